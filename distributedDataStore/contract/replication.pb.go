@@ -0,0 +1,397 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        v3.21.12
+// source: contract/replication.proto
+
+package contract
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ReplicationFrame_Kind int32
+
+const (
+	// RECORD carries a log entry to apply
+	ReplicationFrame_RECORD ReplicationFrame_Kind = 0
+	// RESET starts a full sync, the follower discards its state and the
+	// following RECORD frames up to SYNCED are a snapshot of the leader
+	ReplicationFrame_RESET ReplicationFrame_Kind = 1
+	// SYNCED ends a full sync at sequence number seq
+	ReplicationFrame_SYNCED ReplicationFrame_Kind = 2
+	// HEARTBEAT is sent while there are no records to stream, so an idle
+	// follower still learns it's caught up
+	ReplicationFrame_HEARTBEAT ReplicationFrame_Kind = 3
+)
+
+// Enum value maps for ReplicationFrame_Kind.
+var (
+	ReplicationFrame_Kind_name = map[int32]string{
+		0: "RECORD",
+		1: "RESET",
+		2: "SYNCED",
+		3: "HEARTBEAT",
+	}
+	ReplicationFrame_Kind_value = map[string]int32{
+		"RECORD":    0,
+		"RESET":     1,
+		"SYNCED":    2,
+		"HEARTBEAT": 3,
+	}
+)
+
+func (x ReplicationFrame_Kind) Enum() *ReplicationFrame_Kind {
+	p := new(ReplicationFrame_Kind)
+	*p = x
+	return p
+}
+
+func (x ReplicationFrame_Kind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ReplicationFrame_Kind) Descriptor() protoreflect.EnumDescriptor {
+	return file_contract_replication_proto_enumTypes[0].Descriptor()
+}
+
+func (ReplicationFrame_Kind) Type() protoreflect.EnumType {
+	return &file_contract_replication_proto_enumTypes[0]
+}
+
+func (x ReplicationFrame_Kind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ReplicationFrame_Kind.Descriptor instead.
+func (ReplicationFrame_Kind) EnumDescriptor() ([]byte, []int) {
+	return file_contract_replication_proto_rawDescGZIP(), []int{1, 0}
+}
+
+// FollowRequest is sent by a follower when it connects to the leader.
+type FollowRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// run_id identifies the leader process the follower last synced from
+	RunId string `protobuf:"bytes,1,opt,name=run_id,json=runId,proto3" json:"run_id,omitempty"`
+	// offset is the sequence number of the last record the follower applied
+	Offset uint64 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+}
+
+func (x *FollowRequest) Reset() {
+	*x = FollowRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_contract_replication_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FollowRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FollowRequest) ProtoMessage() {}
+
+func (x *FollowRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_contract_replication_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FollowRequest.ProtoReflect.Descriptor instead.
+func (*FollowRequest) Descriptor() ([]byte, []int) {
+	return file_contract_replication_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *FollowRequest) GetRunId() string {
+	if x != nil {
+		return x.RunId
+	}
+	return ""
+}
+
+func (x *FollowRequest) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+// ReplicationFrame is streamed from the leader to its followers.
+type ReplicationFrame struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Kind  ReplicationFrame_Kind `protobuf:"varint,1,opt,name=kind,proto3,enum=contract.ReplicationFrame_Kind" json:"kind,omitempty"`
+	RunId string                `protobuf:"bytes,2,opt,name=run_id,json=runId,proto3" json:"run_id,omitempty"`
+	Seq   uint64                `protobuf:"varint,3,opt,name=seq,proto3" json:"seq,omitempty"`
+	Entry *LogEntry             `protobuf:"bytes,4,opt,name=entry,proto3" json:"entry,omitempty"`
+	// leader_seq is the sequence number of the last record the leader had
+	// written when it sent the frame
+	LeaderSeq uint64 `protobuf:"varint,5,opt,name=leader_seq,json=leaderSeq,proto3" json:"leader_seq,omitempty"`
+}
+
+func (x *ReplicationFrame) Reset() {
+	*x = ReplicationFrame{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_contract_replication_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReplicationFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationFrame) ProtoMessage() {}
+
+func (x *ReplicationFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_contract_replication_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationFrame.ProtoReflect.Descriptor instead.
+func (*ReplicationFrame) Descriptor() ([]byte, []int) {
+	return file_contract_replication_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReplicationFrame) GetKind() ReplicationFrame_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return ReplicationFrame_RECORD
+}
+
+func (x *ReplicationFrame) GetRunId() string {
+	if x != nil {
+		return x.RunId
+	}
+	return ""
+}
+
+func (x *ReplicationFrame) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *ReplicationFrame) GetEntry() *LogEntry {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+func (x *ReplicationFrame) GetLeaderSeq() uint64 {
+	if x != nil {
+		return x.LeaderSeq
+	}
+	return 0
+}
+
+// ReplicationAck is sent by a follower once it applied every record up to seq.
+type ReplicationAck struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Seq uint64 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+}
+
+func (x *ReplicationAck) Reset() {
+	*x = ReplicationAck{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_contract_replication_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReplicationAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationAck) ProtoMessage() {}
+
+func (x *ReplicationAck) ProtoReflect() protoreflect.Message {
+	mi := &file_contract_replication_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationAck.ProtoReflect.Descriptor instead.
+func (*ReplicationAck) Descriptor() ([]byte, []int) {
+	return file_contract_replication_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReplicationAck) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+var File_contract_replication_proto protoreflect.FileDescriptor
+
+var file_contract_replication_proto_rawDesc = []byte{
+	0x0a, 0x1a, 0x63, 0x6f, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x74, 0x2f, 0x72, 0x65, 0x70, 0x6c, 0x69,
+	0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x63, 0x6f,
+	0x6e, 0x74, 0x72, 0x61, 0x63, 0x74, 0x1a, 0x12, 0x63, 0x6f, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x74,
+	0x2f, 0x6c, 0x6f, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x3e, 0x0a, 0x0d, 0x46, 0x6f,
+	0x6c, 0x6c, 0x6f, 0x77, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x15, 0x0a, 0x06, 0x72,
+	0x75, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x72, 0x75, 0x6e,
+	0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x22, 0xf3, 0x01, 0x0a, 0x10, 0x52,
+	0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x12,
+	0x33, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1f, 0x2e,
+	0x63, 0x6f, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x74, 0x2e, 0x52, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x2e, 0x4b, 0x69, 0x6e, 0x64, 0x52, 0x04,
+	0x6b, 0x69, 0x6e, 0x64, 0x12, 0x15, 0x0a, 0x06, 0x72, 0x75, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x72, 0x75, 0x6e, 0x49, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x73,
+	0x65, 0x71, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12, 0x28, 0x0a,
+	0x05, 0x65, 0x6e, 0x74, 0x72, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x63,
+	0x6f, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x74, 0x2e, 0x4c, 0x6f, 0x67, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x52, 0x05, 0x65, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x65, 0x61, 0x64, 0x65,
+	0x72, 0x5f, 0x73, 0x65, 0x71, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x6c, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x53, 0x65, 0x71, 0x22, 0x38, 0x0a, 0x04, 0x4b, 0x69, 0x6e, 0x64, 0x12, 0x0a,
+	0x0a, 0x06, 0x52, 0x45, 0x43, 0x4f, 0x52, 0x44, 0x10, 0x00, 0x12, 0x09, 0x0a, 0x05, 0x52, 0x45,
+	0x53, 0x45, 0x54, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x59, 0x4e, 0x43, 0x45, 0x44, 0x10,
+	0x02, 0x12, 0x0d, 0x0a, 0x09, 0x48, 0x45, 0x41, 0x52, 0x54, 0x42, 0x45, 0x41, 0x54, 0x10, 0x03,
+	0x22, 0x22, 0x0a, 0x0e, 0x52, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x41,
+	0x63, 0x6b, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x03, 0x73, 0x65, 0x71, 0x42, 0x30, 0x5a, 0x2e, 0x70, 0x65, 0x72, 0x73, 0x6f, 0x6e, 0x61, 0x6c,
+	0x4d, 0x6f, 0x6e, 0x6f, 0x72, 0x65, 0x70, 0x6f, 0x2f, 0x64, 0x69, 0x73, 0x74, 0x72, 0x69, 0x62,
+	0x75, 0x74, 0x65, 0x64, 0x44, 0x61, 0x74, 0x61, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x2f, 0x63, 0x6f,
+	0x6e, 0x74, 0x72, 0x61, 0x63, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_contract_replication_proto_rawDescOnce sync.Once
+	file_contract_replication_proto_rawDescData = file_contract_replication_proto_rawDesc
+)
+
+func file_contract_replication_proto_rawDescGZIP() []byte {
+	file_contract_replication_proto_rawDescOnce.Do(func() {
+		file_contract_replication_proto_rawDescData = protoimpl.X.CompressGZIP(file_contract_replication_proto_rawDescData)
+	})
+	return file_contract_replication_proto_rawDescData
+}
+
+var file_contract_replication_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_contract_replication_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_contract_replication_proto_goTypes = []interface{}{
+	(ReplicationFrame_Kind)(0), // 0: contract.ReplicationFrame.Kind
+	(*FollowRequest)(nil),      // 1: contract.FollowRequest
+	(*ReplicationFrame)(nil),   // 2: contract.ReplicationFrame
+	(*ReplicationAck)(nil),     // 3: contract.ReplicationAck
+	(*LogEntry)(nil),           // 4: contract.LogEntry
+}
+var file_contract_replication_proto_depIdxs = []int32{
+	0, // 0: contract.ReplicationFrame.kind:type_name -> contract.ReplicationFrame.Kind
+	4, // 1: contract.ReplicationFrame.entry:type_name -> contract.LogEntry
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_contract_replication_proto_init() }
+func file_contract_replication_proto_init() {
+	if File_contract_replication_proto != nil {
+		return
+	}
+	file_contract_log_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_contract_replication_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FollowRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_contract_replication_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReplicationFrame); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_contract_replication_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReplicationAck); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_contract_replication_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_contract_replication_proto_goTypes,
+		DependencyIndexes: file_contract_replication_proto_depIdxs,
+		EnumInfos:         file_contract_replication_proto_enumTypes,
+		MessageInfos:      file_contract_replication_proto_msgTypes,
+	}.Build()
+	File_contract_replication_proto = out.File
+	file_contract_replication_proto_rawDesc = nil
+	file_contract_replication_proto_goTypes = nil
+	file_contract_replication_proto_depIdxs = nil
+}
@@ -1,327 +1,251 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
+	"net/http"
 	"os"
-	"personalMonorepo/distributedDataStore/contract"
-	"sync"
+	"os/signal"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/store"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
 )
 
-type Database struct {
-	data        map[string][]byte
-	writeAhead  []*contract.LogEntry
-	logFile     string
-	logFileLock sync.Mutex
-	logFilePtr  *os.File
-	logFileSize int64
-	rotateSize  int64
-}
+const usage = `Usage: ddstore [flags] <command> [args]
 
-const (
-	INSERT = iota
-	UPDATE
-	DELETE
-)
+Commands:
+  set <key> <value>  set key to value
+  get <key>          print the value of key
+  delete <key>       delete key
+  scan <prefix>      print the keys starting with prefix and their values
+  replay             replay the log and print what was replayed
+  dump <file>        write every key to file in the dump format, - for stdout
+  load <file>        load the keys of a dump written by dump, - for stdin
+  serve              serve the APIs enabled by the flags until interrupted
 
-func NewDatabase(logFile string, rotateSize int64) *Database {
-	return &Database{
-		data:        make(map[string][]byte),
-		writeAhead:  make([]*contract.LogEntry, 0),
-		logFile:     logFile,
-		logFileSize: 0,
-		rotateSize:  rotateSize,
-	}
-}
+Every command replays the log first and flushes it before exiting.
 
-func (db *Database) OpenLogFile() error {
-	file, err := os.OpenFile(db.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return err
-	}
+Flags:
+`
 
-	db.logFilePtr = file
-	return nil
+// command is a subcommand of the CLI, run against a replayed database
+type command struct {
+	args int
+	run  func(db *store.Database, args []string, stats store.ReplayStats) error
 }
 
-func (db *Database) CloseLogFile() error {
-	if db.logFilePtr == nil {
+var commands = map[string]command{
+	"set": {args: 2, run: func(db *store.Database, args []string, _ store.ReplayStats) error {
+		return db.MustSet(args[0], []byte(args[1]))
+	}},
+	"get": {args: 1, run: func(db *store.Database, args []string, _ store.ReplayStats) error {
+		value, err := db.Get(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(value))
 		return nil
-	}
-
-	err := db.logFilePtr.Close()
-	if err != nil {
+	}},
+	"delete": {args: 1, run: func(db *store.Database, args []string, _ store.ReplayStats) error {
+		// Deleting a missing key succeeds, like it does over HTTP
+		_, err := db.Delete(args[0])
 		return err
-	}
-
-	db.logFilePtr = nil
-	return nil
-}
-
-func (db *Database) Set(key string, value []byte) error {
-	db.logFileLock.Lock()
-	defer db.logFileLock.Unlock()
-
-	// Check if key exists
-	val, ok := db.data[key]
-
-	var logEntry *contract.LogEntry
-	if ok && !bytes.Equal(val, value) {
-		logEntry = &contract.LogEntry{
-			Op:    UPDATE,
-			Key:   key,
-			Value: value,
+	}},
+	"scan": {args: 1, run: func(db *store.Database, args []string, _ store.ReplayStats) error {
+		entries, err := db.Scan(args[0])
+		if err != nil {
+			return err
 		}
-	} else if !ok {
-		logEntry = &contract.LogEntry{
-			Op:    INSERT,
-			Key:   key,
-			Value: value,
+		for _, kv := range entries {
+			fmt.Printf("%s\t%s\n", kv.Key, kv.Value)
 		}
-	} else {
-		// Value is the same, we don't want to append log or update in-memory database
 		return nil
-	}
-	// Create log entry
-
-	db.writeAhead = append(db.writeAhead, logEntry)
-
-	// Update in-memory database
-	db.data[key] = value
-
-	// Write log entry to log file
-	if db.logFilePtr != nil {
-		logData, err := proto.Marshal(logEntry)
-		if err != nil {
+	}},
+	"replay": {args: 0, run: func(db *store.Database, _ []string, stats store.ReplayStats) error {
+		fmt.Printf("Replayed %d records (%d bytes) in %s, %d inserts, %d updates, %d deletes, %d corrupt records skipped, %d keys\n",
+			stats.Records, stats.Bytes, stats.Elapsed, stats.Inserts, stats.Updates, stats.Deletes,
+			stats.SkippedCorrupt, db.Len())
+		return nil
+	}},
+	"dump": {args: 1, run: func(db *store.Database, args []string, _ store.ReplayStats) error {
+		if args[0] == "-" {
+			_, err := db.Dump(os.Stdout)
 			return err
 		}
-
-		buf := make([]byte, 4)
-		binary.LittleEndian.PutUint32(buf, uint32(len(logData)))
-
-		_, err = db.logFilePtr.Write(buf)
+		file, err := os.Create(args[0])
 		if err != nil {
 			return err
 		}
-
-		_, err = db.logFilePtr.Write(logData)
-		if err != nil {
-			return err
+		_, err = db.Dump(file)
+		if err == nil {
+			err = file.Sync()
+		}
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		return err
+	}},
+	"load": {args: 1, run: func(db *store.Database, args []string, _ store.ReplayStats) error {
+		file := os.Stdin
+		if args[0] != "-" {
+			var err error
+			file, err = os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
 		}
+		n, err := db.Load(file)
+		fmt.Printf("Loaded %d keys\n", n)
+		return err
+	}},
+	"serve": {args: 0, run: func(db *store.Database, _ []string, _ store.ReplayStats) error {
+		return serve(db)
+	}},
+}
 
-		// Update log file size
-		db.logFileSize += int64(len(logData) + 4)
+var (
+	logPath         = flag.String("log", "database.bin", "path of the log file, rotated segments are kept next to it")
+	rotateSize      = flag.Int64("rotate-size", 64<<20, "size in bytes past which the log file is rotated")
+	grpcAddr        = flag.String("grpc-addr", "", "serve: address to serve the gRPC API on, disabled if empty")
+	httpAddr        = flag.String("http-addr", "", "serve: address to serve the HTTP API on, disabled if empty")
+	replicationAddr = flag.String("replication-addr", "", "serve: address to accept followers on, disabled if empty")
+	leaderAddr      = flag.String("follow", "", "serve: replication address of a leader to follow, disabled if empty")
+)
 
-		// Check if log file size exceeds the rotate threshold
-		if db.logFileSize >= db.rotateSize {
-			db.rotateLogFile()
-		}
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	return nil
-}
-
-func (db *Database) rotateLogFile() {
-	err := db.CloseLogFile()
-	if err != nil {
-		return
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name, args := flag.Arg(0), flag.Args()[1:]
+	cmd, ok := commands[name]
+	if !ok || len(args) != cmd.args {
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	// Rename the current log file
-	timestamp := time.Now().Format("20060102_150405")
-	rotatedFile := fmt.Sprintf("%s_%s", db.logFile, timestamp)
-	err = os.Rename(db.logFile, rotatedFile)
+	// Only serve logs below warnings, so one-shot commands can be scripted
+	config := zap.NewProductionConfig()
+	if name != "serve" {
+		config.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	}
+	logger, err := config.Build()
 	if err != nil {
 		log.Fatal(err)
 	}
+	undo := zap.ReplaceGlobals(logger)
 
-	// Open a new log file
-	err = db.OpenLogFile()
+	err = run(logger, cmd, args)
+	undo()
+	_ = logger.Sync()
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, "ddstore:", err)
+		os.Exit(1)
 	}
-
-	// Reset log file size
-	db.logFileSize = 0
 }
 
-func (db *Database) Get(key string) ([]byte, error) {
-	value, ok := db.data[key]
-	if !ok {
-		return nil, fmt.Errorf("key not found")
+// run opens and replays the database at the log path, runs cmd against it
+// and closes it, which flushes the log
+func run(logger *zap.Logger, cmd command, args []string) (err error) {
+	dir, file := filepath.Split(*logPath)
+	if !strings.HasSuffix(file, ".bin") || file == ".bin" {
+		return fmt.Errorf("log file %s must be named <name>.bin", *logPath)
+	}
+	if dir == "" {
+		dir = "."
 	}
-	return value, nil
-}
-
-func (db *Database) Delete(key string) {
-	db.logFileLock.Lock()
-	defer db.logFileLock.Unlock()
-
-	delete(db.data, key)
-}
-
-func (db *Database) ReplayWriteAheadLog() error {
-	sugar := zap.L().Sugar()
-	sugar.Infof("Replaying write-ahead log")
 
-	db.logFileLock.Lock()
-	defer db.logFileLock.Unlock()
+	db := store.NewDatabase(dir, strings.TrimSuffix(file, ".bin"), *rotateSize, store.WithLogger(logger))
 
-	file, err := os.Open(db.logFile)
+	// Open the write-ahead log file
+	err = db.OpenLogFile()
 	if err != nil {
 		return err
 	}
-
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			sugar.Fatal(err)
+	defer func(db *store.Database) {
+		closeErr := db.Close()
+		if err == nil {
+			err = closeErr
 		}
-	}(file)
+	}(db)
 
-	var offset int64
+	// Replay the write-ahead log
+	stats, err := db.ReplayWriteAheadLog(nil)
+	if err != nil {
+		return err
+	}
 
-	for {
-		// reading the length of the encoded item before reading each item
-		buf := make([]byte, 4)
-		if _, err := file.ReadAt(buf, offset); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
+	return cmd.run(db, args, stats)
+}
 
-		itemSize := binary.LittleEndian.Uint32(buf)
-		offset += 4
+// serve serves the APIs enabled by the flags until the process is
+// interrupted, periodically flushing the log
+func serve(db *store.Database) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// reading the actual encoded item
-		item := make([]byte, itemSize)
-		if _, err := file.ReadAt(item, offset); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-
-		entry := &contract.LogEntry{}
-		err := proto.Unmarshal(item, entry)
+	if *replicationAddr != "" {
+		leader, err := store.ServeReplication(db, *replicationAddr, 0)
 		if err != nil {
 			return err
 		}
-
-		db.data[entry.Key] = entry.Value
-		offset += int64(itemSize)
+		defer func(leader *store.ReplicationLeader) {
+			_ = leader.Close()
+		}(leader)
 	}
 
-	return nil
-}
-
-func main() {
-	logger, err := zap.NewProduction()
-	if err != nil {
-		log.Fatal(err)
+	if *leaderAddr != "" {
+		follower := store.FollowLeader(db, *leaderAddr)
+		defer func(follower *store.ReplicationFollower) {
+			_ = follower.Close()
+		}(follower)
 	}
-	defer func(logger *zap.Logger) {
-		_ = logger.Sync()
-	}(logger)
 
-	undo := zap.ReplaceGlobals(logger)
-	defer undo()
-
-	sugar := logger.Sugar()
-
-	logFile := "database.bin"
-	db := NewDatabase(logFile, 32)
-
-	// Open the write-ahead log file
-	err = db.OpenLogFile()
-	if err != nil {
-		sugar.Fatal(err)
-	}
-	defer func(db *Database) {
-		err := db.CloseLogFile()
+	if *grpcAddr != "" {
+		server, _, err := store.StartGRPCServer(db, *grpcAddr)
 		if err != nil {
-			sugar.Fatal(err)
+			return err
 		}
-	}(db)
-
-	// Replay the write-ahead log
-	err = db.ReplayWriteAheadLog()
-	if err != nil {
-		sugar.Fatal(err)
+		defer server.GracefulStop()
 	}
 
-	// Set values in the database
-	err = db.Set("name", []byte("John"))
-	if err != nil {
-		sugar.Fatal(err)
-	}
-
-	err = db.Set("age", []byte("30"))
-	if err != nil {
-		sugar.Fatal(err)
-	}
-
-	err = db.Set("city", []byte("New York"))
-	if err != nil {
-		sugar.Fatal(err)
+	if *httpAddr != "" {
+		server, _, err := store.StartHTTPServer(db, *httpAddr)
+		if err != nil {
+			return err
+		}
+		defer func(server *http.Server) {
+			_ = server.Close()
+		}(server)
 	}
 
-	// Get values from the database
-	name, _ := db.Get("name")
-	age, _ := db.Get("age")
-	city, _ := db.Get("city")
-
-	fmt.Println("Name:", string(name))
-	fmt.Println("Age:", string(age))
-	fmt.Println("City:", string(city))
-
-	// Delete a key from the database
-	db.Delete("age")
-
 	// Periodically flush the write-ahead log to disk
 	ticker := time.NewTicker(5 * time.Second)
-	go func() {
-		for range ticker.C {
-			db.logFileLock.Lock()
-
-			logData := make([]byte, 0)
-			for _, entry := range db.writeAhead {
-				entryData, err := proto.Marshal(entry)
-				if err != nil {
-					sugar.Fatal(err)
-				}
-				logData = append(logData, entryData...)
-			}
-
-			// Write log data to the log file
-			_, err := db.logFilePtr.Write(logData)
-			if err != nil {
-				sugar.Fatal(err)
-			}
-
-			err = db.logFilePtr.Sync()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			zap.S().Infof("Shutting down")
+			return nil
+		case <-ticker.C:
+			err := db.Flush()
 			if err != nil {
-				sugar.Fatal(err)
-				return
+				return err
 			}
-
-			db.logFileLock.Unlock()
 		}
-	}()
-
-	// Wait for a key press to exit the program
-	fmt.Println("\nPress Enter to exit...")
-	_, err = fmt.Scanln()
-	if err != nil {
-		sugar.Fatal(err)
 	}
-	ticker.Stop()
 }
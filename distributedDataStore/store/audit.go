@@ -0,0 +1,186 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// WithAuditLog keeps an audit trail of every record logged in the file at
+// path. Unlike the log, which compaction, retention and Truncate rewrite or
+// remove, the trail is only ever appended to. Every entry holds the hash of the
+// one before it, so changing or removing an entry breaks the chain from there
+// on, which VerifyAudit detects. Cutting entries off the end does leave an
+// intact chain.
+//
+// An entry is written once its record made it to the log, and synced along
+// with it. Values are stored the way they're logged, compressed and encrypted
+// if the log is. It needs the log file, OpenLogFile opens the trail too.
+func WithAuditLog(path string) Option {
+	return func(db *Database) {
+		db.auditPath = path
+	}
+}
+
+// auditFormatVersion is the record framing of the audit trail. The trail has no
+// header to record it in, so it stays the framing it was first written with.
+const auditFormatVersion = flagsFormatVersion
+
+// auditLog appends entries to the audit trail, each framed like a log record
+// around the hash of the previous entry and a record payload.
+type auditLog struct {
+	path string
+	file *os.File
+	size int64
+	// head is the hash of the last entry, the next one links to it
+	head [sha256.Size]byte
+
+	logger *zap.Logger
+}
+
+// openAuditLog opens the audit trail at path for appending, creating it if
+// needed. An entry a crash cut short at the end of the file is dropped.
+func openAuditLog(path string, logger *zap.Logger) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	a := &auditLog{path: path, file: file, size: info.Size(), logger: logger}
+	end, err := readAudit(file, a.size, func(offset int64, entry []byte) error {
+		a.head = sha256.Sum256(entry)
+		return nil
+	})
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		logger.Sugar().Warnf("Dropping the entry a crash cut short at offset %d of audit log %s", end, path)
+		err = file.Truncate(end)
+		a.size = end
+	}
+	if err != nil && !errors.Is(err, ErrCorruptRecord) {
+		_ = file.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// readAudit calls fn with the offset and contents of every entry up to size,
+// and returns the offset it stopped at. A checksum failure stops it with
+// ErrCorruptRecord and an entry cut short with io.ErrUnexpectedEOF, the offset
+// being that of the entry either way.
+func readAudit(r io.ReaderAt, size int64, fn func(offset int64, entry []byte) error) (int64, error) {
+	offset := int64(0)
+	for offset < size {
+		entry, next, err := readRecord(r, offset, size, auditFormatVersion)
+		if err == io.EOF || next > size {
+			return offset, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		err = fn(offset, entry)
+		if err != nil {
+			return offset, err
+		}
+		offset = next
+	}
+	return offset, nil
+}
+
+// append adds an entry for each payload, all of them or none, and syncs them
+// if sync is set.
+func (a *auditLog) append(payloads [][]byte, sync bool) error {
+	var buf bytes.Buffer
+	head := a.head
+	for _, payload := range payloads {
+		entry := make([]byte, 0, len(head)+len(payload))
+		entry = append(append(entry, head[:]...), payload...)
+		head = sha256.Sum256(entry)
+		buf.Write(encodeRecord(auditFormatVersion, entry))
+	}
+
+	_, err := a.file.Write(buf.Bytes())
+	if err == nil && sync {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		// Drop whatever made it, the chain goes on from the last entry
+		if truncErr := a.file.Truncate(a.size); truncErr != nil {
+			a.logger.Sugar().Warnf("Failed to drop a partial write from audit log %s: %v", a.path, truncErr)
+		}
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+
+	a.size += int64(buf.Len())
+	a.head = head
+	return nil
+}
+
+func (a *auditLog) sync() error {
+	return a.file.Sync()
+}
+
+func (a *auditLog) close() error {
+	return a.file.Close()
+}
+
+// VerifyAudit walks the hash chain of the audit trail set with WithAuditLog and
+// returns an error wrapping ErrAuditBroken for the first entry that fails its
+// checksum, doesn't hold the hash of the entry before it or is cut short, nil
+// if the chain is intact. It only reads the file, so it works on read-only
+// databases and while writes go on.
+func (db *Database) VerifyAudit() error {
+	if db.auditPath == "" {
+		return errors.New("no audit log, see WithAuditLog")
+	}
+
+	// Only check the entries written so far, later ones may still be on
+	// their way into the file
+	db.logFileLock.Lock()
+	size := int64(-1)
+	if db.audit != nil {
+		size = db.audit.size
+	}
+	db.logFileLock.Unlock()
+
+	file, err := os.Open(db.auditPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if size < 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		size = info.Size()
+	}
+
+	var head [sha256.Size]byte
+	n := 0
+	offset, err := readAudit(file, size, func(offset int64, entry []byte) error {
+		if len(entry) < len(head) || !bytes.Equal(entry[:len(head)], head[:]) {
+			return fmt.Errorf("%w at entry %d, offset %d: it doesn't link to the entry before it", ErrAuditBroken, n, offset)
+		}
+		head = sha256.Sum256(entry)
+		n++
+		return nil
+	})
+	switch {
+	case errors.Is(err, ErrCorruptRecord):
+		return fmt.Errorf("%w at entry %d, offset %d: checksum mismatch", ErrAuditBroken, n, offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w at entry %d, offset %d: it's cut short", ErrAuditBroken, n, offset)
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package store
+
+import "fmt"
+
+// BufferPolicy decides what a write does when the records waiting for a
+// flush have reached the limit set with WithMaxBuffered.
+type BufferPolicy int
+
+const (
+	// BufferBlock makes the write flush the log itself, so it waits for the
+	// buffered records to be synced before going ahead.
+	BufferBlock BufferPolicy = iota
+	// BufferFail fails the write with ErrBufferFull without writing anything,
+	// leaving it to the caller to back off and retry.
+	BufferFail
+)
+
+// WithMaxBuffered bounds the number of records written since the last flush,
+// so writes outpacing the periodic flush can't grow the buffer without limit.
+// The policy picks between blocking and failing writes that would go over it.
+// Zero, the default, means no bound. It doesn't apply to SyncOnCommit and
+// SyncGroupCommit, which flush every write anyway.
+func WithMaxBuffered(records int, policy BufferPolicy) Option {
+	return func(db *Database) {
+		db.maxBuffered = records
+		db.bufferPolicy = policy
+	}
+}
+
+// reserveBuffer makes room for n more records in the flush buffer, according
+// to the buffer policy. A write bigger than the whole buffer still goes
+// through once the buffer is empty. Callers must hold logFileLock.
+func (db *Database) reserveBuffer(n int) error {
+	if db.maxBuffered == 0 || db.syncMode == SyncOnCommit || len(db.writeAhead) == 0 ||
+		len(db.writeAhead)+n <= db.maxBuffered {
+		return nil
+	}
+
+	if db.bufferPolicy == BufferFail {
+		return fmt.Errorf("%w: %d records waiting for a flush", ErrBufferFull, len(db.writeAhead))
+	}
+	return db.flush()
+}
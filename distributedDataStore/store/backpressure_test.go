@@ -0,0 +1,74 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBufferFailRejectsWritesOverTheBound(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir(), WithMaxBuffered(3, BufferFail))
+	for i := 0; i < 3; i++ {
+		mustSet(t, db, fmt.Sprintf("key-%d", i), "value")
+	}
+
+	_, err := db.Set("over", []byte("value"))
+	if !errors.Is(err, ErrBufferFull) {
+		t.Fatalf("write over the bound returned %v, want ErrBufferFull", err)
+	}
+	if db.Exists("over") {
+		t.Fatal("rejected write was applied")
+	}
+
+	err = db.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, db, "over", "value")
+}
+
+func TestBufferBlockFlushesBeforeGoingOver(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir(), WithMaxBuffered(3, BufferBlock))
+	for i := 0; i < 3; i++ {
+		mustSet(t, db, fmt.Sprintf("key-%d", i), "value")
+	}
+	if _, err := db.Durable("key-0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Durable found key-0 before any flush: %v", err)
+	}
+
+	// The write waits for the three before it to be synced
+	mustSet(t, db, "over", "value")
+	for i := 0; i < 3; i++ {
+		_, err := db.Durable(fmt.Sprintf("key-%d", i))
+		if err != nil {
+			t.Fatalf("key-%d isn't durable after the write over the bound: %v", i, err)
+		}
+	}
+	db.logFileLock.Lock()
+	buffered := len(db.writeAhead)
+	db.logFileLock.Unlock()
+	if buffered != 1 {
+		t.Fatalf("%d records buffered, want the one over the bound", buffered)
+	}
+}
+
+func TestBufferBoundLetsLargeBatchesThroughOnceEmpty(t *testing.T) {
+	for _, policy := range []BufferPolicy{BufferBlock, BufferFail} {
+		db := openTestDatabase(t, t.TempDir(), WithMaxBuffered(2, policy))
+		b := db.Batch()
+		for i := 0; i < 5; i++ {
+			b.Set(fmt.Sprintf("key-%d", i), []byte("value"))
+		}
+		err := b.Commit()
+		if err != nil {
+			t.Fatalf("policy %d: batch bigger than the buffer failed on an empty buffer: %v", policy, err)
+		}
+	}
+}
+
+func TestBufferBoundIgnoredUnderSyncOnCommit(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir(), WithSyncMode(SyncOnCommit), WithMaxBuffered(1, BufferFail))
+	for i := 0; i < 5; i++ {
+		mustSet(t, db, fmt.Sprintf("key-%d", i), "value")
+	}
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"bytes"
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// WriteBatch accumulates writes that are committed to the database as a unit.
+// Recovery either applies all of a committed batch or none of it.
+type WriteBatch struct {
+	db      *Database
+	entries []*contract.LogEntry
+}
+
+// Batch starts a new empty WriteBatch on the database.
+func (db *Database) Batch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// Set stages setting key to value.
+func (b *WriteBatch) Set(key string, value []byte) {
+	b.entries = append(b.entries, &contract.LogEntry{
+		Op:    INSERT,
+		Key:   key,
+		Value: value,
+	})
+}
+
+// Delete stages deleting key.
+func (b *WriteBatch) Delete(key string) {
+	b.entries = append(b.entries, &contract.LogEntry{
+		Op:  DELETE,
+		Key: key,
+	})
+}
+
+// Len returns the number of staged writes.
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Commit writes the staged records to the log, framed by begin and commit
+// markers, with the shards of every key involved locked and with at most one
+// fsync, then applies them to the in-memory database. The batch is empty
+// afterwards and can be reused.
+func (b *WriteBatch) Commit() error {
+	db := b.db
+
+	if len(b.entries) == 0 {
+		return nil
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	err := db.admitEntries(b.entries)
+	if err != nil {
+		return err
+	}
+	defer db.exitWrite()
+
+	keys := make([]string, 0, len(b.entries))
+	for _, entry := range b.entries {
+		err = db.checkKey(entry.Key)
+		if err == nil && entry.Op != DELETE {
+			err = db.checkLimits(entry.Key, entry.Value)
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, entry.Key)
+	}
+	shards := db.shardsOf(keys)
+	lockShards(shards)
+	if db.closed {
+		unlockShards(shards)
+		return ErrClosed
+	}
+
+	entries := b.entries
+	b.entries = nil
+	err = db.commitBatchLocked(entries)
+	leader, seq := db.replicationPosition()
+	unlockShards(shards)
+	if err != nil {
+		return err
+	}
+
+	// Wait for followers outside the locks, like Set
+	return leader.awaitAcks(seq)
+}
+
+// commitBatchLocked logs and applies the staged writes of a batch. Callers
+// must hold the write locks of the shards of every key involved.
+func (db *Database) commitBatchLocked(entries []*contract.LogEntry) error {
+	// Resolve each staged write against the current state, including the
+	// writes staged before it, the same way Set and Delete would
+	staged := make(map[string][]byte)
+	present := make(map[string]bool)
+	lookup := func(key string) ([]byte, bool) {
+		if ok, seen := present[key]; seen {
+			return staged[key], ok
+		}
+		return db.shardFor(key).lookup(key)
+	}
+
+	logEntries := []*contract.LogEntry{{Op: BATCH_BEGIN}}
+	for _, entry := range entries {
+		val, ok := lookup(entry.Key)
+
+		switch entry.Op {
+		case DELETE:
+			if !ok {
+				continue
+			}
+			present[entry.Key] = false
+			delete(staged, entry.Key)
+			logEntries = append(logEntries, &contract.LogEntry{
+				Op:        DELETE,
+				Key:       entry.Key,
+				Timestamp: db.writeTimestamp(),
+			})
+		default:
+			_, restaged := present[entry.Key]
+			if ok && !db.noDedup && bytes.Equal(val, entry.Value) && (restaged || db.shardFor(entry.Key).unadorned(entry.Key)) {
+				continue
+			}
+			op := uint32(INSERT)
+			if ok {
+				op = UPDATE
+			}
+			present[entry.Key] = true
+			staged[entry.Key] = entry.Value
+			logEntries = append(logEntries, &contract.LogEntry{
+				Op:        op,
+				Key:       entry.Key,
+				Value:     entry.Value,
+				Version:   db.versionSeq.Add(1),
+				Timestamp: db.writeTimestamp(),
+			})
+		}
+	}
+
+	if len(logEntries) == 1 {
+		// Nothing changes, we don't want to append empty batches to the log
+		return nil
+	}
+	logEntries = append(logEntries, &contract.LogEntry{Op: BATCH_COMMIT})
+
+	err := db.writeLogEntries(logEntries...)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range logEntries[1 : len(logEntries)-1] {
+		err = db.applyLogEntry(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
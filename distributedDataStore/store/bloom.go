@@ -0,0 +1,93 @@
+package store
+
+// bloomBitsPerKey and bloomHashes size a filter for a false positive rate of
+// about 1%.
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	// bloomMinKeys is the smallest number of keys a filter is sized for, so an
+	// empty shard doesn't have to grow on its first few writes
+	bloomMinKeys = 1024
+)
+
+// bloomFilter answers whether a key may be in a shard, with no false
+// negatives. Keys can't be removed from it, so deleted keys keep reading as
+// maybe present until the filter is rebuilt.
+type bloomFilter struct {
+	bits []uint64
+	// capacity is the number of keys the filter was sized for
+	capacity int
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	if keys < bloomMinKeys {
+		keys = bloomMinKeys
+	}
+	return &bloomFilter{
+		bits:     make([]uint64, (keys*bloomBitsPerKey+63)/64),
+		capacity: keys,
+	}
+}
+
+// add records key in the filter.
+func (b *bloomFilter) add(key string) {
+	h1, h2 := bloomHash(key)
+	n := uint32(len(b.bits) * 64)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain reports whether key may have been added. False means it
+// definitely wasn't.
+func (b *bloomFilter) mayContain(key string) bool {
+	h1, h2 := bloomHash(key)
+	n := uint32(len(b.bits) * 64)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash derives the two hashes the filter probes are built from by
+// splitting a 64-bit FNV-1a hash of key, inlined so lookups don't allocate.
+func bloomHash(key string) (uint32, uint32) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return uint32(h), uint32(h>>32) | 1
+}
+
+// rebuildBloom replaces the filter of the shard with one sized for and holding
+// only its current keys, dropping the bits left behind by deleted keys.
+// Callers must hold the shard lock for writing.
+func (s *shard) rebuildBloom() {
+	s.bloom = newBloomFilter(2 * len(s.keys))
+	for _, key := range s.keys {
+		s.bloom.add(key)
+	}
+}
+
+// rebuildBlooms rebuilds the filter of every shard, locking one shard at a
+// time.
+func (db *Database) rebuildBlooms() {
+	for _, s := range db.shards {
+		s.mu.Lock()
+		s.rebuildBloom()
+		s.mu.Unlock()
+	}
+}
+
+// rebuildBloomsLocked is rebuildBlooms for callers that already hold every
+// shard lock for writing.
+func (db *Database) rebuildBloomsLocked() {
+	for _, s := range db.shards {
+		s.rebuildBloom()
+	}
+}
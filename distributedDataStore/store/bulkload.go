@@ -0,0 +1,254 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// bulkLoadChunk is how many records BulkLoad logs at a time.
+const bulkLoadChunk = 1024
+
+// BulkLoad applies a stream of records in the log format, like a file written
+// by Snapshot, and returns how many it loaded. The records are applied over
+// whatever the database holds, in order, and only INSERT, UPDATE and DELETE
+// records are accepted.
+//
+// Rather than taking the write path of every record, it holds every lock for
+// the whole load, logs the records in chunks and syncs the log once at the
+// end, so it's much faster than a Set per key but blocks other writes and
+// reads meanwhile. Watchers and followers see the records like any other
+// write. On error, the records applied before it stay applied.
+func (db *Database) BulkLoad(r io.Reader) (int, error) {
+	return db.bulkLoad(func(fn func(entry *contract.LogEntry) error) error {
+		return db.readLogStream(r, fn)
+	})
+}
+
+// bulkLoad is BulkLoad for the records read calls its argument with.
+func (db *Database) bulkLoad(read func(fn func(entry *contract.LogEntry) error) error) (loaded int, err error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	if db.partialReplay.Load() && !db.partialAccepted.Load() {
+		return 0, ErrPartialReplay
+	}
+	sugar := db.logger.Sugar()
+
+	err = db.enterWrite(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer db.exitWrite()
+
+	// Deferred before taking the shard locks, so it waits for the followers
+	// once they're released, like Set
+	var leader *ReplicationLeader
+	var seq uint64
+	defer func() {
+		if err == nil {
+			err = leader.awaitAcks(seq)
+		}
+	}()
+
+	lockShards(db.shards)
+	defer unlockShards(db.shards)
+
+	if db.closed {
+		return 0, ErrClosed
+	}
+
+	chunk := make([]*contract.LogEntry, 0, bulkLoadChunk)
+	logChunk := func() error {
+		db.logFileLock.Lock()
+		defer db.logFileLock.Unlock()
+
+		err := db.appendLogFile(chunk...)
+		if err != nil {
+			return err
+		}
+		if db.leader != nil {
+			db.leader.append(chunk)
+			leader, seq = db.leader, db.leader.sequence()
+		}
+
+		for _, entry := range chunk {
+			err = db.applyLogEntry(entry)
+			if err != nil {
+				return err
+			}
+		}
+		loaded += len(chunk)
+		chunk = make([]*contract.LogEntry, 0, bulkLoadChunk)
+		return nil
+	}
+
+	err = read(func(entry *contract.LogEntry) error {
+		chunk = append(chunk, entry)
+		if len(chunk) < bulkLoadChunk {
+			return nil
+		}
+		return logChunk()
+	})
+	if err == nil && len(chunk) > 0 {
+		err = logChunk()
+	}
+	if err != nil {
+		return loaded, err
+	}
+
+	err = db.Flush()
+	if err != nil {
+		return loaded, err
+	}
+
+	sugar.Infof("Bulk loaded %d records", loaded)
+	return loaded, nil
+}
+
+// BulkLoadSegment is BulkLoad that skips the write-ahead log: the stream is
+// written straight to a fresh segment, sealed after the current log and synced
+// before any record is applied, so the database is left untouched if the
+// stream turns out to be unreadable part way. It only works with the
+// FileLogStore. Watchers and followers don't see the records, followers pick
+// them up with the next full snapshot they take.
+func (db *Database) BulkLoadSegment(r io.Reader) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	if db.partialReplay.Load() && !db.partialAccepted.Load() {
+		return 0, ErrPartialReplay
+	}
+	sugar := db.logger.Sugar()
+
+	// The segment must sort after the records already logged and before
+	// any logged from here on, so nothing is written until it's in place
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+	lockShards(db.shards)
+	defer unlockShards(db.shards)
+
+	db.logFileLock.Lock()
+	if db.closed {
+		db.logFileLock.Unlock()
+		return 0, ErrClosed
+	}
+	if _, ok := db.store.(*FileLogStore); !ok {
+		db.logFileLock.Unlock()
+		return 0, ErrNotSupported
+	}
+	segments, err := db.sealLog()
+	db.logFileLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	sealed := segments[len(segments)-2]
+
+	var entries []*contract.LogEntry
+	err = db.readLogStream(r, func(entry *contract.LogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Loaded like a compacted segment, which sorts right after the segment
+	// it's named after
+	path := sealed + "_compacted"
+	err = db.writeSegment(path, entries)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, entry := range entries {
+		err = db.applyLogEntry(entry)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	sugar.Infof("Bulk loaded %d records into %s", len(entries), path)
+	return len(entries), nil
+}
+
+// readLogStream decodes the records of a stream in the log format and calls fn
+// with each, giving it a fresh version. Unlike replay it fails on a corrupt or
+// partial record, a bulk load shouldn't silently skip anything.
+func (db *Database) readLogStream(r io.Reader, fn func(entry *contract.LogEntry) error) error {
+	buf := bufio.NewReaderSize(r, 64<<10)
+
+	magic, err := buf.Peek(len(logMagic) + 2)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	header, offset, err := readLogHeader(bytes.NewReader(magic))
+	if err != nil {
+		return err
+	}
+	_, err = buf.Discard(int(offset))
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, recordPrefixSize(header.version))
+	for {
+		_, err = io.ReadFull(buf, prefix)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading record at offset %d: %w", offset, err)
+		}
+
+		length, err := recordLength(prefix, header.version)
+		if err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		// Grow the payload as it's read rather than trusting the length
+		// with an allocation, the stream may be far shorter
+		var payloadBuf bytes.Buffer
+		_, err = io.CopyN(&payloadBuf, buf, length)
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("reading record at offset %d: %w", offset, err)
+		}
+		payload := payloadBuf.Bytes()
+		if !intactPayload(prefix, payload, header.version) {
+			return fmt.Errorf("record at offset %d: %w", offset, ErrCorruptRecord)
+		}
+
+		entry := &contract.LogEntry{}
+		err = db.decodeLogEntry(header, payload, entry)
+		if err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		if entry.Op != INSERT && entry.Op != UPDATE && entry.Op != DELETE {
+			return fmt.Errorf("%w %d at offset %d, only INSERT, UPDATE and DELETE can be bulk loaded", ErrUnknownOp, entry.Op, offset)
+		}
+		db.restamp(entry)
+
+		err = fn(entry)
+		if err != nil {
+			return err
+		}
+		offset += int64(len(prefix) + len(payload))
+	}
+}
+
+// restamp gives a loaded record a fresh version, and the current time if it
+// was written without one.
+func (db *Database) restamp(entry *contract.LogEntry) {
+	if entry.Op != DELETE {
+		entry.Version = db.versionSeq.Add(1)
+	}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = db.writeTimestamp()
+	}
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// CompareAndSwap sets key to new only if its current value equals old, and
+// reports whether it did. A missing key never matches. The compare and the
+// write happen under the same shard lock, so of two concurrent swaps from the same
+// old value only one succeeds. The key keeps its TTL and metadata, if it has
+// any.
+func (db *Database) CompareAndSwap(key string, old, new []byte) (bool, error) {
+	err := db.checkKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	err = db.admit(context.Background(), 1, len(key)+len(new))
+	if err != nil {
+		return false, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	s.mu.Lock()
+	if db.closed {
+		s.mu.Unlock()
+		return false, ErrClosed
+	}
+
+	val, ok := s.lookup(key)
+	if !ok || !bytes.Equal(val, old) {
+		s.mu.Unlock()
+		return false, nil
+	}
+
+	_, err = db.setLocked(key, new, s.expiry[key], s.meta[key].metadata)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	return true, leader.awaitAcks(seq)
+}
+
+// CompareAndDelete deletes key only if its current value equals old, and
+// reports whether it did.
+func (db *Database) CompareAndDelete(key string, old []byte) (bool, error) {
+	err := db.checkKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	err = db.admit(context.Background(), 1, len(key))
+	if err != nil {
+		return false, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	s.mu.Lock()
+	if db.closed {
+		s.mu.Unlock()
+		return false, ErrClosed
+	}
+
+	val, ok := s.lookup(key)
+	if !ok || !bytes.Equal(val, old) {
+		s.mu.Unlock()
+		return false, nil
+	}
+
+	existed, err := db.deleteLocked(key)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	return existed, leader.awaitAcks(seq)
+}
+
+// SetNX sets key to value only if it's missing, and reports whether it did. An
+// expired key counts as missing. The check and the write happen under the same
+// shard lock, so of two concurrent calls for the same key only one succeeds,
+// which makes it usable as a lock.
+func (db *Database) SetNX(key string, value []byte) (bool, error) {
+	return db.setNX(key, value, 0)
+}
+
+// SetNXWithTTL is SetNX for a key that expires after ttl, so a lock taken with
+// it is released on its own if its holder goes away.
+func (db *Database) SetNXWithTTL(key string, value []byte, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, fmt.Errorf("ttl must be positive, got %s", ttl)
+	}
+
+	return db.setNX(key, value, db.clock.Now().Add(ttl).UnixNano())
+}
+
+func (db *Database) setNX(key string, value []byte, expiresAt int64) (bool, error) {
+	if db.readOnly {
+		return false, ErrReadOnly
+	}
+	err := db.admit(context.Background(), 1, len(key)+len(value))
+	if err != nil {
+		return false, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	s.mu.Lock()
+	if db.closed {
+		s.mu.Unlock()
+		return false, ErrClosed
+	}
+
+	if _, ok := s.lookup(key); ok {
+		s.mu.Unlock()
+		return false, nil
+	}
+
+	_, err = db.setLocked(key, value, expiresAt, nil)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	return true, leader.awaitAcks(seq)
+}
+
+// GetOrSet returns the value of key, or sets it to value and returns that if
+// it's missing, reporting whether it did. The read and the write happen under
+// the same shard lock, so concurrent callers all get the same value back, and
+// only a call that inserts writes to the log.
+func (db *Database) GetOrSet(key string, value []byte) ([]byte, bool, error) {
+	err := db.admit(context.Background(), 1, len(key)+len(value))
+	if err != nil {
+		return nil, false, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	s.mu.Lock()
+	if db.closed {
+		s.mu.Unlock()
+		return nil, false, ErrClosed
+	}
+
+	if existing, ok := s.lookup(key); ok {
+		copied := make([]byte, len(existing))
+		copy(copied, existing)
+		s.mu.Unlock()
+		return copied, false, nil
+	}
+
+	if db.readOnly {
+		s.mu.Unlock()
+		return nil, false, ErrReadOnly
+	}
+	_, err = db.setLocked(key, value, 0, nil)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied, true, leader.awaitAcks(seq)
+}
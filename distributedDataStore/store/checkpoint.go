@@ -0,0 +1,179 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"strconv"
+)
+
+// checkpointPosition is where replay resumes after loading a checkpoint: the
+// records of segment from offset on, and every segment after it.
+type checkpointPosition struct {
+	segment string
+	offset  int64
+}
+
+// checkpointFile returns the path of the checkpoint of the log. It doesn't
+// match the segment pattern, so it's never replayed as part of the log.
+func (db *Database) checkpointFile() string {
+	return db.logFile + ".checkpoint"
+}
+
+// Checkpoint writes a snapshot of the current state together with the log
+// position it corresponds to, so that ReplayWriteAheadLog can load the
+// snapshot and only apply the records written after it, instead of replaying
+// the whole log. A new checkpoint replaces the previous one.
+//
+// The active log file is sealed first, so the position is the end of a
+// rotated segment, which keeps its name from then on. The segments up to the
+// position are no longer needed for recovery, they're left in place unless
+// WithSegmentRetention removes them.
+func (db *Database) Checkpoint() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if db.partialReplay.Load() {
+		return ErrPartialReplay
+	}
+
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	err := db.checkpoint()
+	if err == nil && db.retention != nil {
+		// The segments the checkpoint covers may be removable now
+		db.requestRetention()
+	}
+	return err
+}
+
+// checkpoint is Checkpoint for callers that already hold compactLock.
+func (db *Database) checkpoint() error {
+	sugar := db.logger.Sugar()
+
+	rLockShards(db.shards)
+	db.logFileLock.Lock()
+	if db.closed {
+		db.logFileLock.Unlock()
+		rUnlockShards(db.shards)
+		return ErrClosed
+	}
+	if db.store == nil {
+		// Nothing on disk to checkpoint against
+		db.logFileLock.Unlock()
+		rUnlockShards(db.shards)
+		return nil
+	}
+
+	segments, err := db.sealLog()
+	db.logFileLock.Unlock()
+	if err != nil {
+		rUnlockShards(db.shards)
+		return err
+	}
+
+	entries := db.liveEntries()
+	rUnlockShards(db.shards)
+
+	// The last segment is the fresh active file, the one before it was just
+	// sealed
+	sealed := segments[len(segments)-2]
+	info, err := os.Stat(sealed)
+	if err != nil {
+		return err
+	}
+
+	marker := &contract.LogEntry{
+		Op:    CHECKPOINT,
+		Key:   filepath.Base(sealed),
+		Value: []byte(strconv.FormatInt(info.Size(), 10)),
+	}
+	err = db.writeSegment(db.checkpointFile(), append([]*contract.LogEntry{marker}, entries...))
+	if err != nil {
+		return err
+	}
+
+	sugar.Infof("Checkpointed %d keys at offset %d of %s", len(entries), info.Size(), sealed)
+	return nil
+}
+
+// loadCheckpoint applies the checkpoint of the log through r, if there is one,
+// and returns the position replay resumes from. A checkpoint that can't be read
+// is ignored, the log it was taken from is still complete, so the database is
+// reset and replayed from the start instead. Only reaching the deadline of
+// the replay is returned as an error. Callers must hold every shard lock.
+func (db *Database) loadCheckpoint(r *replayer) (*checkpointPosition, error) {
+	sugar := db.logger.Sugar()
+
+	path := db.checkpointFile()
+	position, err := db.readCheckpoint(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err == nil {
+		err = r.replayFile(path, 0)
+	}
+	var stopped *replayDeadlineError
+	if errors.As(err, &stopped) {
+		return nil, err
+	}
+	if err != nil {
+		sugar.Warnf("Ignoring unreadable checkpoint %s, replaying the whole log: %v", path, err)
+		for _, s := range db.shards {
+			s.reset()
+		}
+		*r.stats = ReplayStats{}
+		return nil, nil
+	}
+
+	sugar.Infof("Loaded checkpoint %s, resuming at offset %d of %s", path, position.offset, position.segment)
+	return position, nil
+}
+
+// readCheckpoint reads the position recorded in the first record of a
+// checkpoint file.
+func (db *Database) readCheckpoint(path string) (*checkpointPosition, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header, offset, err := readLogHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, _, err := readRecord(file, offset, info.Size(), header.version)
+	if err != nil {
+		return nil, err
+	}
+
+	marker := &contract.LogEntry{}
+	err = db.decodeLogEntry(header, payload, marker)
+	if err != nil {
+		return nil, err
+	}
+	if marker.Op != CHECKPOINT {
+		return nil, fmt.Errorf("%s doesn't start with a checkpoint record", path)
+	}
+
+	offset, err = strconv.ParseInt(string(marker.Value), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad checkpoint offset: %w", path, err)
+	}
+
+	return &checkpointPosition{
+		segment: filepath.Join(filepath.Dir(db.logFile), marker.Key),
+		offset:  offset,
+	}, nil
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestReplaySkipsRecordsBeforeCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit))
+	want := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key, value := fmt.Sprintf("key-%d", i%10), fmt.Sprintf("value-%d", i)
+		mustSet(t, db, key, value)
+		want[key] = value
+	}
+	mustDelete(t, db, "key-0")
+	delete(want, "key-0")
+
+	err := db.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	covered := segmentNames(t, dir)
+
+	mustSet(t, db, "key-1", "after")
+	mustSet(t, db, "new", "after")
+	want["key-1"] = "after"
+	want["new"] = "after"
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db = NewDatabase(dir, "test", MinRotateSize, WithLogger(zap.NewNop()))
+	t.Cleanup(func() { _ = db.Close() })
+	stats, err := db.ReplayWriteAheadLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, db, want)
+	// The checkpoint's records for the 9 live keys, its position and the 2
+	// writes after it, not the 1001 records it covers
+	if stats.Records > 20 {
+		t.Fatalf("replay read %d records, the checkpoint should have skipped most", stats.Records)
+	}
+
+	// The segments the checkpoint covers aren't needed anymore
+	for _, name := range covered {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db = NewDatabase(dir, "test", MinRotateSize, WithLogger(zap.NewNop()))
+	t.Cleanup(func() { _ = db.Close() })
+	_, err = db.ReplayWriteAheadLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, db, want)
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is where the database reads the time from: TTL expiry, write
+// timestamps, log rotation by age and the intervals of its background work all
+// go through it. The latencies it logs and reports are still measured on the
+// wall clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives the current time once d has
+	// passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// WithClock makes the database read the time from clock, RealClock by
+// default. A ManualClock makes time-based behaviour deterministic in tests.
+func WithClock(clock Clock) Option {
+	return func(db *Database) {
+		db.clock = clock
+	}
+}
+
+// RealClock is the Clock of the system.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// ManualClock is a Clock that only moves when it's told to, so tests can
+// expire keys or rotate the log by advancing it rather than by sleeping.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+	// changed is closed and replaced whenever a caller starts waiting
+	changed chan struct{}
+}
+
+type manualWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewManualClock returns a ManualClock reading start until it's advanced.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start, changed: make(chan struct{})}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, manualWaiter{at: c.now.Add(d), c: ch})
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return ch
+}
+
+// Advance moves the clock forward by d and wakes up the callers of After whose
+// time has come. They run asynchronously, so the effect of what they do, like
+// a sweep of expired keys, shows up shortly after Advance returns.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+// BlockUntil blocks until at least n callers are waiting on After, so a test
+// can tell the background work it's about to trigger is armed.
+func (c *ManualClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		waiting, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"personalMonorepo/distributedDataStore/contract"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes log entries into record payloads. The codec a log file was
+// written with is recorded in its header, so every file is decoded with the
+// codec that wrote it regardless of what the database is configured with now.
+type Codec interface {
+	// ID identifies the codec in log headers and must never change.
+	ID() byte
+	Marshal(entry *contract.LogEntry) ([]byte, error)
+	Unmarshal(data []byte, entry *contract.LogEntry) error
+}
+
+var (
+	// ProtoCodec encodes entries as protobuf, the default.
+	ProtoCodec Codec = protoCodec{}
+	// JSONCodec encodes entries as JSON, handy for eyeballing a log.
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec encodes entries as MessagePack.
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// WithCodec sets the codec used for newly created log files, ProtoCodec by
+// default.
+func WithCodec(codec Codec) Option {
+	return func(db *Database) {
+		db.codec = codec
+	}
+}
+
+func codecByID(id byte) (Codec, error) {
+	for _, codec := range []Codec{ProtoCodec, JSONCodec, MsgpackCodec} {
+		if codec.ID() == id {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown log codec %d", id)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) ID() byte { return 0 }
+
+func (protoCodec) Marshal(entry *contract.LogEntry) ([]byte, error) {
+	return proto.Marshal(entry)
+}
+
+func (protoCodec) Unmarshal(data []byte, entry *contract.LogEntry) error {
+	return proto.Unmarshal(data, entry)
+}
+
+// codecEntry mirrors contract.LogEntry for the codecs that don't understand
+// proto messages, keeping their encoding independent of the generated code.
+type codecEntry struct {
+	Op        uint32            `json:"op" msgpack:"op"`
+	Key       string            `json:"key" msgpack:"key"`
+	Value     []byte            `json:"value,omitempty" msgpack:"value,omitempty"`
+	ExpiresAt int64             `json:"expires_at,omitempty" msgpack:"expires_at,omitempty"`
+	Version   uint64            `json:"version,omitempty" msgpack:"version,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty" msgpack:"timestamp,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty" msgpack:"metadata,omitempty"`
+	Seq       uint64            `json:"seq,omitempty" msgpack:"seq,omitempty"`
+}
+
+func toCodecEntry(entry *contract.LogEntry) codecEntry {
+	return codecEntry{
+		Op:        entry.Op,
+		Key:       entry.Key,
+		Value:     entry.Value,
+		ExpiresAt: entry.ExpiresAt,
+		Version:   entry.Version,
+		Timestamp: entry.Timestamp,
+		Metadata:  entry.Metadata,
+		Seq:       entry.Seq,
+	}
+}
+
+func (e codecEntry) toLogEntry(entry *contract.LogEntry) {
+	entry.Op = e.Op
+	entry.Key = e.Key
+	entry.Value = e.Value
+	entry.ExpiresAt = e.ExpiresAt
+	entry.Version = e.Version
+	entry.Timestamp = e.Timestamp
+	entry.Metadata = e.Metadata
+	entry.Seq = e.Seq
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte { return 1 }
+
+func (jsonCodec) Marshal(entry *contract.LogEntry) ([]byte, error) {
+	return json.Marshal(toCodecEntry(entry))
+}
+
+func (jsonCodec) Unmarshal(data []byte, entry *contract.LogEntry) error {
+	var e codecEntry
+	err := json.Unmarshal(data, &e)
+	if err != nil {
+		return err
+	}
+	e.toLogEntry(entry)
+	return nil
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte { return 2 }
+
+func (msgpackCodec) Marshal(entry *contract.LogEntry) ([]byte, error) {
+	return msgpack.Marshal(toCodecEntry(entry))
+}
+
+func (msgpackCodec) Unmarshal(data []byte, entry *contract.LogEntry) error {
+	var e codecEntry
+	err := msgpack.Unmarshal(data, &e)
+	if err != nil {
+		return err
+	}
+	e.toLogEntry(entry)
+	return nil
+}
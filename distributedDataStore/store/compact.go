@@ -0,0 +1,263 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"personalMonorepo/distributedDataStore/contract"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Compact rewrites the log so it only holds one INSERT record per live key,
+// dropping superseded and deleted records, plus the DELETE records the
+// checkpoint still needs.
+//
+// The active log file is sealed first, so everything written so far lives in
+// rotated segments and new writes keep going to a fresh file. The live keys are
+// then written to a compacted segment that sorts after the sealed segments but
+// before anything rotated later, and only then are the sealed segments removed,
+// oldest first. A crash at any point leaves a log that replays to the same
+// state: whatever suffix of the old history survives is followed by a full copy
+// of the state it leads to.
+//
+// The DELETE records of the sealed segments are dropped along with them, since
+// every older record of their keys goes too, except for those in the
+// checkpoint, which survives. The compacted segment keeps a DELETE record for
+// every key the checkpoint holds that isn't live anymore, so replaying the
+// checkpoint and then the compacted segment never resurrects a deleted key.
+// Those tombstones are dropped by the first compaction after a checkpoint that
+// no longer holds their keys.
+//
+// Writes are only held off while the log is sealed and the keyspace copied, so
+// reads and writes carry on while the compacted segment is written.
+func (db *Database) Compact() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if db.partialReplay.Load() {
+		return ErrPartialReplay
+	}
+	sugar := db.logger.Sugar()
+	start := time.Now()
+
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	// Every write holds its shard lock while logging, so read locking all of
+	// them keeps the log and the keyspace in step while we seal and copy
+	rLockShards(db.shards)
+	db.logFileLock.Lock()
+	if db.closed {
+		db.logFileLock.Unlock()
+		rUnlockShards(db.shards)
+		return ErrClosed
+	}
+	if db.store == nil {
+		// Nothing on disk to compact
+		db.logFileLock.Unlock()
+		rUnlockShards(db.shards)
+		return nil
+	}
+
+	segments, err := db.sealLog()
+	if err == nil {
+		// Whatever part of the log the policy was measuring is compacted
+		// now, even if the rest of the compaction fails
+		db.compactedSeq = db.logSeq
+		db.compactedRotations = db.store.(*FileLogStore).rotations
+	}
+	db.logFileLock.Unlock()
+	if err != nil {
+		rUnlockShards(db.shards)
+		return err
+	}
+	// The last segment is the fresh active file
+	sealed := segments[:len(segments)-1]
+
+	entries := db.liveEntries()
+	rUnlockShards(db.shards)
+
+	// Compaction drops every deleted key from the log, drop them from the
+	// filters too
+	db.rebuildBlooms()
+
+	if len(sealed) == 0 {
+		return nil
+	}
+
+	tombstones, err := db.tombstones(entries)
+	if err != nil {
+		return fmt.Errorf("reading checkpoint: %w", err)
+	}
+
+	compacted := fmt.Sprintf("%s_compacted", sealed[len(sealed)-1])
+	err = db.writeSegment(compacted, append(entries, tombstones...))
+	if err != nil {
+		return err
+	}
+
+	var reclaimed int64
+	for _, segment := range sealed {
+		if segment == compacted {
+			continue
+		}
+		if info, err := os.Stat(segment); err == nil {
+			reclaimed += info.Size()
+		}
+		err = os.Remove(segment)
+		if err != nil {
+			return err
+		}
+	}
+	if info, err := os.Stat(compacted); err == nil {
+		reclaimed -= info.Size()
+	}
+	if reclaimed < 0 {
+		reclaimed = 0
+	}
+	compactionReclaimedBytes.Observe(float64(reclaimed))
+
+	sugar.Infof("Compacted %d segments into %s with %d records and %d tombstones, reclaiming %d bytes",
+		len(sealed), compacted, len(entries), len(tombstones), reclaimed)
+	db.logger.Debug("compact",
+		zap.String("segment", compacted),
+		zap.Int("segments", len(sealed)),
+		zap.Int("records", len(entries)),
+		zap.Int("tombstones", len(tombstones)),
+		zap.Int64("reclaimed", reclaimed),
+		zap.Duration("latency", time.Since(start)))
+	return nil
+}
+
+// sealLog rotates the log file and returns its segments, the last one being the
+// fresh active file. Callers must hold logFileLock.
+func (db *Database) sealLog() ([]string, error) {
+	store, ok := db.store.(*FileLogStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	err := store.Rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	return discoverSegments(store.path)
+}
+
+// liveEntries returns an INSERT record for every live key, in key order.
+// Callers must hold every shard lock.
+func (db *Database) liveEntries() []*contract.LogEntry {
+	live := db.collectShared("", func(string) bool { return true })
+	entries := make([]*contract.LogEntry, 0, len(live))
+	for _, kv := range live {
+		entries = append(entries, &contract.LogEntry{
+			Op:        INSERT,
+			Key:       kv.Key,
+			Value:     kv.Value,
+			ExpiresAt: db.shardFor(kv.Key).expiry[kv.Key],
+			Version:   db.shardFor(kv.Key).versions[kv.Key],
+			Timestamp: db.shardFor(kv.Key).meta[kv.Key].timestamp,
+			Metadata:  db.shardFor(kv.Key).meta[kv.Key].metadata,
+		})
+	}
+	return entries
+}
+
+// tombstones returns a DELETE record for every key held by the checkpoint that
+// isn't among the live entries, none without a checkpoint. The checkpoint is
+// read the way replay reads it, so records replay would skip don't need one.
+// Callers must hold compactLock, so the checkpoint doesn't change meanwhile.
+func (db *Database) tombstones(live []*contract.LogEntry) ([]*contract.LogEntry, error) {
+	path := db.checkpointFile()
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	isLive := make(map[string]bool, len(live))
+	for _, entry := range live {
+		isLive[entry.Key] = true
+	}
+
+	now := db.writeTimestamp()
+	var tombstones []*contract.LogEntry
+	opts := logReadOptions{strict: db.strictReplay, readOnly: true}
+	_, err = readLogFile(db.logger, path, 0, opts, func(header logHeader, record []byte) error {
+		entry := &contract.LogEntry{}
+		err := db.decodeLogEntry(header, record, entry)
+		if err != nil {
+			return err
+		}
+		if entry.Op == CHECKPOINT || isLive[entry.Key] {
+			return nil
+		}
+		tombstones = append(tombstones, &contract.LogEntry{
+			Op:        DELETE,
+			Key:       entry.Key,
+			Timestamp: now,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// writeSegment writes entries as a complete log file at path, in the current
+// format. The file is
+// written and synced under a temporary name first and renamed into place, so a
+// reader never sees a partial segment.
+func (e *recordEncoder) writeSegment(path string, entries []*contract.LogEntry) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = e.writeEntries(file, entries)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// writeEntries writes a log header followed by entries to w.
+func (e *recordEncoder) writeEntries(w io.Writer, entries []*contract.LogEntry) error {
+	buf := bufio.NewWriter(w)
+	header := e.currentHeader()
+
+	_, err := buf.Write(encodeLogHeader(header.codec))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		record, err := e.encodeLogEntry(header, entry)
+		if err != nil {
+			return err
+		}
+
+		_, err = buf.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return buf.Flush()
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"strings"
+	"testing"
+)
+
+// rotateTestLog seals the active log file of db into a segment.
+func rotateTestLog(t *testing.T, db *Database) {
+	t.Helper()
+
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+	err := db.store.(*FileLogStore).Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copyTestDir copies the files of dir into a new directory and returns its
+// path.
+func copyTestDir(t *testing.T, dir string) string {
+	t.Helper()
+
+	copied := t.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		copyTestFile(t, filepath.Join(dir, entry.Name()), filepath.Join(copied, entry.Name()))
+	}
+	return copied
+}
+
+func copyTestFile(t *testing.T, from, to string) {
+	t.Helper()
+
+	src, err := os.Open(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// segmentNames returns the names of the rotated segments in dir, oldest first.
+func segmentNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	segments, err := discoverSegments(filepath.Join(dir, "test.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, segment := range segments {
+		if name := filepath.Base(segment); name != "test.bin" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestCompactSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit))
+	want := make(map[string]string)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key-%02d", i)
+			value := fmt.Sprintf("round-%d", round)
+			mustSet(t, db, key, value)
+			want[key] = value
+		}
+		key := fmt.Sprintf("key-%02d", round)
+		mustDelete(t, db, key)
+		delete(want, key)
+		rotateTestLog(t, db)
+	}
+
+	// The log as compaction finds it, and the segments it's going to remove
+	before := copyTestDir(t, dir)
+	old := segmentNames(t, before)
+	wantBefore := make(map[string]string, len(want))
+	for key, value := range want {
+		wantBefore[key] = value
+	}
+
+	err := db.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, db, "after", "compaction")
+	want["after"] = "compaction"
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := segmentNames(t, dir); len(left) != 1 || !strings.HasSuffix(left[0], "_compacted") {
+		t.Fatalf("compaction left segments %v, want a single compacted one", left)
+	}
+
+	// Segments are removed oldest first, a crash on the way leaves any
+	// suffix of them next to the compacted segment
+	for kept := 0; kept <= len(old); kept++ {
+		t.Run(fmt.Sprintf("%d of %d old segments left", kept, len(old)), func(t *testing.T) {
+			crashed := copyTestDir(t, dir)
+			for _, name := range old[len(old)-kept:] {
+				copyTestFile(t, filepath.Join(before, name), filepath.Join(crashed, name))
+			}
+			checkContents(t, openTestDatabase(t, crashed), want)
+		})
+	}
+
+	// A crash while the compacted segment is written leaves it behind
+	// under its temporary name, half written
+	t.Run("compacted segment half written", func(t *testing.T) {
+		crashed := copyTestDir(t, before)
+		compacted, err := os.ReadFile(filepath.Join(dir, segmentNames(t, dir)[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmp := filepath.Join(crashed, old[len(old)-1]+"_compacted.tmp")
+		err = os.WriteFile(tmp, compacted[:len(compacted)/2], 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkContents(t, openTestDatabase(t, crashed), wantBefore)
+	})
+}
+
+// compactedTombstones returns the keys of the DELETE records in the compacted
+// segment of dir.
+func compactedTombstones(t *testing.T, db *Database, dir string) []string {
+	t.Helper()
+
+	var compacted string
+	for _, name := range segmentNames(t, dir) {
+		if strings.HasSuffix(name, "_compacted") {
+			compacted = filepath.Join(dir, name)
+		}
+	}
+	if compacted == "" {
+		t.Fatal("no compacted segment")
+	}
+	var keys []string
+	_, err := readLogFile(db.logger, compacted, 0, logReadOptions{strict: true, readOnly: true}, func(header logHeader, record []byte) error {
+		entry := &contract.LogEntry{}
+		err := db.decodeLogEntry(header, record, entry)
+		if err == nil && entry.Op == DELETE {
+			keys = append(keys, entry.Key)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestCompactDoesNotResurrectDeletedKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit))
+	mustSet(t, db, "kept", "value")
+	mustSet(t, db, "gone", "value")
+	mustSet(t, db, "checkpointed", "value")
+	mustDelete(t, db, "gone")
+	err := db.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustDelete(t, db, "checkpointed")
+	want := map[string]string{"kept": "value"}
+
+	// Only the key the checkpoint still holds needs its tombstone, the
+	// older records of the other went with the sealed segments
+	err = db.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := compactedTombstones(t, db, dir); len(got) != 1 || got[0] != "checkpointed" {
+		t.Fatalf("compacted segment has tombstones for %v, want [checkpointed]", got)
+	}
+	db = reopenTestDatabase(t, db, dir, WithSyncMode(SyncOnCommit))
+	checkContents(t, db, want)
+
+	// Once a checkpoint no longer holds the key its tombstone can go too
+	err = db.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := compactedTombstones(t, db, dir); len(got) != 0 {
+		t.Fatalf("compacted segment has tombstones for %v, want none", got)
+	}
+	db = reopenTestDatabase(t, db, dir)
+	checkContents(t, db, want)
+}
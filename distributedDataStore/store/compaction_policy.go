@@ -0,0 +1,126 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultCompactionCheckInterval is how often a CompactionPolicy is evaluated
+// unless it says otherwise
+const defaultCompactionCheckInterval = 30 * time.Second
+
+// CompactionPolicy decides when the background compactor runs Compact. Each
+// threshold left at zero is disabled, and the log is compacted as soon as any
+// of the others is reached.
+type CompactionPolicy struct {
+	// MaxSpaceAmplification compacts once the log files take up more than
+	// this many bytes per byte of live value. Keys and record framing count
+	// towards the log but not the values, so it should be set well above the
+	// ratio a freshly compacted log has.
+	MaxSpaceAmplification float64
+	// MaxRotations compacts once the log file was rotated this many times
+	// since the last compaction
+	MaxRotations int
+	// MinLogSize keeps logs smaller than this many bytes from being compacted
+	// for their space amplification, which is noisy on small logs
+	MinLogSize int64
+	// CheckInterval is how often the policy is evaluated, every 30s by
+	// default
+	CheckInterval time.Duration
+}
+
+// WithCompactionPolicy compacts the log in the background whenever policy
+// says so. It only applies to the FileLogStore, and never to a read-only
+// database. The log is never compacted again before something new was written
+// to it.
+func WithCompactionPolicy(policy CompactionPolicy) Option {
+	return func(db *Database) {
+		db.compactionPolicy = &policy
+	}
+}
+
+// compactOnPolicy evaluates the compaction policy every CheckInterval and
+// compacts the log when it's due. It stops when the database is closed.
+func (db *Database) compactOnPolicy() {
+	interval := db.compactionPolicy.CheckInterval
+	if interval <= 0 {
+		interval = defaultCompactionCheckInterval
+	}
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-db.clock.After(interval):
+			reason, due := db.compactionDue()
+			if !due {
+				continue
+			}
+			db.logger.Sugar().Infof("Compacting log, %s", reason)
+			err := db.Compact()
+			if err != nil && !errors.Is(err, ErrClosed) {
+				db.logger.Sugar().Errorf("Failed to compact log: %v", err)
+			}
+		}
+	}
+}
+
+// compactionDue reports whether the compaction policy calls for compacting
+// the log now, and why.
+func (db *Database) compactionDue() (string, bool) {
+	policy := db.compactionPolicy
+
+	db.logFileLock.Lock()
+	store, ok := db.store.(*FileLogStore)
+	if db.closed || !ok || db.logSeq == db.compactedSeq {
+		db.logFileLock.Unlock()
+		return "", false
+	}
+	rotations := store.rotations - db.compactedRotations
+	db.logFileLock.Unlock()
+
+	if policy.MaxRotations > 0 && rotations >= uint64(policy.MaxRotations) {
+		return fmt.Sprintf("rotated %d times since the last compaction", rotations), true
+	}
+
+	if policy.MaxSpaceAmplification <= 0 {
+		return "", false
+	}
+	logSize, err := logFilesSize(db.logFile)
+	if err != nil || logSize < policy.MinLogSize {
+		return "", false
+	}
+	var live int64
+	for _, s := range db.shards {
+		s.mu.RLock()
+		live += s.valueBytes
+		s.mu.RUnlock()
+	}
+	if float64(logSize) > policy.MaxSpaceAmplification*float64(live) {
+		return fmt.Sprintf("%d bytes of log for %d bytes of live values", logSize, live), true
+	}
+	return "", false
+}
+
+// logFilesSize returns the total size of the log files of the log at path,
+// skipping segments removed while they're listed.
+func logFilesSize(path string) (int64, error) {
+	segments, err := discoverSegments(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
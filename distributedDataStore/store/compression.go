@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// Compression selects how record payloads are compressed.
+type Compression byte
+
+const (
+	// CompressionNone stores payloads as they are, the default.
+	CompressionNone Compression = iota
+	// CompressionGzip trades CPU for the best ratio.
+	CompressionGzip
+	// CompressionSnappy is much faster than gzip at a lower ratio.
+	CompressionSnappy
+)
+
+// payloadFlag is the first byte of a record payload from flagsFormatVersion on.
+// The low bits hold the Compression the rest of the payload was written with,
+// and payloadEncrypted marks payloads sealed with the database's AEAD.
+type payloadFlag byte
+
+const (
+	payloadCompressionMask payloadFlag = 0x0f
+	payloadEncrypted       payloadFlag = 0x10
+)
+
+// defaultCompressMinSize is the payload size below which compression is
+// usually not worth it.
+const defaultCompressMinSize = 256
+
+// WithCompression compresses record payloads of at least minSize bytes with
+// compression. Smaller payloads, and payloads that don't shrink, are stored
+// uncompressed. A minSize of zero picks a default.
+func WithCompression(compression Compression, minSize int) Option {
+	return func(db *Database) {
+		if minSize <= 0 {
+			minSize = defaultCompressMinSize
+		}
+		db.compression = compression
+		db.compressMinSize = minSize
+	}
+}
+
+// encodePayload prefixes payload with its flags byte, compressing and then
+// encrypting it first if the encoder is configured to.
+func (e *recordEncoder) encodePayload(payload []byte) ([]byte, error) {
+	flag := payloadFlag(CompressionNone)
+	if e.compression != CompressionNone && len(payload) >= e.compressMinSize {
+		compressed, err := compress(e.compression, payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(payload) {
+			payload = compressed
+			flag = payloadFlag(e.compression)
+		}
+	}
+
+	if e.aead != nil {
+		flag |= payloadEncrypted
+		return e.seal(flag, payload)
+	}
+
+	return append([]byte{byte(flag)}, payload...), nil
+}
+
+// decodePayload strips the flags byte of payload and undoes its encryption and
+// compression.
+func (e *recordEncoder) decodePayload(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("record payload is missing its flags: %w", ErrCorruptRecord)
+	}
+
+	flag, body := payloadFlag(payload[0]), payload[1:]
+	if flag&payloadEncrypted != 0 {
+		var err error
+		body, err = e.open(flag, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return decompress(Compression(flag&payloadCompressionMask), body)
+}
+
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %d", compression)
+	}
+}
+
+func decompress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("unknown compression %d: %w", compression, ErrCorruptRecord)
+	}
+}
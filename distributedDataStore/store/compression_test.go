@@ -0,0 +1,64 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// BenchmarkCompression writes compressible values with every codec and
+// compression, and reports how many bytes of log each write takes next to how
+// long it takes.
+func BenchmarkCompression(b *testing.B) {
+	codecs := []struct {
+		name  string
+		codec Codec
+	}{
+		{name: "proto", codec: ProtoCodec},
+		{name: "json", codec: JSONCodec},
+		{name: "msgpack", codec: MsgpackCodec},
+	}
+	compressions := []struct {
+		name        string
+		compression Compression
+	}{
+		{name: "none", compression: CompressionNone},
+		{name: "gzip", compression: CompressionGzip},
+		{name: "snappy", compression: CompressionSnappy},
+	}
+	// Log lines, like many values people store
+	values := make([][]byte, 100)
+	for i := range values {
+		values[i] = []byte(strings.Repeat(fmt.Sprintf("level=info msg=\"request served\" id=%d status=200\n", i), 64))
+	}
+	for _, c := range codecs {
+		for _, comp := range compressions {
+			b.Run(c.name+"/"+comp.name, func(b *testing.B) {
+				dir := b.TempDir()
+				db := openTestDatabase(b, dir, WithCodec(c.codec), WithCompression(comp.compression, 0))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, err := db.Set(fmt.Sprintf("key-%d", i), values[i%len(values)])
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				err := db.Flush()
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+
+				segments, err := db.SegmentSizes()
+				if err != nil {
+					b.Fatal(err)
+				}
+				var size int64
+				for _, segment := range segments {
+					size += segment.Size
+				}
+				b.ReportMetric(float64(size)/float64(b.N), "log-bytes/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Increment adds delta to the counter stored at key as decimal bytes, treating
+// a missing key as 0, and returns the new value. The read, the add and the
+// write happen under the key's shard lock, so concurrent increments never lose
+// an update. The key keeps its TTL and metadata, if it has any. A sum that
+// doesn't fit an int64 fails with ErrOverflow, leaving the key as it was.
+func (db *Database) Increment(key string, delta int64) (int64, error) {
+	err := db.checkKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.admit(context.Background(), 1, len(key))
+	if err != nil {
+		return 0, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	s.mu.Lock()
+	if db.closed {
+		s.mu.Unlock()
+		return 0, ErrClosed
+	}
+
+	var current, expiresAt int64
+	var metadata map[string]string
+	val, ok := s.lookup(key)
+	if ok {
+		expiresAt, metadata = s.expiry[key], s.meta[key].metadata
+		var err error
+		current, err = strconv.ParseInt(string(val), 10, 64)
+		if err != nil {
+			s.mu.Unlock()
+			return 0, fmt.Errorf("%w: key %q holds %q", ErrNotInteger, key, val)
+		}
+	}
+
+	next := current + delta
+	if (delta > 0 && next < current) || (delta < 0 && next > current) {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("%w: %d%+d on key %q", ErrOverflow, current, delta, key)
+	}
+	_, err = db.setLocked(key, []byte(strconv.FormatInt(next, 10)), expiresAt, metadata)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return next, leader.awaitAcks(seq)
+}
@@ -0,0 +1,1296 @@
+// Package store is the key-value database: an in-memory keyspace backed by a
+// write-ahead log, along with the servers, replication and tooling built on
+// it.
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type Database struct {
+	// shards partition the keyspace by key hash, see shard
+	shards []*shard
+	// writeAhead holds the records written to the log file since it was last
+	// synced, i.e. the ones a crash could still lose
+	writeAhead []*contract.LogEntry
+	logFile    string
+	name       string
+	// logFileLock guards the log file and the records going into it. It's
+	// always taken after any shard locks.
+	logFileLock sync.Mutex
+	// compactLock keeps compactions from running concurrently, and from
+	// removing segments while they're read. It's taken before any shard
+	// locks.
+	compactLock sync.Mutex
+	// store holds the log, a FileLogStore for logFile once it's opened
+	store LogStore
+	// lock holds the advisory lock on <logFile>.lock while the log is open,
+	// so a second database can't append to it too
+	lock *os.File
+	// audit is the audit trail kept at auditPath while the log is open, see
+	// WithAuditLog
+	auditPath string
+	audit     *auditLog
+	// logSeq counts the records appended to the log, syncedSeq is its value
+	// as of the last sync
+	logSeq    uint64
+	syncedSeq atomic.Uint64
+	// lastSync is when the log was last synced, syncErr is the error the
+	// last sync failed with, nil if it succeeded
+	lastSync time.Time
+	syncErr  error
+	// healthBuffered is the number of buffered records past which Health
+	// fails, zero means maxBuffered
+	healthBuffered int
+	// versionSeq hands out the versions of writes, so a key's version
+	// changes on every write and is never reused
+	versionSeq atomic.Uint64
+	// lastTimestamp is the timestamp of the latest write, so the timestamps
+	// handed out later are greater even if the clock goes back
+	lastTimestamp atomic.Int64
+	// partialReplay is set while the keyspace only holds what a replay read
+	// before its deadline, partialAccepted once writes are let through
+	// anyway, see ReplayWriteAheadLogWithTimeout
+	partialReplay   atomic.Bool
+	partialAccepted atomic.Bool
+
+	// freezeLock is read-locked by every write and locked by Freeze, see
+	// enterWrite. freezing is set from Freeze to Unfreeze, frozen once
+	// Freeze returned.
+	freezeLock   sync.RWMutex
+	freezePolicy atomic.Int32
+	freezing     atomic.Bool
+	frozen       atomic.Bool
+
+	// idempotency remembers the tokens of SetIdempotent
+	idempotency idempotencyWindow
+	// maxBuffered bounds writeAhead according to bufferPolicy, zero means
+	// no bound
+	maxBuffered  int
+	bufferPolicy BufferPolicy
+	// opLimit and byteLimit admit writes when set, see WithRateLimit
+	opLimit   *writeLimit
+	byteLimit *writeLimit
+	// keyLimits bounds what can be written
+	keyLimits
+	rotateSize int64
+	// rotateInterval rotates the log file by age as well as size when set
+	rotateInterval time.Duration
+	// clock is where the time is read from, see WithClock
+	clock Clock
+	// writeBuffer is the size of the buffer in front of the log file, see
+	// WithWriteBuffer
+	writeBuffer int
+	// noRotationSync skips the syncs of rotations, see WithRotationSync
+	noRotationSync bool
+	// shardLogs keeps a log per shard, see WithShardLogs. Under
+	// SyncOnCommit, shardLogRanges holds the ranges of records synced past a
+	// gap, synced ahead of an earlier write still syncing, shardLogSynced is
+	// signalled when syncedSeq moves on and shardLogErr is set once a sync
+	// failed, failing every later write.
+	shardLogs bool
+	// openBatch is set when replay ended in a batch that never committed,
+	// until the log is open to close it, see closeOpenBatch
+	openBatch      bool
+	shardLogRanges map[uint64]uint64
+	shardLogSynced *sync.Cond
+	shardLogErr    error
+	syncMode       SyncMode
+	// recordEncoder encodes and decodes the records of the log
+	recordEncoder
+	strictReplay bool
+	readOnly     bool
+	mmapReplay   bool
+	// noDedup logs writes of the value a key already holds, see WithValueDedup
+	noDedup bool
+	// memory is set for a database without a log, see NewMemoryDatabase
+	memory bool
+	// merge is the merge operator, see WithMergeOperator
+	merge MergeFunc
+	// parallelReplay decodes log files concurrently during replay
+	parallelReplay bool
+	// compactionPolicy runs Compact in the background when set.
+	// compactedSeq and compactedRotations are logSeq and the log file's
+	// rotations as of the last compaction, guarded by logFileLock.
+	compactionPolicy   *CompactionPolicy
+	compactedSeq       uint64
+	compactedRotations uint64
+	// retention bounds the rotated segments kept when set
+	retention *segmentRetention
+	// closed is only set while holding every shard lock and logFileLock, so
+	// holding any one of them is enough to read it
+	closed bool
+	// done is closed by Close to stop background goroutines
+	done          chan struct{}
+	sweepInterval time.Duration
+	// leader streams written records to followers when replication is on
+	leader *ReplicationLeader
+	// commits queues writes for the group committer under SyncGroupCommit
+	commits chan *commitRequest
+	// watchers holds the Watch subscribers
+	watchers    watchHub
+	watchBuffer int
+	watchPolicy WatchPolicy
+	shardCount  int
+	// logger receives the database's logs, opLogger is its sampled variant
+	// for per-operation debug logs
+	logger              *zap.Logger
+	opLogger            *zap.Logger
+	logSampleFirst      int
+	logSampleThereafter int
+}
+
+const (
+	INSERT = iota
+	UPDATE
+	DELETE
+	// BATCH_BEGIN and BATCH_COMMIT frame the records of a WriteBatch
+	BATCH_BEGIN
+	BATCH_COMMIT
+	// CHECKPOINT opens a checkpoint file and records where the log resumes
+	CHECKPOINT
+	// MERGE combines a merge operand with the value of its key, see Merge
+	MERGE
+)
+
+// SyncMode controls when writes to the log file are fsync'd to stable storage.
+//
+// The modes trade throughput for durability. SyncOnCommit is the only mode in
+// which a nil error from Set or Delete means the record survives a power loss,
+// but every write then pays for a full fsync, which usually caps throughput at
+// a few thousand writes per second. SyncEvery amortizes that cost by syncing
+// on a periodic flush, so a crash can lose the writes made since the last
+// flush. SyncNone never syncs and leaves durability entirely to the OS page
+// cache, which is the fastest and the least safe. SyncGroupCommit keeps the
+// guarantee of SyncOnCommit while amortizing the fsync over concurrent writes,
+// at the cost of a little latency for a lone writer.
+//
+// Whatever the mode, a rotation syncs the segment it seals and the directory
+// holding the log before later writes go to the new file, unless
+// WithRotationSync turns that off.
+type SyncMode int
+
+const (
+	// SyncEvery syncs the log file on every periodic flush.
+	SyncEvery SyncMode = iota
+	// SyncOnCommit syncs the log file before each write returns.
+	SyncOnCommit
+	// SyncNone never syncs the log file explicitly, a flush only writes out
+	// what WithWriteBuffer holds back. Rotations still sync unless
+	// WithRotationSync turns that off, and so does Close.
+	SyncNone
+	// SyncGroupCommit syncs before each write returns, like SyncOnCommit, but
+	// shares one fsync between the writes that queue up meanwhile.
+	SyncGroupCommit
+)
+
+// Option configures optional behaviour of a Database.
+type Option func(*Database)
+
+// WithSyncMode sets the durability mode of the log file, SyncEvery by default.
+func WithSyncMode(mode SyncMode) Option {
+	return func(db *Database) {
+		db.syncMode = mode
+	}
+}
+
+// WithReadOnly opens the database read-only. It can be loaded with
+// ReplayWriteAheadLog and serves reads, but every write fails with ErrReadOnly
+// and the log files are never opened for writing, not even to truncate a
+// partially written record.
+func WithReadOnly() Option {
+	return func(db *Database) {
+		db.readOnly = true
+	}
+}
+
+// WithStrictReplay makes ReplayWriteAheadLog fail on a record whose checksum
+// doesn't match instead of skipping it.
+func WithStrictReplay() Option {
+	return func(db *Database) {
+		db.strictReplay = true
+	}
+}
+
+// MinRotateSize is the smallest rotateSize OpenLogFile accepts. Anything
+// smaller seals a segment every few records and fills the directory with
+// them, sizes of 16MiB to 256MiB keep the segment count low while bounding
+// what compaction has to rewrite.
+const MinRotateSize = 64 << 10
+
+// NewDatabase returns the database called name keeping its log in dir, as
+// <name>.bin rotated into <name>.bin_<timestamp> segments once it grows past
+// rotateSize bytes, which must be at least MinRotateSize. Several databases
+// can share a directory as long as their names differ. The directory is
+// created when the log is opened.
+func NewDatabase(dir, name string, rotateSize int64, opts ...Option) *Database {
+	db := &Database{
+		writeAhead:    make([]*contract.LogEntry, 0),
+		logFile:       filepath.Join(dir, name+".bin"),
+		name:          name,
+		rotateSize:    rotateSize,
+		syncMode:      SyncEvery,
+		recordEncoder: recordEncoder{codec: ProtoCodec},
+		done:          make(chan struct{}),
+		sweepInterval: time.Second,
+		shardCount:    defaultShardCount,
+		watchBuffer:   defaultWatchBuffer,
+		idempotency:   idempotencyWindow{ttl: defaultIdempotencyTTL, max: defaultIdempotencyTokens},
+
+		logSampleFirst:      defaultLogSampleFirst,
+		logSampleThereafter: defaultLogSampleThereafter,
+	}
+
+	for _, opt := range opts {
+		opt(db)
+	}
+
+	if db.logger == nil {
+		db.logger = zap.L()
+	}
+	if db.clock == nil {
+		db.clock = RealClock{}
+	}
+	db.opLogger = sampledLogger(db.logger, db.logSampleFirst, db.logSampleThereafter)
+
+	db.shards = make([]*shard, db.shardCount)
+	for i := range db.shards {
+		db.shards[i] = newShard(db.clock)
+	}
+
+	if db.shardLogs {
+		db.shardLogRanges = make(map[uint64]uint64)
+		db.shardLogSynced = sync.NewCond(&db.logFileLock)
+	}
+	if db.syncMode == SyncGroupCommit {
+		db.commits = make(chan *commitRequest)
+		go db.groupCommitter()
+	}
+	if !db.readOnly {
+		// Expired keys read as missing anyway, a read-only database has no
+		// log to record their eviction in
+		go db.sweepExpired()
+	}
+	if db.rotateInterval > 0 {
+		go db.rotateOnInterval()
+	}
+	if db.compactionPolicy != nil && !db.readOnly {
+		go db.compactOnPolicy()
+	}
+	if db.retention != nil && !db.readOnly {
+		go db.retainSegments()
+	}
+
+	return db
+}
+
+// OpenLogFile opens the log file for appending, creating it and its directory
+// if needed, and fails if the name or rotate size given to NewDatabase is
+// invalid, or if WithShardLogs is given with options it doesn't support. It
+// locks the log for as long as it's open, so a second database opening it
+// fails with ErrLocked rather than interleaving appends. It's a no-op for a
+// database given another LogStore, for a read-only database, which only ever
+// reads the log files during replay, and for one created with
+// NewMemoryDatabase, which has no log.
+func (db *Database) OpenLogFile() error {
+	if db.shardLogs {
+		err := db.checkShardLogOptions()
+		if err != nil {
+			return err
+		}
+	}
+	if db.store != nil || db.readOnly || db.memory {
+		return nil
+	}
+
+	if db.name == "" || filepath.Base(db.name) != db.name {
+		return fmt.Errorf("invalid database name %q, it must be a plain file name", db.name)
+	}
+	if db.rotateSize < MinRotateSize {
+		return fmt.Errorf("invalid rotate size %d, it must be at least %d bytes", db.rotateSize, MinRotateSize)
+	}
+	err := os.MkdirAll(filepath.Dir(db.logFile), 0755)
+	if err != nil {
+		return err
+	}
+
+	// The active file is renamed away on rotation, so the lock is taken on a
+	// file of its own that stays put
+	lock, err := lockFile(db.logFile + ".lock")
+	if err != nil {
+		return err
+	}
+	var audit *auditLog
+	if db.auditPath != "" {
+		audit, err = openAuditLog(db.auditPath, db.logger)
+		if err != nil {
+			_ = lock.Close()
+			return err
+		}
+	}
+	closeOnError := func() {
+		_ = lock.Close()
+		if audit != nil {
+			_ = audit.close()
+		}
+	}
+
+	if db.shardLogs {
+		store, err := db.openShardLogStore()
+		if err != nil {
+			closeOnError()
+			return err
+		}
+		db.store = store
+		db.lock = lock
+		db.audit = audit
+		return db.closeOpenBatch()
+	}
+
+	store, err := openFileLogStore(db.logFile, db.rotateSize, db.codec, db.strictReplay, db.clock, db.logger)
+	if err != nil {
+		closeOnError()
+		return err
+	}
+	store.reencode = db.reencode
+	store.setWriteBuffer(db.writeBuffer)
+	store.noRotationSync = db.noRotationSync
+	if db.retention != nil {
+		store.onRotate = db.requestRetention
+		// Segments may have piled up while the database was down
+		db.requestRetention()
+	}
+
+	db.store = store
+	db.lock = lock
+	db.audit = audit
+	return db.closeOpenBatch()
+}
+
+// closeOpenBatch logs an empty batch if replay ended on the records of a batch
+// a crash kept from committing. Replay would take whatever is logged after them
+// for part of that batch otherwise, and discard it along with it. It's a no-op
+// until the log is open.
+func (db *Database) closeOpenBatch() error {
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if !db.openBatch || db.store == nil {
+		return nil
+	}
+
+	err := db.appendLogFile(&contract.LogEntry{Op: BATCH_BEGIN}, &contract.LogEntry{Op: BATCH_COMMIT})
+	if err != nil {
+		return err
+	}
+	err = db.flush()
+	if err != nil {
+		return err
+	}
+	db.openBatch = false
+	return nil
+}
+
+// CloseLogFile closes the log, if it's open, and releases its lock.
+func (db *Database) CloseLogFile() error {
+	if db.store == nil {
+		return nil
+	}
+
+	if closer, ok := db.store.(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			return err
+		}
+	}
+	db.store = nil
+
+	if db.audit != nil {
+		err := db.audit.close()
+		db.audit = nil
+		if err != nil {
+			return err
+		}
+	}
+	if db.lock != nil {
+		err := db.lock.Close()
+		db.lock = nil
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set sets key to value and reports whether it inserted the key, updated it,
+// or left it unchanged because it already held value, in which case nothing is
+// logged. The result is Unchanged whenever err is set, except for a failure to
+// replicate, which comes after the write was applied locally.
+func (db *Database) Set(key string, value []byte) (Result, error) {
+	return db.SetContext(context.Background(), key, value)
+}
+
+// MustSet is Set for callers that don't care what it did, like before Set
+// returned a Result.
+func (db *Database) MustSet(key string, value []byte) error {
+	_, err := db.Set(key, value)
+	return err
+}
+
+// SetContext is Set, aborting with ctx.Err() if ctx is done before the write
+// gets hold of the lock.
+func (db *Database) SetContext(ctx context.Context, key string, value []byte) (Result, error) {
+	timer := prometheus.NewTimer(setDuration)
+	defer timer.ObserveDuration()
+
+	setsTotal.Inc()
+	return db.set(ctx, key, value, 0, nil)
+}
+
+// set writes key with the given expiry in unix nanoseconds, 0 for none, and
+// metadata.
+func (db *Database) set(ctx context.Context, key string, value []byte, expiresAt int64, metadata map[string]string) (result Result, err error) {
+	if db.readOnly {
+		return Unchanged, ErrReadOnly
+	}
+
+	start := time.Now()
+	defer func() {
+		db.logOp("set", key, len(value), start, err)
+	}()
+
+	err = db.admit(ctx, 1, len(key)+len(value))
+	if err != nil {
+		return Unchanged, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	err = lockContext(ctx, &s.mu)
+	if err != nil {
+		return Unchanged, err
+	}
+	if db.closed {
+		s.mu.Unlock()
+		return Unchanged, ErrClosed
+	}
+
+	result, err = db.setLocked(key, value, expiresAt, metadata)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return Unchanged, err
+	}
+
+	// Wait for followers outside the lock so other writes aren't held up
+	return result, leader.awaitAcks(seq)
+}
+
+// setOp returns the op of the record setting key to value with the given
+// expiry and metadata would log, false if dedup is set and the key already
+// holds them, so nothing would be written. Callers must hold the shard lock.
+func (s *shard) setOp(key string, value []byte, expiresAt int64, metadata map[string]string, dedup bool) (uint32, bool) {
+	val, ok := s.lookup(key)
+	switch {
+	case !ok:
+		return INSERT, true
+	case !dedup || !bytes.Equal(val, value) || s.expiry[key] != expiresAt || !equalMetadata(s.meta[key].metadata, metadata):
+		return UPDATE, true
+	default:
+		return 0, false
+	}
+}
+
+// setLocked is set for callers that already hold the write lock of the key's
+// shard.
+func (db *Database) setLocked(key string, value []byte, expiresAt int64, metadata map[string]string) (Result, error) {
+	err := db.checkLimits(key, value)
+	if err != nil {
+		return Unchanged, err
+	}
+
+	s := db.shardFor(key)
+
+	op, changed := s.setOp(key, value, expiresAt, metadata, !db.noDedup)
+	if !changed {
+		// Value is the same, we don't want to append log or update in-memory database
+		return Unchanged, nil
+	}
+	logEntry := &contract.LogEntry{
+		Op:        op,
+		Key:       key,
+		Value:     value,
+		ExpiresAt: expiresAt,
+		Version:   db.versionSeq.Add(1),
+		Timestamp: db.writeTimestamp(),
+		Metadata:  copyMetadata(metadata),
+	}
+
+	// Log before updating memory, so the write is tracked against the value it
+	// replaces until it's synced
+	err = db.writeLogEntries(logEntry)
+	if err != nil {
+		return Unchanged, err
+	}
+
+	// Update in-memory database
+	s.put(key, value, logEntry.Version)
+	s.setExpiry(key, expiresAt)
+	s.setMeta(key, logEntry.Timestamp, logEntry.Metadata)
+	return resultOf(op), nil
+}
+
+// writeLogEntries appends records to the log file and hands them to the
+// replication leader, if there is one. Callers must hold the write locks of the
+// shards of every key logged, so that writes to a key reach the log in the same
+// order they're applied in memory.
+func (db *Database) writeLogEntries(logEntries ...*contract.LogEntry) error {
+	if db.readOnly {
+		// Every write logs before it changes anything, so this turns away
+		// all of them
+		return ErrReadOnly
+	}
+	if db.partialReplay.Load() && !db.partialAccepted.Load() {
+		return ErrPartialReplay
+	}
+	if db.frozen.Load() {
+		// Writes wait out a freeze before taking their locks, see
+		// enterWrite, this only catches those that don't
+		return ErrFrozen
+	}
+	if db.syncMode == SyncGroupCommit {
+		return db.groupCommit(logEntries)
+	}
+	if store, ok := db.store.(*shardLogStore); ok && db.syncMode == SyncOnCommit {
+		return db.commitShardLogs(store, logEntries)
+	}
+
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	err := db.reserveBuffer(len(logEntries))
+	if err != nil {
+		return err
+	}
+
+	err = db.appendLogFile(logEntries...)
+	if err != nil {
+		return err
+	}
+	db.trackUnsynced(logEntries)
+
+	if db.leader != nil {
+		db.leader.append(logEntries)
+	}
+	return nil
+}
+
+// appendLogFile appends records to the log. A LogStore never splits a record,
+// so a crash can only lose whole records, and the batch markers written around
+// the records of a batch take care of batches. Callers must hold logFileLock.
+func (db *Database) appendLogFile(logEntries ...*contract.LogEntry) error {
+	if db.store == nil {
+		db.publishWatched(logEntries)
+		return nil
+	}
+
+	_, sharded := db.store.(*shardLogStore)
+	records := make([][]byte, len(logEntries))
+	for i, logEntry := range logEntries {
+		if sharded {
+			logEntry.Seq = db.logSeq + uint64(i) + 1
+		}
+		record, err := db.encodeEntry(db.currentHeader(), logEntry)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	undo, err := db.appendRecords(records, logEntries)
+	if err != nil {
+		return err
+	}
+
+	// Under group commit the committer syncs its whole group right here
+	syncNow := db.syncMode == SyncGroupCommit || db.syncMode == SyncOnCommit && !sharded
+	if syncNow {
+		err = db.store.Sync()
+		db.syncErr = err
+		if err != nil {
+			// The write fails, so it must not be replayed either
+			undo()
+			return err
+		}
+	}
+	if db.audit != nil {
+		err = db.audit.append(records, syncNow)
+		if err != nil {
+			undo()
+			return err
+		}
+	}
+
+	db.logSeq += uint64(len(logEntries))
+	db.stageWatched(db.logSeq, logEntries)
+	if syncNow {
+		db.syncedSeq.Store(db.logSeq)
+		db.lastSync = db.clock.Now()
+		db.releaseWatched(db.logSeq)
+	} else if db.syncMode == SyncOnCommit {
+		// Sharded, synced by commitShardLogs or by the flush the caller
+		// ends with
+	} else {
+		db.writeAhead = append(db.writeAhead, logEntries...)
+	}
+
+	return nil
+}
+
+// appendRecords appends the encoded records of entries to the log as a single
+// write and returns a func that takes them back. The records a failed write
+// appended before failing are taken back too, so the log never holds records
+// that weren't applied in memory, as long as the LogStore supports Truncate.
+// Callers must hold logFileLock.
+func (db *Database) appendRecords(records [][]byte, entries []*contract.LogEntry) (func(), error) {
+	switch store := db.store.(type) {
+	case *FileLogStore:
+		return store.appendAll(records)
+	case *shardLogStore:
+		shards := make([]int, len(entries))
+		for i, entry := range entries {
+			shards[i] = shardIndex(entry.Key, len(store.logs))
+		}
+		return store.appendAll(records, shards)
+	}
+
+	undo := func(n int) {
+		err := db.store.Truncate(n)
+		if err != nil {
+			db.logger.Sugar().Warnf("Failed to take back the records of a failed write, replay may apply them: %v", err)
+		}
+	}
+	for i, record := range records {
+		err := db.store.Append(record)
+		if err != nil {
+			if i > 0 {
+				undo(i)
+			}
+			return nil, err
+		}
+	}
+	return func() { undo(len(records)) }, nil
+}
+
+// Flush writes out the records WithWriteBuffer holds back and syncs the log
+// file, and the audit log if there is one, to stable storage, so every write
+// that returned before it is durable once it returns nil. The records it made
+// durable then leave the flush buffer bounded by WithMaxBuffered, and Durable
+// returns the values they wrote. It's safe to call while writes go on: it
+// holds logFileLock, so writes only wait for it while it syncs. Under SyncNone
+// it only writes the records out, leaving their durability to the OS, so
+// Durable, Stats().LastSync and Watch don't count them as synced until Close
+// syncs the log. A closed database fails with ErrClosed.
+func (db *Database) Flush() error {
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.flush()
+}
+
+func (db *Database) flush() error {
+	if db.syncMode != SyncNone {
+		return db.syncLog()
+	}
+	if db.store == nil {
+		return nil
+	}
+
+	// SyncNone only writes out what the write buffer holds back and leaves
+	// the rest to the OS, so none of it counts as synced
+	var err error
+	if store, ok := db.store.(*FileLogStore); ok {
+		err = store.writeOut()
+	}
+	db.syncErr = err
+	if err != nil {
+		return err
+	}
+	db.writeAhead = db.writeAhead[:0]
+	return nil
+}
+
+// syncLog writes out the records the write buffer holds back and syncs the log
+// file, and the audit log if there is one, whatever the sync mode. Callers
+// must hold logFileLock.
+func (db *Database) syncLog() error {
+	if db.store == nil {
+		return nil
+	}
+
+	err := db.store.Sync()
+	if err == nil && db.audit != nil {
+		err = db.audit.sync()
+	}
+	db.syncErr = err
+	if err != nil {
+		return err
+	}
+
+	db.syncedSeq.Store(db.logSeq)
+	db.lastSync = db.clock.Now()
+	db.writeAhead = db.writeAhead[:0]
+	db.releaseWatched(db.logSeq)
+	if db.shardLogSynced != nil {
+		db.shardLogSynced.Broadcast()
+	}
+	return nil
+}
+
+// Close flushes and syncs the log file, even under SyncNone, closes it, and
+// marks the database as closed so that later operations fail with ErrClosed.
+// Closing an already closed database is a no-op.
+func (db *Database) Close() error {
+	lockShards(db.shards)
+	defer unlockShards(db.shards)
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.closed {
+		return nil
+	}
+
+	err := db.syncLog()
+	if err != nil {
+		return err
+	}
+
+	err = db.CloseLogFile()
+	if err != nil {
+		return err
+	}
+
+	db.closed = true
+	close(db.done)
+	return nil
+}
+
+// Get returns a copy of the value of key, or ErrKeyNotFound if it isn't set.
+func (db *Database) Get(key string) ([]byte, error) {
+	return db.GetContext(context.Background(), key)
+}
+
+// GetContext is Get, aborting with ctx.Err() if ctx is done before the read
+// gets hold of the lock.
+func (db *Database) GetContext(ctx context.Context, key string) ([]byte, error) {
+	getsTotal.Inc()
+
+	err := db.checkKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := db.shardFor(key)
+	err = rLockContext(ctx, &s.mu)
+	if err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	value, ok := s.lookup(key)
+	if !ok {
+		getMissesTotal.Inc()
+		return nil, ErrKeyNotFound
+	}
+
+	// Hand out a copy, a caller mutating the stored slice would silently
+	// corrupt the in-memory database
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied, nil
+}
+
+// GetMany returns copies of the values of keys, taking the read lock of each
+// shard involved once. Missing keys are left out of the result rather than
+// failing the whole read.
+func (db *Database) GetMany(keys []string) (map[string][]byte, error) {
+	return db.GetManyContext(context.Background(), keys)
+}
+
+// GetManyContext is GetMany, aborting with ctx.Err() if ctx is done before the
+// read takes the locks.
+func (db *Database) GetManyContext(ctx context.Context, keys []string) (map[string][]byte, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	getsTotal.Add(float64(len(keys)))
+
+	for _, key := range keys {
+		err := db.checkKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	shards := db.shardsOf(keys)
+	rLockShards(shards)
+	defer rUnlockShards(shards)
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	result := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		value, ok := db.shardFor(key).lookup(key)
+		if !ok {
+			getMissesTotal.Inc()
+			continue
+		}
+		copied := make([]byte, len(value))
+		copy(copied, value)
+		result[key] = copied
+	}
+	return result, nil
+}
+
+// Exists reports whether key is set, without copying its value. It's false
+// once the database is closed.
+func (db *Database) Exists(key string) bool {
+	s := db.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db.closed {
+		return false
+	}
+
+	_, ok := s.lookup(key)
+	return ok
+}
+
+// Delete deletes key and reports whether it was set. Deleting a missing key is
+// a no-op that writes nothing to the log.
+func (db *Database) Delete(key string) (bool, error) {
+	return db.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete, aborting with ctx.Err() if ctx is done before the
+// write gets hold of the lock.
+func (db *Database) DeleteContext(ctx context.Context, key string) (existed bool, err error) {
+	if db.readOnly {
+		return false, ErrReadOnly
+	}
+	deletesTotal.Inc()
+
+	start := time.Now()
+	defer func() {
+		db.logOp("delete", key, 0, start, err)
+	}()
+
+	err = db.admit(ctx, 1, len(key))
+	if err != nil {
+		return false, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	err = lockContext(ctx, &s.mu)
+	if err != nil {
+		return false, err
+	}
+	if db.closed {
+		s.mu.Unlock()
+		return false, ErrClosed
+	}
+
+	existed, err = db.deleteLocked(key)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	return existed, leader.awaitAcks(seq)
+}
+
+// deleteLocked is Delete for callers that already hold the write lock of the
+// key's shard.
+func (db *Database) deleteLocked(key string) (bool, error) {
+	err := db.checkKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	s := db.shardFor(key)
+
+	// Deleting a missing key is a no-op, we don't want to bloat the log
+	if _, ok := s.lookup(key); !ok {
+		return false, nil
+	}
+
+	logEntry := &contract.LogEntry{
+		Op:        DELETE,
+		Key:       key,
+		Timestamp: db.writeTimestamp(),
+	}
+
+	err = db.writeLogEntries(logEntry)
+	if err != nil {
+		return false, err
+	}
+
+	// Update in-memory database
+	s.remove(key)
+	return true, nil
+}
+
+// ReplayStats describes what ReplayWriteAheadLog read and applied.
+type ReplayStats struct {
+	// Records is the number of records read and Bytes their size in the log
+	Records int
+	Bytes   int64
+	// Inserts, Updates and Deletes count the records applied by op, merges
+	// counting as updates. Records of a batch that was never committed
+	// aren't applied.
+	Inserts int
+	Updates int
+	Deletes int
+	// SkippedCorrupt counts the records skipped for failing their checksum
+	SkippedCorrupt int
+	Elapsed        time.Duration
+}
+
+// Applied returns the number of records applied to the database.
+func (s ReplayStats) Applied() int {
+	return s.Inserts + s.Updates + s.Deletes
+}
+
+// replayProgressInterval is the number of records between two progress
+// reports during replay.
+const replayProgressInterval = 10000
+
+// ReplayWriteAheadLog loads the database from its log. If progress isn't nil
+// it's called with the stats so far every few thousand records and once more
+// when replay is done.
+func (db *Database) ReplayWriteAheadLog(progress func(ReplayStats)) (ReplayStats, error) {
+	stats, _, err := db.replayWriteAheadLog(progress, time.Time{})
+	return stats, err
+}
+
+// replayWriteAheadLog is ReplayWriteAheadLog stopping at deadline if it's set,
+// in which case it returns where it stopped.
+func (db *Database) replayWriteAheadLog(progress func(ReplayStats), deadline time.Time) (ReplayStats, *replayDeadlineError, error) {
+	if db.memory {
+		return ReplayStats{}, nil, nil
+	}
+
+	sugar := db.logger.Sugar()
+	sugar.Infof("Replaying write-ahead log")
+
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+	lockShards(db.shards)
+	defer unlockShards(db.shards)
+
+	if db.partialReplay.Load() {
+		// Start over rather than replay the records applied already a
+		// second time
+		for _, s := range db.shards {
+			s.reset()
+		}
+	}
+
+	r := &replayer{db: db, stats: &ReplayStats{}, progress: progress, started: time.Now(), deadline: deadline}
+	err := db.replayLog(r)
+	r.report()
+	var stopped *replayDeadlineError
+	if errors.As(err, &stopped) {
+		db.partialReplay.Store(true)
+		db.partialAccepted.Store(false)
+		db.rebuildBloomsLocked()
+		replayDuration.Set(r.stats.Elapsed.Seconds())
+		sugar.Warnf("Replay stopped at its deadline at offset %d of %s after %d records", stopped.offset, stopped.path, r.stats.Records)
+		return *r.stats, stopped, nil
+	}
+	if err != nil {
+		return *r.stats, nil, err
+	}
+	db.partialReplay.Store(false)
+	db.openBatch = r.inBatch && !db.readOnly
+	err = db.closeOpenBatch()
+	if err != nil {
+		return *r.stats, nil, err
+	}
+
+	// Replay drops keys that were deleted or expired, rebuilding sizes the
+	// filters for what's left
+	db.rebuildBloomsLocked()
+
+	stats := *r.stats
+	replayDuration.Set(stats.Elapsed.Seconds())
+	sugar.Infof("Replayed %d records (%d bytes) in %s, %d inserts, %d updates, %d deletes, %d corrupt records skipped",
+		stats.Records, stats.Bytes, stats.Elapsed, stats.Inserts, stats.Updates, stats.Deletes, stats.SkippedCorrupt)
+	return stats, nil, nil
+}
+
+// replayLog replays the whole log through r, starting from the checkpoint if
+// there is one. Callers must hold compactLock and every shard lock.
+func (db *Database) replayLog(r *replayer) error {
+	if db.shardLogs {
+		// Whatever was logged before the database switched to shard logs
+		// comes first
+		err := db.withLogFiles(func(segments []string) error {
+			return db.replaySegments(r, segments)
+		})
+		if err != nil {
+			return err
+		}
+		return db.replayShardLogs(r)
+	}
+	if _, ok := db.store.(*FileLogStore); db.store != nil && !ok {
+		err := db.store.ReadAll(func(record []byte) error {
+			return r.replay(db.currentHeader(), record)
+		})
+		if err != nil {
+			return err
+		}
+		r.finish("the log")
+		return nil
+	}
+
+	return db.withLogFiles(func(segments []string) error {
+		return db.replaySegments(r, segments)
+	})
+}
+
+// replaySegments replays segments through r, starting from the checkpoint if
+// there is one.
+func (db *Database) replaySegments(r *replayer, segments []string) error {
+	sugar := db.logger.Sugar()
+
+	checkpoint, err := db.loadCheckpoint(r)
+	if err != nil {
+		return err
+	}
+
+	var files []segmentStart
+	for _, segment := range segments {
+		start := int64(0)
+		if checkpoint != nil && segment != db.logFile {
+			switch {
+			case segment < checkpoint.segment:
+				// Covered by the checkpoint, never read it again
+				continue
+			case segment == checkpoint.segment:
+				start = checkpoint.offset
+			}
+		}
+		files = append(files, segmentStart{path: segment, start: start})
+	}
+
+	if db.parallelReplay {
+		return r.replayParallel(files)
+	}
+
+	for _, file := range files {
+		sugar.Infof("Replaying segment %s", file.path)
+		err := r.replayFile(file.path, file.start)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// segmentStart is a log file to replay from offset start on.
+type segmentStart struct {
+	path  string
+	start int64
+}
+
+// replayFile replays the records of a log file from offset start on, zero
+// meaning the first record.
+func (db *Database) replayFile(path string, start int64) error {
+	r := &replayer{db: db, stats: &ReplayStats{}}
+	return r.replayFile(path, start)
+}
+
+// replayer applies replayed records to the database, holding back the records
+// of a batch until its commit marker, and keeps count of them in stats.
+type replayer struct {
+	db      *Database
+	batch   []*contract.LogEntry
+	inBatch bool
+
+	stats    *ReplayStats
+	progress func(ReplayStats)
+	started  time.Time
+	// deadline stops replay when set, see ReplayWriteAheadLogWithTimeout
+	deadline time.Time
+}
+
+func (r *replayer) replayFile(path string, start int64) error {
+	opts := r.db.replayReadOptions()
+	opts.deadline = r.deadline
+	return r.readFile(path, start, opts)
+}
+
+// readFile is replayFile reading the file with opts instead of the options
+// of the database's own log.
+func (r *replayer) readFile(path string, start int64, opts logReadOptions) error {
+	skipped, err := readLogFile(r.db.logger, path, start, opts, r.replay)
+	r.stats.SkippedCorrupt += skipped
+	if err != nil {
+		return err
+	}
+	r.finish(path)
+	return nil
+}
+
+// replayReadOptions returns how replay reads log files.
+func (db *Database) replayReadOptions() logReadOptions {
+	return logReadOptions{
+		strict:    db.strictReplay,
+		readOnly:  db.readOnly,
+		mmap:      db.mmapReplay,
+		truncated: db.truncatedLogFile,
+	}
+}
+
+// truncatedLogFile brings the open log file store in line with the active file
+// after replay cut it to size, so appends, their undos and rotation by size go
+// by the size the file really has. Callers must hold logFileLock, which replay
+// does through withLogFiles.
+func (db *Database) truncatedLogFile(path string, size int64) {
+	store, ok := db.store.(*FileLogStore)
+	if ok && path == store.path {
+		store.truncated(size)
+	}
+}
+
+func (r *replayer) replay(header logHeader, record []byte) error {
+	entry := &contract.LogEntry{}
+	err := r.db.decodeLogEntry(header, record, entry)
+	if err != nil {
+		return err
+	}
+
+	return r.replayEntry(entry, int64(recordPrefixSize(header.version)+len(record)))
+}
+
+// replayEntry replays a decoded record that took up size bytes in the log.
+func (r *replayer) replayEntry(entry *contract.LogEntry, size int64) error {
+	r.stats.Records++
+	r.stats.Bytes += size
+	if r.stats.Records%replayProgressInterval == 0 {
+		r.report()
+	}
+
+	var err error
+	switch {
+	case entry.Op == BATCH_BEGIN:
+		if r.inBatch {
+			r.db.logger.Sugar().Warnf("Discarding %d records of an uncommitted batch", len(r.batch))
+		}
+		r.batch = r.batch[:0]
+		r.inBatch = true
+	case entry.Op == BATCH_COMMIT:
+		for _, batchEntry := range r.batch {
+			err = r.apply(batchEntry)
+			if err != nil {
+				return err
+			}
+		}
+		r.batch = r.batch[:0]
+		r.inBatch = false
+	case entry.Op == CHECKPOINT:
+		// Only marks where the log resumes, see loadCheckpoint
+	case r.inBatch:
+		r.batch = append(r.batch, entry)
+	default:
+		return r.apply(entry)
+	}
+	return nil
+}
+
+// apply applies a replayed record and counts it.
+func (r *replayer) apply(entry *contract.LogEntry) error {
+	err := r.db.applyLogEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	switch entry.Op {
+	case INSERT:
+		r.stats.Inserts++
+	case UPDATE, MERGE:
+		r.stats.Updates++
+	case DELETE:
+		r.stats.Deletes++
+	}
+	return nil
+}
+
+// report hands the stats so far to the progress callback, if there is one.
+func (r *replayer) report() {
+	r.stats.Elapsed = time.Since(r.started)
+	if r.progress != nil {
+		r.progress(*r.stats)
+	}
+}
+
+// finish reports a batch left open at the end of what was replayed.
+func (r *replayer) finish(source string) {
+	if r.inBatch {
+		// We crashed before the batch was committed, none of it should apply
+		r.db.logger.Sugar().Warnf("Discarding %d records of an uncommitted batch in %s", len(r.batch), source)
+	}
+}
+
+// applyLogEntry replays a single record against the in-memory database.
+// Callers must hold the write lock of the key's shard.
+func (db *Database) applyLogEntry(entry *contract.LogEntry) error {
+	s := db.shardFor(entry.Key)
+	switch entry.Op {
+	case INSERT, UPDATE:
+		if entry.ExpiresAt != 0 && entry.ExpiresAt <= db.clock.Now().UnixNano() {
+			// Already expired by the time we recover, don't load it
+			s.remove(entry.Key)
+			break
+		}
+		s.put(entry.Key, entry.Value, db.observeVersion(entry.Version))
+		s.setExpiry(entry.Key, entry.ExpiresAt)
+		s.setMeta(entry.Key, db.observeTimestamp(entry.Timestamp), entry.Metadata)
+	case DELETE:
+		db.observeTimestamp(entry.Timestamp)
+		s.remove(entry.Key)
+	case MERGE:
+		return db.applyMerge(entry)
+	default:
+		return fmt.Errorf("%w %d for key %q", ErrUnknownOp, entry.Op, entry.Key)
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// openTestDatabase opens and replays the database called test in dir, closing
+// it when the test ends.
+func openTestDatabase(t testing.TB, dir string, opts ...Option) *Database {
+	t.Helper()
+
+	opts = append([]Option{WithLogger(zap.NewNop())}, opts...)
+	db := NewDatabase(dir, "test", MinRotateSize, opts...)
+	err := db.OpenLogFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.ReplayWriteAheadLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// reopenTestDatabase closes db and opens the database in dir again.
+func reopenTestDatabase(t testing.TB, db *Database, dir string, opts ...Option) *Database {
+	t.Helper()
+
+	err := db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return openTestDatabase(t, dir, opts...)
+}
+
+// checkContents fails the test unless db holds exactly want.
+func checkContents(t testing.TB, db *Database, want map[string]string) {
+	t.Helper()
+
+	got := make(map[string]string)
+	db.ForEach(func(key string, value []byte) bool {
+		got[key] = string(value)
+		return true
+	})
+	if len(got) != len(want) {
+		t.Fatalf("got %d keys %v, want %d keys %v", len(got), got, len(want), want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("key %q holds %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestReplayRebuildsState(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  func(t *testing.T, db *Database)
+		want    map[string]string
+		inserts int
+		updates int
+		deletes int
+	}{
+		{
+			name: "inserts",
+			writes: func(t *testing.T, db *Database) {
+				mustSet(t, db, "a", "1")
+				mustSet(t, db, "b", "2")
+			},
+			want:    map[string]string{"a": "1", "b": "2"},
+			inserts: 2,
+		},
+		{
+			name: "updates keep the last value",
+			writes: func(t *testing.T, db *Database) {
+				mustSet(t, db, "a", "1")
+				mustSet(t, db, "a", "2")
+				mustSet(t, db, "a", "3")
+			},
+			want:    map[string]string{"a": "3"},
+			inserts: 1,
+			updates: 2,
+		},
+		{
+			name: "deletes remove the key",
+			writes: func(t *testing.T, db *Database) {
+				mustSet(t, db, "a", "1")
+				mustSet(t, db, "b", "2")
+				mustDelete(t, db, "a")
+			},
+			want:    map[string]string{"b": "2"},
+			inserts: 2,
+			deletes: 1,
+		},
+		{
+			name: "insert after delete",
+			writes: func(t *testing.T, db *Database) {
+				mustSet(t, db, "a", "1")
+				mustDelete(t, db, "a")
+				mustSet(t, db, "a", "2")
+			},
+			want:    map[string]string{"a": "2"},
+			inserts: 2,
+			deletes: 1,
+		},
+		{
+			name: "deleting a missing key logs nothing",
+			writes: func(t *testing.T, db *Database) {
+				mustSet(t, db, "a", "1")
+				mustDelete(t, db, "b")
+			},
+			want:    map[string]string{"a": "1"},
+			inserts: 1,
+		},
+		{
+			name: "batch",
+			writes: func(t *testing.T, db *Database) {
+				mustSet(t, db, "a", "1")
+				b := db.Batch()
+				b.Set("b", []byte("2"))
+				b.Delete("a")
+				err := b.Commit()
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:    map[string]string{"b": "2"},
+			inserts: 2,
+			deletes: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := openTestDatabase(t, dir)
+			tt.writes(t, db)
+			err := db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db = NewDatabase(dir, "test", MinRotateSize, WithLogger(zap.NewNop()))
+			t.Cleanup(func() { _ = db.Close() })
+			stats, err := db.ReplayWriteAheadLog(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkContents(t, db, tt.want)
+			if stats.Inserts != tt.inserts || stats.Updates != tt.updates || stats.Deletes != tt.deletes {
+				t.Fatalf("replayed %d inserts, %d updates and %d deletes, want %d, %d and %d",
+					stats.Inserts, stats.Updates, stats.Deletes, tt.inserts, tt.updates, tt.deletes)
+			}
+		})
+	}
+}
+
+// TestConcurrentSetGet is meant to run under -race, which flags reads of the
+// shards that don't hold their lock.
+func TestConcurrentSetGet(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir(), WithShards(4))
+
+	const writers, keys = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				_, err := db.Set(key, []byte(fmt.Sprintf("%d-%d", w, i)))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if i%10 == 0 {
+					_, err = db.Delete(key)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				_, err := db.Get(fmt.Sprintf("key-%d", i))
+				if err != nil && !errors.Is(err, ErrKeyNotFound) {
+					t.Error(err)
+					return
+				}
+				db.Len()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every key written last by some writer holds one of their values
+	for i := 0; i < keys; i++ {
+		value, err := db.Get(fmt.Sprintf("key-%d", i))
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var w, j int
+		_, err = fmt.Sscanf(string(value), "%d-%d", &w, &j)
+		if err != nil || j != i {
+			t.Fatalf("key-%d holds %q", i, value)
+		}
+	}
+}
+
+func mustSet(t testing.TB, db *Database, key, value string) {
+	t.Helper()
+
+	_, err := db.Set(key, []byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustDelete(t testing.TB, db *Database, key string) {
+	t.Helper()
+
+	_, err := db.Delete(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingLogStore is a MemoryLogStore whose appends and syncs fail with err
+// once it's set, like a full disk would.
+type failingLogStore struct {
+	*MemoryLogStore
+	appendErr, syncErr error
+}
+
+func (s *failingLogStore) Append(record []byte) error {
+	if s.appendErr != nil {
+		return s.appendErr
+	}
+	return s.MemoryLogStore.Append(record)
+}
+
+func (s *failingLogStore) Sync() error {
+	if s.syncErr != nil {
+		return s.syncErr
+	}
+	return s.MemoryLogStore.Sync()
+}
+
+func TestFailedWriteLeavesStateUnchanged(t *testing.T) {
+	for _, failSync := range []bool{false, true} {
+		store := &failingLogStore{MemoryLogStore: NewMemoryLogStore()}
+		db := openTestDatabase(t, t.TempDir(), WithLogStore(store), WithSyncMode(SyncOnCommit))
+		mustSet(t, db, "a", "1")
+		mustSet(t, db, "b", "1")
+		_, version, err := db.GetVersion("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failSync {
+			store.syncErr = syscall.ENOSPC
+		} else {
+			store.appendErr = syscall.ENOSPC
+		}
+		_, err = db.Set("a", []byte("2"))
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Fatalf("Set returned %v, want ENOSPC", err)
+		}
+		_, err = db.Set("c", []byte("2"))
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Fatalf("Set of a new key returned %v, want ENOSPC", err)
+		}
+		_, err = db.Delete("b")
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Fatalf("Delete returned %v, want ENOSPC", err)
+		}
+		b := db.Batch()
+		b.Set("a", []byte("3"))
+		b.Delete("b")
+		err = b.Commit()
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Fatalf("batch returned %v, want ENOSPC", err)
+		}
+
+		// None of the failed writes is visible
+		checkContents(t, db, map[string]string{"a": "1", "b": "1"})
+		_, got, err := db.GetVersion("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != version {
+			t.Fatalf("failed writes moved key a from version %d to %d", version, got)
+		}
+
+		// Writes go through again once there's room
+		store.appendErr, store.syncErr = nil, nil
+		mustSet(t, db, "a", "4")
+		checkContents(t, db, map[string]string{"a": "4", "b": "1"})
+
+		// Records whose append or sync failed were taken back, replay
+		// doesn't apply them either
+		replayed := openTestDatabase(t, t.TempDir(), WithLogStore(store.MemoryLogStore))
+		checkContents(t, replayed, map[string]string{"a": "4", "b": "1"})
+	}
+}
+
+func TestSyncNoneFlushDoesNotCountAsSync(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir, WithSyncMode(SyncNone))
+	events, cancel := db.Watch("")
+	defer cancel()
+	mustSet(t, db, "a", "1")
+	err := db.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The flush only wrote the record out, nothing says it survives a crash
+	if _, err := db.Durable("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Durable found a after a SyncNone flush: %v", err)
+	}
+	if stats := db.Stats(); !stats.LastSync.IsZero() {
+		t.Fatalf("last sync at %v after a SyncNone flush, want none", stats.LastSync)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("watch delivered %+v before the record was synced", event)
+	default:
+	}
+
+	// Close syncs whatever the mode
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		if event.Key != "a" {
+			t.Fatalf("watch delivered %+v, want the write of a", event)
+		}
+	default:
+		t.Fatal("watch delivered nothing once Close synced the log")
+	}
+}
+
+func TestContextVariantsAbortOnDoneContext(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir())
+	mustSet(t, db, "key", "value")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := db.DeleteContext(ctx, "key")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DeleteContext returned %v, want context.Canceled", err)
+	}
+	_, err = db.ScanContext(ctx, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ScanContext returned %v, want context.Canceled", err)
+	}
+	_, err = db.ScanKeysContext(ctx, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ScanKeysContext returned %v, want context.Canceled", err)
+	}
+	_, err = db.GetManyContext(ctx, []string{"key"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetManyContext returned %v, want context.Canceled", err)
+	}
+	checkContents(t, db, map[string]string{"key": "value"})
+}
+
+// BenchmarkSetSyncModes compares the durability modes on single-threaded
+// writes: SyncOnCommit pays an fsync on every write, the others leave it to
+// the periodic flush or to the OS.
+func BenchmarkSetSyncModes(b *testing.B) {
+	modes := []struct {
+		name string
+		mode SyncMode
+	}{
+		{name: "SyncEvery", mode: SyncEvery},
+		{name: "SyncOnCommit", mode: SyncOnCommit},
+		{name: "SyncNone", mode: SyncNone},
+	}
+	value := []byte(strings.Repeat("v", 100))
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			db := openTestDatabase(b, b.TempDir(), WithSyncMode(m.mode))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := db.Set(fmt.Sprintf("key-%d", i), value)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
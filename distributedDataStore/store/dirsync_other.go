@@ -0,0 +1,8 @@
+//go:build !unix
+
+package store
+
+// syncDir does nothing where directories can't be opened to be synced.
+func syncDir(path string) error {
+	return nil
+}
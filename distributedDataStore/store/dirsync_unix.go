@@ -0,0 +1,20 @@
+//go:build unix
+
+package store
+
+import "os"
+
+// syncDir syncs the directory at path, so the files created in it and renamed
+// into or out of it survive a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	closeErr := dir.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
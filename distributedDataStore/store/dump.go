@@ -0,0 +1,308 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"personalMonorepo/distributedDataStore/contract"
+	"sort"
+	"time"
+)
+
+// The dump format is the interchange format of the database, for moving a
+// keyspace between databases and versions. Unlike the files Snapshot writes,
+// which are in the log format and change along with it, it doesn't depend on
+// the log, and any change to it comes with a new dumpVersion. A dump is
+//
+//	magic    "DDSD"
+//	version  1 byte, dumpVersion
+//	header   uvarint length, then a JSON object, see dumpHeader. Readers
+//	         ignore fields they don't know.
+//	entries  one per key, in key order:
+//	           tag        1 byte, 1
+//	           key        uvarint length, then the bytes
+//	           value      uvarint length, then the bytes
+//	           expires at varint, unix nanoseconds, 0 for none
+//	           version    uvarint
+//	           written at varint, unix nanoseconds, 0 if unknown
+//	           metadata   uvarint count, then that many key and value
+//	                      pairs, each a uvarint length and the bytes
+//	trailer  tag 1 byte, 0, the number of entries as a uvarint, and the
+//	         CRC32C of every byte before it, 4 bytes little-endian
+//
+// Values are stored as they're read, not compressed or encrypted.
+const (
+	dumpMagic   = "DDSD"
+	dumpVersion = 1
+
+	dumpEntryTag   = 1
+	dumpTrailerTag = 0
+
+	// maxDumpField bounds the length of a field, so a corrupt length
+	// doesn't allocate without end
+	maxDumpField = 1 << 32
+)
+
+// dumpHeader describes a dump. Fields lists the fields of every entry, in
+// order.
+type dumpHeader struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Keys    int       `json:"keys"`
+	Fields  []string  `json:"fields"`
+}
+
+var dumpFields = []string{"key", "value", "expires_at", "version", "timestamp", "metadata"}
+
+// Dump writes every live key, together with its TTL, version, write time and
+// metadata, to w in the dump format, and returns the number of keys written.
+// The keyspace is copied under the read lock of every shard, so the dump
+// reflects a single moment. Load reads it back.
+func (db *Database) Dump(w io.Writer) (int, error) {
+	rLockShards(db.shards)
+	if db.closed {
+		rUnlockShards(db.shards)
+		return 0, ErrClosed
+	}
+	entries := db.liveEntries()
+	rUnlockShards(db.shards)
+
+	d := &dumpWriter{w: bufio.NewWriter(w), crc: crc32.New(crcTable)}
+	header, err := json.Marshal(dumpHeader{
+		Version: dumpVersion,
+		Created: db.clock.Now().UTC(),
+		Keys:    len(entries),
+		Fields:  dumpFields,
+	})
+	if err != nil {
+		return 0, err
+	}
+	d.write([]byte(dumpMagic))
+	d.write([]byte{dumpVersion})
+	d.writeBytes(header)
+
+	for _, entry := range entries {
+		d.write([]byte{dumpEntryTag})
+		d.writeBytes([]byte(entry.Key))
+		d.writeBytes(entry.Value)
+		d.writeVarint(entry.ExpiresAt)
+		d.writeUvarint(entry.Version)
+		d.writeVarint(entry.Timestamp)
+
+		// In key order, so dumps of the same keyspace are identical
+		keys := make([]string, 0, len(entry.Metadata))
+		for key := range entry.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		d.writeUvarint(uint64(len(keys)))
+		for _, key := range keys {
+			d.writeBytes([]byte(key))
+			d.writeBytes([]byte(entry.Metadata[key]))
+		}
+	}
+
+	d.write([]byte{dumpTrailerTag})
+	d.writeUvarint(uint64(len(entries)))
+	d.write(binary.LittleEndian.AppendUint32(nil, d.crc.Sum32()))
+	if d.err == nil {
+		d.err = d.w.Flush()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return len(entries), nil
+}
+
+// Load applies the keys of a dump written by Dump over whatever the database
+// holds, like BulkLoad does for a stream of log records, and returns the
+// number of keys loaded. The keys keep their TTL, write time and metadata, and
+// get fresh versions. The keys are logged and applied in chunks as they're
+// read, so on error, including a dump that turns out to be cut short or to
+// fail its checksum, the keys read before it stay applied.
+func (db *Database) Load(r io.Reader) (int, error) {
+	return db.bulkLoad(func(fn func(entry *contract.LogEntry) error) error {
+		return db.readDump(r, fn)
+	})
+}
+
+// dumpWriter writes the fields of a dump, keeping the first error and the
+// checksum of everything written.
+type dumpWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	err error
+}
+
+func (d *dumpWriter) write(b []byte) {
+	if d.err != nil {
+		return
+	}
+	d.crc.Write(b)
+	_, d.err = d.w.Write(b)
+}
+
+func (d *dumpWriter) writeUvarint(v uint64) {
+	d.write(binary.AppendUvarint(nil, v))
+}
+
+func (d *dumpWriter) writeVarint(v int64) {
+	d.write(binary.AppendVarint(nil, v))
+}
+
+func (d *dumpWriter) writeBytes(b []byte) {
+	d.writeUvarint(uint64(len(b)))
+	d.write(b)
+}
+
+// readDump decodes the entries of a dump and calls fn with an INSERT record for
+// each, giving it a fresh version. It fails with ErrBadDump on anything
+// malformed, including a dump cut short or failing its checksum.
+func (db *Database) readDump(r io.Reader, fn func(entry *contract.LogEntry) error) error {
+	d := &dumpReader{r: bufio.NewReader(r), crc: crc32.New(crcTable)}
+
+	magic := d.read(len(dumpMagic))
+	if d.err == nil && string(magic) != dumpMagic {
+		return fmt.Errorf("%w: not a dump", ErrBadDump)
+	}
+	version := d.readByte()
+	if d.err == nil && version != dumpVersion {
+		return fmt.Errorf("%w: unsupported dump version %d", ErrBadDump, version)
+	}
+	var header dumpHeader
+	raw := d.readBytes()
+	if d.err == nil {
+		err := json.Unmarshal(raw, &header)
+		if err != nil {
+			return fmt.Errorf("%w: header: %v", ErrBadDump, err)
+		}
+	}
+
+	n := uint64(0)
+	for d.err == nil {
+		switch tag := d.readByte(); {
+		case d.err != nil:
+		case tag == dumpTrailerTag:
+			count := d.readUvarint()
+			sum := d.crc.Sum32()
+			stored := d.read(4)
+			if d.err != nil {
+				break
+			}
+			if count != n {
+				return fmt.Errorf("%w: %d entries, the trailer says %d", ErrBadDump, n, count)
+			}
+			if binary.LittleEndian.Uint32(stored) != sum {
+				return fmt.Errorf("%w: checksum mismatch", ErrBadDump)
+			}
+			return nil
+		case tag == dumpEntryTag:
+			entry := d.readEntry()
+			if d.err != nil {
+				break
+			}
+			db.restamp(entry)
+			err := fn(entry)
+			if err != nil {
+				return err
+			}
+			n++
+		default:
+			return fmt.Errorf("%w: unknown tag %d after %d entries", ErrBadDump, tag, n)
+		}
+	}
+
+	if errors.Is(d.err, io.EOF) || errors.Is(d.err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: cut short after %d entries", ErrBadDump, n)
+	}
+	return d.err
+}
+
+// dumpReader reads the fields of a dump, keeping the first error and the
+// checksum of everything read.
+type dumpReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (d *dumpReader) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, b)
+	d.crc.Write(b)
+	return b
+}
+
+func (d *dumpReader) readByte() byte {
+	b := d.read(1)
+	if d.err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *dumpReader) readUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	var v uint64
+	v, d.err = binary.ReadUvarint(byteCounter{d})
+	return v
+}
+
+func (d *dumpReader) readVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	var v int64
+	v, d.err = binary.ReadVarint(byteCounter{d})
+	return v
+}
+
+func (d *dumpReader) readBytes() []byte {
+	n := d.readUvarint()
+	if d.err == nil && n > maxDumpField {
+		d.err = fmt.Errorf("%w: field of %d bytes", ErrBadDump, n)
+	}
+	return d.read(int(n))
+}
+
+func (d *dumpReader) readEntry() *contract.LogEntry {
+	entry := &contract.LogEntry{Op: INSERT}
+	entry.Key = string(d.readBytes())
+	entry.Value = d.readBytes()
+	entry.ExpiresAt = d.readVarint()
+	entry.Version = d.readUvarint()
+	entry.Timestamp = d.readVarint()
+	count := d.readUvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		if entry.Metadata == nil {
+			entry.Metadata = make(map[string]string)
+		}
+		key := d.readBytes()
+		entry.Metadata[string(key)] = string(d.readBytes())
+	}
+	return entry
+}
+
+// byteCounter feeds the bytes of the varints it reads into the checksum of
+// the dump.
+type byteCounter struct {
+	d *dumpReader
+}
+
+func (b byteCounter) ReadByte() (byte, error) {
+	c, err := b.d.r.ReadByte()
+	if err == nil {
+		b.d.crc.Write([]byte{c})
+	}
+	return c, err
+}
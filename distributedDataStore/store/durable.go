@@ -0,0 +1,89 @@
+package store
+
+import (
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// durableState is what a key held as of the last sync of the log. It's kept
+// for keys written since, seq being the log position of the latest write.
+type durableState struct {
+	value     []byte
+	present   bool
+	expiresAt int64
+	seq       uint64
+}
+
+// Durable is Get restricted to state that survives a crash. It returns the
+// value key held as of the last sync of the log file, ignoring any write since
+// that a crash could still lose. Under SyncOnCommit, or without a log file,
+// every write counts as durable and Durable is the same as Get. Under SyncNone
+// the log is only synced by Close, so Durable keeps returning what key held
+// when the database was opened.
+//
+// Together with Set this gives a choice per read: Get sees every write the
+// moment it returns, Durable only sees writes once the periodic flush, or an
+// explicit Flush, has synced them.
+func (db *Database) Durable(key string) ([]byte, error) {
+	err := db.checkKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := db.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	value, ok := s.lookup(key)
+	if state, pending := s.pending[key]; pending && state.seq > db.syncedSeq.Load() {
+		value = state.value
+		ok = state.present && (state.expiresAt == 0 || state.expiresAt > db.clock.Now().UnixNano())
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied, nil
+}
+
+// trackUnsynced remembers the durable state of the keys of entries, which were
+// just appended to the log but not synced yet. It runs before the entries are
+// applied in memory, so for a key without unsynced writes so far the current
+// state is its durable state. Callers must hold logFileLock and the write locks
+// of the shards of the keys.
+func (db *Database) trackUnsynced(entries []*contract.LogEntry) {
+	if db.syncMode == SyncOnCommit || db.store == nil {
+		return
+	}
+
+	synced := db.syncedSeq.Load()
+	for _, entry := range entries {
+		if entry.Op == BATCH_BEGIN || entry.Op == BATCH_COMMIT {
+			continue
+		}
+
+		s := db.shardFor(entry.Key)
+		state, ok := s.pending[entry.Key]
+		if !ok || state.seq <= synced {
+			value, present := s.data[entry.Key]
+			state = &durableState{value: value, present: present, expiresAt: s.expiry[entry.Key]}
+			s.pending[entry.Key] = state
+		}
+		state.seq = db.logSeq
+	}
+}
+
+// prunePending forgets the durable state of keys whose writes have all been
+// synced. Callers must hold the shard lock for writing.
+func (s *shard) prunePending(synced uint64) {
+	for key, state := range s.pending {
+		if state.seq <= synced {
+			delete(s.pending, key)
+		}
+	}
+}
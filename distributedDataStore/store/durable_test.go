@@ -0,0 +1,61 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestDurableValuesSurviveCrash(t *testing.T) {
+	dir := t.TempDir()
+	// The write buffer holds back whatever isn't synced yet, so a copy of the
+	// files is what a crash would leave behind
+	db := openTestDatabase(t, dir, WithSyncMode(SyncEvery), WithWriteBuffer(1<<20))
+	rng := rand.New(rand.NewSource(1))
+
+	type crash struct {
+		dir     string
+		durable map[string]string
+	}
+	var crashes []crash
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", rng.Intn(20))
+		if rng.Intn(4) == 0 {
+			mustDelete(t, db, key)
+		} else {
+			mustSet(t, db, key, fmt.Sprintf("value-%d", i))
+		}
+		if rng.Intn(30) == 0 {
+			err := db.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if i%40 != 39 {
+			continue
+		}
+		durable := make(map[string]string)
+		for k := 0; k < 20; k++ {
+			key := fmt.Sprintf("key-%d", k)
+			value, err := db.Durable(key)
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			durable[key] = string(value)
+		}
+		crashes = append(crashes, crash{dir: copyTestDir(t, dir), durable: durable})
+	}
+
+	// Every value Durable returned is there after the crash, and nothing
+	// it didn't return
+	for i, c := range crashes {
+		t.Run(fmt.Sprintf("crash %d", i), func(t *testing.T) {
+			checkContents(t, openTestDatabase(t, c.dir, WithSyncMode(SyncEvery)), c.durable)
+		})
+	}
+}
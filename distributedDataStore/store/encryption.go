@@ -0,0 +1,59 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// WithEncryption encrypts every record payload written to the log with aead,
+// using a fresh random nonce per record. Replay needs the same AEAD to read the
+// records back.
+func WithEncryption(aead cipher.AEAD) Option {
+	return func(db *Database) {
+		db.aead = aead
+	}
+}
+
+// NewAESGCM returns an AES-GCM AEAD for WithEncryption from a 16, 24 or 32 byte
+// key.
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts body into a payload laid out as flags, nonce, ciphertext. The
+// flags byte is authenticated as additional data so it can't be tampered with.
+func (e *recordEncoder) seal(flag payloadFlag, body []byte) ([]byte, error) {
+	payload := make([]byte, 1+e.aead.NonceSize(), 1+e.aead.NonceSize()+len(body)+e.aead.Overhead())
+	payload[0] = byte(flag)
+
+	nonce := payload[1:]
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.aead.Seal(payload, nonce, body, payload[:1]), nil
+}
+
+// open decrypts the body of a payload sealed by seal.
+func (e *recordEncoder) open(flag payloadFlag, body []byte) ([]byte, error) {
+	if e.aead == nil {
+		return nil, fmt.Errorf("record is encrypted but no key was given: %w", ErrDecrypt)
+	}
+	if len(body) < e.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted record is too short: %w", ErrCorruptRecord)
+	}
+
+	nonce, ciphertext := body[:e.aead.NonceSize()], body[e.aead.NonceSize():]
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, []byte{byte(flag)})
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,80 @@
+package store
+
+import "errors"
+
+var (
+	// ErrKeyNotFound is returned when reading a key that doesn't exist.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrClosed is returned by operations on a Database after Close.
+	ErrClosed = errors.New("database is closed")
+	// ErrCorruptRecord is returned when a log record fails its checksum.
+	ErrCorruptRecord = errors.New("record checksum mismatch")
+	// ErrUnknownOp is returned when a log record carries an op we don't know.
+	ErrUnknownOp = errors.New("unknown log op")
+	// ErrUnsupportedFormat is returned for logs written in a newer format.
+	ErrUnsupportedFormat = errors.New("unsupported log format version")
+	// ErrDecrypt is returned when an encrypted record can't be decrypted,
+	// usually because the database was opened with the wrong key.
+	ErrDecrypt = errors.New("record decryption failed")
+	// ErrNotInteger is returned by Increment when the existing value isn't a
+	// decimal int64.
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrOverflow is returned by Increment when the sum doesn't fit an int64.
+	ErrOverflow = errors.New("integer overflow")
+	// ErrNotSupported is returned by operations the database's LogStore can't
+	// back, like compacting a log that isn't kept in files.
+	ErrNotSupported = errors.New("not supported by the log store")
+	// ErrBufferFull is returned by writes under the BufferFail policy while
+	// too many records are waiting for a flush.
+	ErrBufferFull = errors.New("log buffer is full")
+	// ErrValueTooLarge is returned when writing a value over the size set
+	// with WithMaxValueSize.
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrKeyTooLong is returned when writing a key over the length set with
+	// WithMaxKeyLength.
+	ErrKeyTooLong = errors.New("key too long")
+	// ErrReadOnly is returned by writes to a database opened with
+	// WithReadOnly.
+	ErrReadOnly = errors.New("database is read-only")
+	// ErrConflict is returned by Txn when a key the transaction read was
+	// written before it could commit.
+	ErrConflict = errors.New("transaction conflict")
+	// ErrSegmentRemoved is returned by a LogReader whose next segment can't
+	// be found because the one it was reading was removed, usually by
+	// compaction.
+	ErrSegmentRemoved = errors.New("log segment was removed")
+	// ErrNotModified is returned by GetIfModifiedSince when the key wasn't
+	// written after the given time.
+	ErrNotModified = errors.New("not modified")
+	// ErrUnhealthy is returned by Health when the database can't take
+	// writes safely.
+	ErrUnhealthy = errors.New("database is unhealthy")
+	// ErrLocked is returned by OpenLogFile when another database already has
+	// the log file open for writing.
+	ErrLocked = errors.New("log file is locked by another database")
+	// ErrAuditBroken is returned by VerifyAudit when the hash chain of the
+	// audit log is broken.
+	ErrAuditBroken = errors.New("audit log is broken")
+	// ErrRateLimited is returned by writes a limiter set with WithRateLimit
+	// or WithByteRateLimit under RateLimitFail has no tokens for.
+	ErrRateLimited = errors.New("write rate limited")
+	// ErrEmptyKey is returned by calls given the empty key, unless it's
+	// allowed with WithEmptyKeys.
+	ErrEmptyKey = errors.New("empty key")
+	// ErrNoMergeOperator is returned by Merge, and by the replay of a merge
+	// record, when no merge operator was registered with WithMergeOperator.
+	ErrNoMergeOperator = errors.New("no merge operator")
+	// ErrBadDump is returned by Load for a stream that isn't a whole dump
+	// written by Dump, or one of a newer version.
+	ErrBadDump = errors.New("malformed dump")
+	// ErrPartialReplay is returned by writes after a replay that stopped at
+	// its deadline, until AcceptPartialReplay, and by Compact and Checkpoint
+	// until a replay reads the whole log.
+	ErrPartialReplay = errors.New("replay stopped short of the end of the log")
+	// ErrFrozen is returned by writes to a database frozen with FreezeFail,
+	// and by Freeze on a database that's frozen already.
+	ErrFrozen = errors.New("database is frozen")
+	// ErrTokenReused is returned by SetIdempotent for a token already used
+	// for a write of another key or value.
+	ErrTokenReused = errors.New("idempotency token reused for another write")
+)
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var publishExpvar sync.Once
+
+// PublishExpvar publishes the counters of the Prometheus metrics through
+// expvar too, as the "datastore" map, so they show up on /debug/vars of any
+// server that serves expvar.Handler, http.DefaultServeMux included. Nothing is
+// published until it's first called, later calls do nothing. The HTTP server
+// calls it and serves /debug/vars itself.
+//
+// The values are read from the Prometheus metrics when /debug/vars is
+// requested, so both always agree, and like them they're process wide.
+func PublishExpvar() {
+	publishExpvar.Do(func() {
+		vars := new(expvar.Map).Init()
+		vars.Set("sets", metricFunc(setsTotal))
+		vars.Set("gets", metricFunc(getsTotal))
+		vars.Set("get_misses", metricFunc(getMissesTotal))
+		vars.Set("deletes", metricFunc(deletesTotal))
+		vars.Set("rotations", metricFunc(rotationsTotal))
+		vars.Set("keys", metricFunc(keysGauge))
+		vars.Set("replay_duration_seconds", metricFunc(replayDuration))
+		expvar.Publish("datastore", vars)
+	})
+}
+
+// metricFunc reads the value of a counter or gauge.
+func metricFunc(metric prometheus.Metric) expvar.Func {
+	return func() interface{} {
+		var out dto.Metric
+		err := metric.Write(&out)
+		if err != nil {
+			return nil
+		}
+		if out.Counter != nil {
+			return out.Counter.GetValue()
+		}
+		return out.Gauge.GetValue()
+	}
+}
@@ -0,0 +1,776 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FileLogStore is the LogStore a database uses by default: a log file that's
+// rotated into timestamped segments named <path>_<timestamp> once it grows past
+// rotateSize. Each file starts with a header saying how its records are
+// encoded, see record.go.
+type FileLogStore struct {
+	path       string
+	rotateSize int64
+	// codec is stamped into the header of new files
+	codec  Codec
+	strict bool
+
+	file *os.File
+	// buf buffers the appends to file when bufferSize is set, it's flushed
+	// before every sync, rotation and close. size counts the buffered bytes.
+	buf        []byte
+	bufferSize int
+	size       int64
+	// header describes the format of the active file, which holds no records
+	// while size is still start
+	header logHeader
+	start  int64
+	// openedAt is when the active file was opened, so the file's age counts
+	// from the last rotation whatever triggered it. clock tells the time.
+	openedAt time.Time
+	clock    Clock
+	// synced is the size of the active file as of its last sync, rotations
+	// counts the files sealed so far. appended is closed and replaced when
+	// either changes, waking up the LogReaders waiting for new records.
+	synced    int64
+	rotations uint64
+	appended  chan struct{}
+
+	// reencode converts a record read from a file in an older format to the
+	// current one, so ReadAll always returns records in a single format
+	reencode func(header logHeader, record []byte) ([]byte, error)
+	// onRotate is called after every successful rotation when set
+	onRotate func()
+	// noRotationSync skips the syncs of Rotate, see WithRotationSync
+	noRotationSync bool
+
+	logger *zap.Logger
+}
+
+// WithWriteBuffer buffers up to size bytes of records in memory before writing
+// them to the log file, so small writes share a write syscall. The buffer is
+// written out by every flush, rotation and close, until then buffered records
+// are lost not only on a power loss but also when the process crashes. Zero,
+// the default, writes each record as it's logged. It only applies to the
+// FileLogStore and is pointless under SyncOnCommit, which writes out every
+// record anyway.
+func WithWriteBuffer(size int) Option {
+	return func(db *Database) {
+		db.writeBuffer = size
+	}
+}
+
+// WithRotationSync sets whether rotating the log file syncs it, and the
+// directory holding it, before moving on to the next file. It does by default,
+// whatever the SyncMode: the sealed segment is synced before it's renamed, and
+// the directory once the rename and the new file are in place, so a crash right
+// after a rotation can neither lose the tail of the segment nor the segment
+// itself, even though writes to the next file already succeeded. Turning it
+// off leaves that to the OS like SyncNone leaves the writes, saving the stall
+// of up to two fsyncs per rotation. It only applies to the FileLogStore.
+func WithRotationSync(enabled bool) Option {
+	return func(db *Database) {
+		db.noRotationSync = !enabled
+	}
+}
+
+// openFileLogStore opens the log file at path for appending, creating it if
+// needed. An existing file in another format than the current one is sealed
+// as a segment first, so that every file holds records of a single format.
+func openFileLogStore(path string, rotateSize int64, codec Codec, strict bool, clock Clock, logger *zap.Logger) (*FileLogStore, error) {
+	s := &FileLogStore{
+		path:       path,
+		rotateSize: rotateSize,
+		codec:      codec,
+		strict:     strict,
+		clock:      clock,
+		appended:   make(chan struct{}),
+		logger:     logger,
+	}
+
+	err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.header.version != currentFormatVersion || s.header.codec.ID() != codec.ID() {
+		err = s.Rotate()
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// dropTornHeader empties file if all it holds is a header cut short by a crash,
+// so it's stamped again like a fresh log, and returns its size.
+func (s *FileLogStore) dropTornHeader(file *os.File, size int64) (int64, error) {
+	content := make([]byte, size)
+	_, err := file.ReadAt(content, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if !tornHeader(content) {
+		return size, nil
+	}
+
+	s.logger.Sugar().Warnf("Log file %s only holds a torn header, rewriting it", s.path)
+	err = file.Truncate(0)
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// open opens the active log file and reads or writes its header.
+func (s *FileLogStore) open() error {
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	header := logHeader{version: currentFormatVersion, codec: s.codec}
+	size := info.Size()
+	if size < int64(len(logMagic)+2) {
+		size, err = s.dropTornHeader(file, size)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	var start int64
+	if size == 0 {
+		// Fresh log, stamp it with the current format
+		n, err := file.Write(encodeLogHeader(s.codec))
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		size = int64(n)
+		start = size
+	} else {
+		header, start, err = readLogHeader(file)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	s.file = file
+	s.buf = s.buf[:0]
+	s.header = header
+	s.start = start
+	s.openedAt = s.clock.Now()
+	// Count what's already in the file, so rotation respects the true size
+	// of a log reopened after a restart
+	s.size = size
+	s.synced = size
+	logFileSizeGauge.Set(float64(size))
+	return nil
+}
+
+// truncated records that the active file was cut to size behind the store's
+// back. The records still buffered go after it.
+func (s *FileLogStore) truncated(size int64) {
+	s.size = size + int64(len(s.buf))
+	s.synced = size
+	logFileSizeGauge.Set(float64(s.size))
+}
+
+// Append frames record and appends it to the active file. Once the file has
+// grown past rotateSize it's rotated before the next append, so a failed
+// rotation fails that append without having written anything. A file holding
+// no records is never rotated, whatever its size, so every segment sealed by
+// size holds at least one record.
+func (s *FileLogStore) Append(record []byte) error {
+	err := s.rotateIfFull()
+	if err != nil {
+		return err
+	}
+	return s.write(record)
+}
+
+// appendAll appends records as a single write: a rotation that's due happens
+// before the first of them, never in between, and if one fails to be written
+// the ones before it are taken back, so the active file gets either all of
+// them or none. The returned func takes them back too, for a write that fails
+// later on, like on its fsync.
+func (s *FileLogStore) appendAll(records [][]byte) (func(), error) {
+	err := s.rotateIfFull()
+	if err != nil {
+		return nil, err
+	}
+
+	undo := s.mark()
+	for _, record := range records {
+		err = s.write(record)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+	}
+	return undo, nil
+}
+
+// Truncate takes back the last n records of the active file. It reads the
+// file to find where they start, writes through the Database take theirs back
+// with mark instead.
+func (s *FileLogStore) Truncate(n int) error {
+	err := s.flushBuffer()
+	if err != nil {
+		return err
+	}
+
+	var offsets []int64
+	for offset := s.start; offset < s.size; {
+		offsets = append(offsets, offset)
+		_, offset, err = readRecord(s.file, offset, s.size, s.header.version)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", s.path, err)
+		}
+	}
+	if n > len(offsets) {
+		return fmt.Errorf("%s holds %d records, can't take back %d", s.path, len(offsets), n)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	size := offsets[len(offsets)-n]
+	err = s.file.Truncate(size)
+	if err != nil {
+		return err
+	}
+	s.size = size
+	if s.synced > size {
+		s.synced = size
+	}
+	logFileSizeGauge.Set(float64(s.size))
+	return nil
+}
+
+func (s *FileLogStore) rotateIfFull() error {
+	if s.size < s.rotateSize || s.size <= s.start {
+		return nil
+	}
+	err := s.Rotate()
+	if err != nil {
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+	return nil
+}
+
+// write frames record and writes it to the active file, through the buffer if
+// there is one.
+func (s *FileLogStore) write(record []byte) error {
+	framed := encodeRecord(s.header.version, record)
+	err := s.writeFramed(framed)
+	if err != nil {
+		return err
+	}
+
+	s.size += int64(len(framed))
+	logFileSizeGauge.Set(float64(s.size))
+	return nil
+}
+
+func (s *FileLogStore) writeFramed(framed []byte) error {
+	if s.bufferSize > 0 && len(s.buf)+len(framed) > s.bufferSize {
+		err := s.flushBuffer()
+		if err != nil {
+			return err
+		}
+	}
+	if len(framed) < s.bufferSize {
+		s.buf = append(s.buf, framed...)
+		return nil
+	}
+	_, err := s.file.Write(framed)
+	return err
+}
+
+// mark returns a func that takes back every record appended to the active file
+// from now on, written out or still buffered, along with whatever a failed
+// write left of a record. Callers must hold logFileLock from mark until the
+// func returns, a rotation in between seals the records out of its reach.
+func (s *FileLogStore) mark() func() {
+	rotations, size := s.rotations, s.size
+	return func() {
+		if s.rotations != rotations {
+			s.logger.Sugar().Warnf("Log file %s was rotated, can't take back the records of a failed write", s.path)
+			return
+		}
+
+		// The bytes up to written are in the file, the rest in the buffer
+		written := s.size - int64(len(s.buf))
+		if size >= written {
+			s.buf = s.buf[:size-written]
+		} else {
+			s.buf = s.buf[:0]
+			written = size
+		}
+		err := s.file.Truncate(written)
+		if err != nil {
+			s.logger.Sugar().Warnf("Failed to take back the records of a failed write from %s, replay may apply them: %v", s.path, err)
+			return
+		}
+
+		s.size = size
+		logFileSizeGauge.Set(float64(s.size))
+	}
+}
+
+func (s *FileLogStore) Sync() error {
+	err := s.flushBuffer()
+	if err != nil {
+		return err
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return err
+	}
+	s.markSynced()
+	return nil
+}
+
+// writeOut is Sync without the fsync, for SyncNone: it writes the buffered
+// records to the active file and lets LogReaders read them.
+func (s *FileLogStore) writeOut() error {
+	err := s.flushBuffer()
+	if err != nil {
+		return err
+	}
+	s.markSynced()
+	return nil
+}
+
+func (s *FileLogStore) markSynced() {
+	if s.synced != s.size {
+		s.synced = s.size
+		s.notifyAppended()
+	}
+}
+
+// setWriteBuffer starts buffering appends to the active file and the files
+// after it in a buffer of size bytes, see WithWriteBuffer.
+func (s *FileLogStore) setWriteBuffer(size int) {
+	s.bufferSize = size
+	if size > 0 {
+		s.buf = make([]byte, 0, size)
+	}
+}
+
+// flushBuffer writes the buffered records to the active file. What a failed
+// write didn't get to stays buffered.
+func (s *FileLogStore) flushBuffer() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	n, err := s.file.Write(s.buf)
+	s.buf = s.buf[:copy(s.buf, s.buf[n:])]
+	return err
+}
+
+// notifyAppended wakes up the LogReaders waiting for the active file to grow
+// or be sealed.
+func (s *FileLogStore) notifyAppended() {
+	close(s.appended)
+	s.appended = make(chan struct{})
+}
+
+// Rotate seals the active log file under a timestamped name, see segmentName,
+// and starts a fresh one. If any step fails the old file stays active and the error is returned,
+// a failed rotation never loses the log.
+func (s *FileLogStore) Rotate() error {
+	sugar := s.logger.Sugar()
+	start := time.Now()
+
+	// Sync what was written to the current log file, once it's closed nothing
+	// can reach it anymore
+	err := s.flushBuffer()
+	if err == nil && !s.noRotationSync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		sugar.Warnf("Failed to sync log file %s before rotating, continuing with it: %v", s.path, err)
+		return err
+	}
+
+	// Rename the current log file. It stays open, so if anything below fails
+	// we can keep appending to it
+	rotatedFile, err := s.segmentName()
+	if err == nil {
+		err = os.Rename(s.path, rotatedFile)
+	}
+	if err != nil {
+		sugar.Warnf("Failed to rotate log file %s, continuing with it: %v", s.path, err)
+		return err
+	}
+
+	// Open a new log file, which resets the log file size
+	old, oldBuf, oldSize, oldHeader, oldStart, oldOpenedAt, oldSynced := s.file, s.buf, s.size, s.header, s.start, s.openedAt, s.synced
+	err = s.open()
+	if err == nil && !s.noRotationSync {
+		// Until the directory is synced, a crash can undo the rename or
+		// lose the new file, and the records written to it with it
+		err = syncDir(filepath.Dir(s.path))
+		if err != nil {
+			_ = s.file.Close()
+		}
+	}
+	if err != nil {
+		sugar.Warnf("Failed to start a new log file %s, continuing with %s: %v", s.path, rotatedFile, err)
+		s.file, s.buf, s.size, s.header, s.start, s.openedAt, s.synced = old, oldBuf, oldSize, oldHeader, oldStart, oldOpenedAt, oldSynced
+		if renameErr := os.Rename(rotatedFile, s.path); renameErr != nil {
+			sugar.Warnf("Failed to move %s back to %s: %v", rotatedFile, s.path, renameErr)
+		}
+		return err
+	}
+
+	err = old.Close()
+	if err != nil {
+		sugar.Warnf("Failed to close rotated log file %s: %v", rotatedFile, err)
+	}
+
+	s.rotations++
+	s.notifyAppended()
+	if s.onRotate != nil {
+		s.onRotate()
+	}
+
+	rotationsTotal.Inc()
+	s.logger.Debug("rotate",
+		zap.String("segment", rotatedFile),
+		zap.Int64("bytes", oldSize),
+		zap.Duration("latency", time.Since(start)))
+	return nil
+}
+
+// Close writes out the buffered records, if any, and closes the active log
+// file.
+func (s *FileLogStore) Close() error {
+	s.notifyAppended()
+	err := s.flushBuffer()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// truncate removes every file of the log, segments oldest first and the active
+// file last, and starts a fresh active file. If that fails the store is left
+// closed, so later appends fail rather than going to a removed file.
+func (s *FileLogStore) truncate() error {
+	segments, err := discoverSegments(s.path)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if segment == s.path {
+			continue
+		}
+		err = os.Remove(segment)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err = s.file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = s.open()
+	if err != nil {
+		return err
+	}
+
+	// Wake up the LogReaders, whose files are gone now
+	s.rotations++
+	s.notifyAppended()
+	return nil
+}
+
+// ReadAll calls fn for the records of every segment and then the active file.
+// Records of files written in an older format are converted to the current
+// one.
+func (s *FileLogStore) ReadAll(fn func(record []byte) error) error {
+	segments, err := discoverSegments(s.path)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		_, err = readLogFile(s.logger, segment, 0, logReadOptions{strict: s.strict}, func(header logHeader, record []byte) error {
+			if header.version != currentFormatVersion || header.codec.ID() != s.codec.ID() {
+				record, err = s.reencode(header, record)
+				if err != nil {
+					return err
+				}
+			}
+			return fn(record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discoverSegments returns every rotated segment of the log at path followed
+// by the active log file, in the order they were written. Rotated segments
+// carry a sortable timestamp suffix, so lexical order is chronological order.
+// Only files named like segments of path are returned, so the logs of other
+// databases in the same directory are left out, even when their name starts
+// with this one's.
+func discoverSegments(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(path) + "_"
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		// Files still being written when we stopped end in .tmp and don't
+		// match
+		if strings.HasPrefix(name, prefix) && segmentSuffix.MatchString(name[len(prefix):]) {
+			segments = append(segments, filepath.Join(filepath.Dir(path), name))
+		}
+	}
+	sort.Strings(segments)
+
+	_, err = os.Stat(path)
+	if err == nil {
+		segments = append(segments, path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+// withLogFiles calls fn with the files of the log, as listed by
+// discoverSegments, and keeps them from being renamed or removed until fn
+// returns. Everything reading the log files goes through here: a reader
+// listing them on its own could have a rotation rename the active file before
+// opening it, and silently miss its records. A database created with
+// NewMemoryDatabase has no files.
+//
+// Rotation only happens under logFileLock, which is held throughout, so fn
+// must not write to the database. Callers must hold compactLock, which keeps
+// compaction from removing segments, and no shard lock unless they took
+// compactLock first.
+func (db *Database) withLogFiles(fn func(segments []string) error) error {
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.memory {
+		return fn(nil)
+	}
+	segments, err := discoverSegments(db.logFile)
+	if err != nil {
+		return err
+	}
+	return fn(segments)
+}
+
+// segmentSuffix matches what follows <path>_ in the name of a segment: the
+// timestamp of its rotation, followed by _compacted for compacted segments.
+// Segments rotated before timestamps had nanoseconds have none.
+var segmentSuffix = regexp.MustCompile(`^\d{8}_\d{6}(_\d{9})?(_compacted)*$`)
+
+// segmentTimeLayout is the layout of the timestamp of a segment, down to the
+// second, the nanoseconds follow it.
+const segmentTimeLayout = "20060102_150405"
+
+// segmentName returns the name the active log file is rotated to: its path
+// followed by the time of the rotation, down to the nanosecond. In case the
+// clock went back since the last rotation, the time is moved on to just after
+// that of the newest segment, so segments still sort in the order they were
+// written and two rotations never pick the same name. A name that's taken
+// anyway fails the rotation, with an error wrapping os.ErrExist, rather than
+// the rename clobbering a segment.
+func (s *FileLogStore) segmentName() (string, error) {
+	now := s.clock.Now()
+	segments, err := discoverSegments(s.path)
+	if err != nil {
+		return "", err
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] == s.path {
+			continue
+		}
+		newest, ok := segmentTime(segments[i][len(s.path)+1:], now.Location())
+		if ok && !now.After(newest) {
+			now = newest.Add(time.Nanosecond)
+		}
+		break
+	}
+
+	name := fmt.Sprintf("%s_%s_%09d", s.path, now.Format(segmentTimeLayout), now.Nanosecond())
+	_, err = os.Lstat(name)
+	if err == nil {
+		return "", fmt.Errorf("rotating log file %s to %s: %w", s.path, name, os.ErrExist)
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	return name, nil
+}
+
+// segmentTime parses the time of a segment out of what follows <path>_ in its
+// name, as a time in loc.
+func segmentTime(suffix string, loc *time.Location) (time.Time, bool) {
+	for strings.HasSuffix(suffix, "_compacted") {
+		suffix = strings.TrimSuffix(suffix, "_compacted")
+	}
+	if len(suffix) < len(segmentTimeLayout) {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation(segmentTimeLayout, suffix[:len(segmentTimeLayout)], loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if nanos := strings.TrimPrefix(suffix[len(segmentTimeLayout):], "_"); nanos != "" {
+		n, err := strconv.Atoi(nanos)
+		if err != nil {
+			return time.Time{}, false
+		}
+		t = t.Add(time.Duration(n))
+	}
+	return t, true
+}
+
+// logReadOptions controls how readLogFile deals with a damaged log file.
+type logReadOptions struct {
+	// strict fails on a record failing its checksum instead of skipping it
+	strict bool
+	// readOnly leaves a partially written last record in place instead of
+	// truncating it away
+	readOnly bool
+	// mmap reads the file through a memory mapping when the platform allows
+	mmap bool
+	// deadline, when set, stops the read with a *replayDeadlineError once
+	// it has passed
+	deadline time.Time
+	// truncated, when set, is called with the path and new size of a file
+	// once a partially written last record is truncated away
+	truncated func(path string, size int64)
+}
+
+// readLogFile calls fn with the header of the log file at path and each of its
+// records from offset start on, zero meaning the first record. Records failing
+// their checksum are skipped unless opts.strict, and counted in the returned
+// number. A partially written last record is truncated away unless
+// opts.readOnly.
+func readLogFile(logger *zap.Logger, path string, start int64, opts logReadOptions, fn func(header logHeader, record []byte) error) (int, error) {
+	sugar := logger.Sugar()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// The file is only read, failing to close it loses nothing
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			sugar.Warnf("Failed to close log file %s: %v", path, err)
+		}
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var r io.ReaderAt = file
+	if opts.mmap {
+		mapped, unmap, ok := mapLogFile(file, info.Size())
+		if ok {
+			defer unmap()
+			r = mapped
+		}
+	}
+
+	header, offset, err := readLogHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	if start > offset {
+		offset = start
+	}
+
+	skipped := 0
+	for {
+		if !opts.deadline.IsZero() && time.Now().After(opts.deadline) {
+			return skipped, &replayDeadlineError{path: path, offset: offset, size: info.Size()}
+		}
+		item, next, err := readRecord(r, offset, info.Size(), header.version)
+		if err == ErrCorruptRecord && !opts.strict {
+			sugar.Warnf("Skipping corrupt record at offset %d of %s", offset, path)
+			skipped++
+			offset = next
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				if offset < info.Size() && opts.readOnly {
+					sugar.Warnf("Ignoring partial record of %d bytes at offset %d of %s",
+						info.Size()-offset, offset, path)
+				} else if offset < info.Size() {
+					// The last record was only partially written, most likely
+					// because we crashed mid-write. Drop it so new appends
+					// start from a clean record boundary.
+					sugar.Warnf("Truncating partial record of %d bytes at offset %d of %s",
+						info.Size()-offset, offset, path)
+					err = os.Truncate(path, offset)
+					if err != nil {
+						return skipped, err
+					}
+					if opts.truncated != nil {
+						opts.truncated(path, offset)
+					}
+				}
+				return skipped, nil
+			}
+			return skipped, fmt.Errorf("%s at offset %d: %w", path, offset, err)
+		}
+
+		offset = next
+
+		err = fn(header, item)
+		if err != nil {
+			return skipped, err
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestReplayTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.bin")
+	db := openTestDatabase(t, dir)
+	mustSet(t, db, "a", "1")
+	mustSet(t, db, "b", "2")
+	err := db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intact := info.Size()
+
+	// A crash mid-append leaves the start of a record: its length prefix
+	// promises more than the file holds
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.Write([]byte{0x40, 0, 0, 0, 0xde, 0xad})
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestDatabase(t, dir)
+	checkContents(t, db, map[string]string{"a": "1", "b": "2"})
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != intact {
+		t.Fatalf("log is %d bytes after replay, want the %d intact ones", info.Size(), intact)
+	}
+
+	// Appends go on from the end of the last intact record, and the store
+	// counts the file from there too
+	mustSet(t, db, "c", "3")
+	err = db.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.logFileLock.Lock()
+	size := db.store.(*FileLogStore).size
+	db.logFileLock.Unlock()
+	if size != info.Size() {
+		t.Fatalf("store counts %d bytes, the file holds %d", size, info.Size())
+	}
+
+	db = reopenTestDatabase(t, db, dir)
+	checkContents(t, db, map[string]string{"a": "1", "b": "2", "c": "3"})
+}
+
+// recordOffsets returns the offset of every record of the log file at path.
+func recordOffsets(t *testing.T, path string) []int64 {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, offset, err := readLogHeader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for offset < info.Size() {
+		offsets = append(offsets, offset)
+		_, offset, err = readRecord(file, offset, info.Size(), header.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return offsets
+}
+
+func TestReplaySkipsRecordWithCorruptLength(t *testing.T) {
+	tests := []struct {
+		name string
+		// bit is the bit of the length prefix flipped
+		bit uint
+	}{
+		// The length runs past the end of the file, like a torn tail
+		{name: "too long", bit: 31},
+		// The length stays within the file
+		{name: "too short", bit: 1},
+		// The length is intact, its checksum isn't
+		{name: "length checksum", bit: 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.bin")
+			db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit))
+			want := make(map[string]string)
+			for i := 0; i < 10; i++ {
+				key, value := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+				mustSet(t, db, key, value)
+				if i != 2 {
+					want[key] = value
+				}
+			}
+			err := db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			third := recordOffsets(t, path)[2]
+			data[third+int64(tt.bit/8)] ^= 1 << (tt.bit % 8)
+			err = os.WriteFile(path, data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Strict replay stops at it rather than guessing
+			strict := NewDatabase(dir, "test", MinRotateSize, WithLogger(zap.NewNop()), WithStrictReplay(), WithReadOnly())
+			_, err = strict.ReplayWriteAheadLog(nil)
+			if !errors.Is(err, ErrCorruptRecord) {
+				t.Fatalf("strict replay returned %v, want ErrCorruptRecord", err)
+			}
+
+			report, err := strict.Verify(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Corruptions) != 1 || report.Corruptions[0].Kind != CorruptChecksum || report.Corruptions[0].Offset != third {
+				t.Fatalf("verify found %v, want a checksum mismatch at offset %d", report.Corruptions, third)
+			}
+
+			// The default replay skips it, keeping the records after it on
+			// disk as well as in memory
+			db = openTestDatabase(t, dir)
+			checkContents(t, db, want)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len(data)) {
+				t.Fatalf("log is %d bytes after replay, want all %d kept", info.Size(), len(data))
+			}
+		})
+	}
+}
+
+func TestReplayReadsLogsWithoutLengthChecksums(t *testing.T) {
+	// A log written before record lengths had a checksum of their own
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.bin")
+	enc := &recordEncoder{codec: ProtoCodec}
+	header := logHeader{version: flagsFormatVersion, codec: ProtoCodec}
+	log := append([]byte(logMagic), flagsFormatVersion, ProtoCodec.ID())
+	for _, entry := range []*contract.LogEntry{
+		{Op: INSERT, Key: "a", Value: []byte("1")},
+		{Op: INSERT, Key: "b", Value: []byte("2")},
+		{Op: DELETE, Key: "a"},
+	} {
+		record, err := enc.encodeLogEntry(header, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, record...)
+	}
+	err := os.WriteFile(path, log, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDatabase(t, dir)
+	checkContents(t, db, map[string]string{"b": "2"})
+
+	// Appends keep to the file's format
+	mustSet(t, db, "c", "3")
+	db = reopenTestDatabase(t, db, dir)
+	checkContents(t, db, map[string]string{"b": "2", "c": "3"})
+}
+
+func TestTruncateTakesBackTheLastRecords(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit), WithWriteBuffer(1<<10))
+	mustSet(t, db, "a", "1")
+	mustSet(t, db, "b", "2")
+	mustSet(t, db, "c", "3")
+
+	db.logFileLock.Lock()
+	store := db.store.(*FileLogStore)
+	err := store.Truncate(2)
+	tooMany := store.Truncate(5)
+	db.logFileLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tooMany == nil {
+		t.Fatal("taking back more records than the file holds succeeded")
+	}
+
+	// Appends go on from where the file was cut
+	mustSet(t, db, "d", "4")
+	db = reopenTestDatabase(t, db, dir)
+	checkContents(t, db, map[string]string{"a": "1", "d": "4"})
+}
+
+func TestRotationFailsInReadOnlyDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// Writes append as they go, instead of on the next flush
+	db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit))
+	// A single record filling the file makes the rotation due on the next
+	// write
+	value := strings.Repeat("x", MinRotateSize)
+	mustSet(t, db, "big", value)
+	want := map[string]string{"big": value}
+
+	err := os.Chmod(dir, 0555)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chmod(dir, 0755) }()
+	probe, err := os.Create(filepath.Join(dir, "probe"))
+	if err == nil {
+		_ = probe.Close()
+		t.Skip("the directory is still writable, permissions aren't enforced for this user")
+	}
+
+	// The rotation due before this write can't rename the active file, the
+	// write fails instead of taking the process down, and nothing changes
+	_, err = db.Set("failed", []byte("value"))
+	if err == nil || !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("write with a failing rotation returned %v, want a permission error", err)
+	}
+	checkContents(t, db, want)
+
+	// Once the directory is writable again the next write rotates
+	err = os.Chmod(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, db, "after", "value")
+	want["after"] = "value"
+	segments, err := db.SegmentSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("log has %d files after the rotation, want 2", len(segments))
+	}
+
+	db = reopenTestDatabase(t, db, dir, WithSyncMode(SyncOnCommit))
+	checkContents(t, db, want)
+}
+
+func TestRotationsNeverReuseSegmentNames(t *testing.T) {
+	tests := []struct {
+		name string
+		// step is how far the clock moves between rotations
+		step time.Duration
+	}{
+		{name: "same instant", step: 0},
+		{name: "clock going back", step: -time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			clock := NewManualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			db := openTestDatabase(t, dir, WithClock(clock), WithSyncMode(SyncOnCommit))
+			const rotations = 3
+			for i := 0; i < rotations; i++ {
+				mustSet(t, db, "key", fmt.Sprintf("value-%d", i))
+				mustSet(t, db, fmt.Sprintf("key-%d", i), "value")
+				rotateTestLog(t, db)
+				clock.Advance(tt.step)
+			}
+
+			if names := segmentNames(t, dir); len(names) != rotations {
+				t.Fatalf("%d rotations left segments %v", rotations, names)
+			}
+			// The segments sort in the order they were written, so the last
+			// value of key wins on replay
+			want := map[string]string{"key": fmt.Sprintf("value-%d", rotations-1)}
+			for i := 0; i < rotations; i++ {
+				want[fmt.Sprintf("key-%d", i)] = "value"
+			}
+			db = reopenTestDatabase(t, db, dir, WithClock(clock))
+			checkContents(t, db, want)
+		})
+	}
+}
+
+// BenchmarkWriteBuffer writes small records with every record its own write
+// syscall and sharing writes through buffers of a few sizes. Under SyncNone
+// nothing else goes to the file, so the difference is the syscalls saved.
+func BenchmarkWriteBuffer(b *testing.B) {
+	value := []byte("small value")
+	for _, size := range []int{0, 4 << 10, 64 << 10} {
+		b.Run(fmt.Sprintf("%d bytes", size), func(b *testing.B) {
+			// A single file, so rotations don't flush the buffer
+			db := NewDatabase(b.TempDir(), "test", 1<<30, WithLogger(zap.NewNop()), WithSyncMode(SyncNone), WithWriteBuffer(size))
+			err := db.OpenLogFile()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() { _ = db.Close() })
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err = db.Set(fmt.Sprintf("key-%d", i), value)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			err = db.Flush()
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package store
+
+import "context"
+
+// FreezePolicy decides what a write does while the database is frozen.
+type FreezePolicy int
+
+const (
+	// FreezeBlock makes the write wait for Unfreeze.
+	FreezeBlock FreezePolicy = iota
+	// FreezeFail fails the write with ErrFrozen without writing anything,
+	// leaving it to the caller to retry after the freeze.
+	FreezeFail
+)
+
+// Freeze stops the database from changing its files on disk, for as long as
+// it takes to copy them for a backup, until Unfreeze. It waits for the writes
+// in flight, including those waiting for followers to acknowledge them, and
+// for a compaction, checkpoint or bulk load under way, then syncs the log, so
+// the files hold every write that returned before it. Writes after it wait
+// or fail with ErrFrozen, as policy picks, and compaction, checkpoints,
+// retention, rotation and the eviction of expired keys hold off until
+// Unfreeze. Records from a leader or from Raft wait whatever the policy, as
+// they can't be turned away. Reads go on as usual.
+//
+// A database can only be frozen once at a time, a second Freeze fails with
+// ErrFrozen.
+func (db *Database) Freeze(policy FreezePolicy) error {
+	if !db.freezing.CompareAndSwap(false, true) {
+		return ErrFrozen
+	}
+
+	// Set before taking the lock, writes find the lock taken as soon as
+	// Freeze starts waiting for it
+	db.freezePolicy.Store(int32(policy))
+	db.freezeLock.Lock()
+	db.compactLock.Lock()
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	err := db.flush()
+	if err == nil && db.closed {
+		err = ErrClosed
+	}
+	if err != nil {
+		db.compactLock.Unlock()
+		db.freezeLock.Unlock()
+		db.freezing.Store(false)
+		return err
+	}
+	db.frozen.Store(true)
+	return nil
+}
+
+// Unfreeze lets the writes Freeze held up go ahead, all at once. It's a no-op
+// if the database isn't frozen.
+func (db *Database) Unfreeze() {
+	if !db.frozen.CompareAndSwap(true, false) {
+		return
+	}
+	db.compactLock.Unlock()
+	db.freezeLock.Unlock()
+	db.freezing.Store(false)
+}
+
+// enterWrite lets a write through the freeze, waiting for Unfreeze or failing
+// with ErrFrozen as the policy of the freeze picks. A write let through must
+// call exitWrite once it's done, Freeze waits for it until then. It must be
+// called before taking any lock, so a waiting write doesn't hold up reads.
+func (db *Database) enterWrite(ctx context.Context) error {
+	if db.freezeLock.TryRLock() {
+		return nil
+	}
+	if FreezePolicy(db.freezePolicy.Load()) == FreezeFail {
+		return ErrFrozen
+	}
+	return rLockContext(ctx, &db.freezeLock)
+}
+
+// exitWrite ends a write let through by enterWrite.
+func (db *Database) exitWrite() {
+	db.freezeLock.RUnlock()
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readTestDir returns the contents of every file in dir by name.
+func readTestDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
+
+func TestFreezeBlockHoldsWritesUntilUnfreeze(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir)
+	mustSet(t, db, "before", "freeze")
+
+	err := db.Freeze(FreezeBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lets the writers go if the test fails while they wait
+	defer db.Unfreeze()
+	frozen := readTestDir(t, dir)
+
+	const writers = 8
+	var written atomic.Int32
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var err error
+			switch w % 3 {
+			case 0:
+				_, err = db.Set(fmt.Sprintf("writer-%d", w), []byte("value"))
+			case 1:
+				_, err = db.Delete("before")
+			default:
+				b := db.Batch()
+				b.Set(fmt.Sprintf("writer-%d", w), []byte("value"))
+				err = b.Commit()
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			written.Add(1)
+		}(w)
+	}
+
+	// Reads go on while the writes wait
+	time.Sleep(100 * time.Millisecond)
+	value, err := db.Get("before")
+	if err != nil || string(value) != "freeze" {
+		t.Fatalf("Get during the freeze returned %q, %v", value, err)
+	}
+	if n := written.Load(); n != 0 {
+		t.Fatalf("%d writes went through during the freeze", n)
+	}
+	// The files are quiescent, and hold every write from before the freeze
+	if files := readTestDir(t, dir); !reflect.DeepEqual(files, frozen) {
+		t.Fatal("log files changed during the freeze")
+	}
+	checkContents(t, openTestDatabase(t, copyTestDir(t, dir)), map[string]string{"before": "freeze"})
+
+	db.Unfreeze()
+	released := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%d of %d writes went through after Unfreeze", written.Load(), writers)
+	}
+	if n := written.Load(); n != writers {
+		t.Fatalf("%d of %d writes went through after Unfreeze", n, writers)
+	}
+	if db.Exists("before") {
+		t.Fatal("delete held up by the freeze wasn't applied")
+	}
+}
+
+func TestFreezeFailRejectsWrites(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir())
+	mustSet(t, db, "key", "value")
+
+	err := db.Freeze(FreezeFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Freeze(FreezeFail)
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("second Freeze returned %v, want ErrFrozen", err)
+	}
+	_, err = db.Set("key", []byte("frozen"))
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Set returned %v, want ErrFrozen", err)
+	}
+	_, err = db.Delete("key")
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("Delete returned %v, want ErrFrozen", err)
+	}
+	b := db.Batch()
+	b.Set("other", []byte("frozen"))
+	err = b.Commit()
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("batch returned %v, want ErrFrozen", err)
+	}
+	checkContents(t, db, map[string]string{"key": "value"})
+
+	db.Unfreeze()
+	mustSet(t, db, "key", "thawed")
+	checkContents(t, db, map[string]string{"key": "thawed"})
+}
@@ -0,0 +1,70 @@
+package store
+
+import "personalMonorepo/distributedDataStore/contract"
+
+// commitRequest is a write waiting for the group committer. done receives the
+// outcome once the records are synced.
+type commitRequest struct {
+	entries []*contract.LogEntry
+	done    chan error
+}
+
+// groupCommit hands records to the group committer and waits until they're
+// durable. The caller keeps its shard locks meanwhile, so writes to the same
+// key still reach the log one after the other, while writes to other keys
+// share the fsync.
+func (db *Database) groupCommit(logEntries []*contract.LogEntry) error {
+	req := &commitRequest{entries: logEntries, done: make(chan error, 1)}
+	db.commits <- req
+	return <-req.done
+}
+
+// groupCommitter writes and syncs queued writes until the database is closed.
+// Every write that queues up while a sync is running goes into the next group,
+// so the number of fsyncs adapts to the load.
+func (db *Database) groupCommitter() {
+	for {
+		select {
+		case <-db.done:
+			return
+		case req := <-db.commits:
+			group := []*commitRequest{req}
+		drain:
+			for {
+				select {
+				case req := <-db.commits:
+					group = append(group, req)
+				default:
+					break drain
+				}
+			}
+
+			err := db.commitGroup(group)
+			for _, req := range group {
+				req.done <- err
+			}
+		}
+	}
+}
+
+// commitGroup appends the records of a group with a single write and syncs
+// them with a single fsync, see appendLogFile.
+func (db *Database) commitGroup(group []*commitRequest) error {
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	var logEntries []*contract.LogEntry
+	for _, req := range group {
+		logEntries = append(logEntries, req.entries...)
+	}
+
+	err := db.appendLogFile(logEntries...)
+	if err != nil {
+		return err
+	}
+
+	if db.leader != nil {
+		db.leader.append(logEntries)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkGroupCommit compares concurrent durable writes syncing one by one
+// with SyncOnCommit to those sharing an fsync with SyncGroupCommit. Groups
+// only build up while writers run during an fsync, so run it on several CPUs,
+// with -cpu 4 for instance.
+func BenchmarkGroupCommit(b *testing.B) {
+	modes := []struct {
+		name string
+		mode SyncMode
+	}{
+		{name: "SyncOnCommit", mode: SyncOnCommit},
+		{name: "SyncGroupCommit", mode: SyncGroupCommit},
+	}
+	value := []byte(strings.Repeat("v", 100))
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			db := openTestDatabase(b, b.TempDir(), WithSyncMode(m.mode))
+			var n atomic.Int64
+			// Enough writers for a group to build up behind every fsync
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := db.Set(fmt.Sprintf("key-%d", n.Add(1)), value)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
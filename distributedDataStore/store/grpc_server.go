@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net"
+	"personalMonorepo/distributedDataStore/contract"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer exposes a Database over the contract.Store gRPC service.
+type grpcServer struct {
+	contract.UnimplementedStoreServer
+	db *Database
+}
+
+// StartGRPCServer serves db over gRPC on addr in the background. The returned
+// server can be stopped with Stop or GracefulStop.
+func StartGRPCServer(db *Database, addr string) (*grpc.Server, net.Addr, error) {
+	sugar := db.logger.Sugar()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := grpc.NewServer()
+	contract.RegisterStoreServer(server, &grpcServer{db: db})
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil {
+			sugar.Errorf("gRPC server stopped: %v", err)
+		}
+	}()
+
+	sugar.Infof("Serving gRPC on %s", listener.Addr())
+	return server, listener.Addr(), nil
+}
+
+func (s *grpcServer) Put(ctx context.Context, req *contract.PutRequest) (*contract.PutResponse, error) {
+	_, err := s.db.SetContext(ctx, req.Key, req.Value)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &contract.PutResponse{}, nil
+}
+
+func (s *grpcServer) Get(ctx context.Context, req *contract.GetRequest) (*contract.GetResponse, error) {
+	value, err := s.db.GetContext(ctx, req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &contract.GetResponse{Value: value}, nil
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *contract.DeleteRequest) (*contract.DeleteResponse, error) {
+	existed, err := s.db.DeleteContext(ctx, req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &contract.DeleteResponse{Existed: existed}, nil
+}
+
+func (s *grpcServer) Scan(ctx context.Context, req *contract.ScanRequest) (*contract.ScanResponse, error) {
+	resp := &contract.ScanResponse{}
+
+	if req.KeysOnly {
+		keys, err := s.db.ScanKeysContext(ctx, req.Prefix)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		for _, key := range keys {
+			resp.Entries = append(resp.Entries, &contract.KeyValue{Key: key})
+		}
+		return resp, nil
+	}
+
+	entries, err := s.db.ScanContext(ctx, req.Prefix)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, &contract.KeyValue{Key: entry.Key, Value: entry.Value})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetMany(ctx context.Context, req *contract.GetManyRequest) (*contract.GetManyResponse, error) {
+	values, err := s.db.GetManyContext(ctx, req.Keys)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &contract.GetManyResponse{}
+	for _, key := range req.Keys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		resp.Entries = append(resp.Entries, &contract.KeyValue{Key: key, Value: value})
+		// Keys asked for twice are only returned once
+		delete(values, key)
+	}
+	return resp, nil
+}
+
+// toStatus maps database errors onto gRPC status codes.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrClosed), errors.Is(err, ErrFrozen), errors.Is(err, ErrPartialReplay):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrBufferFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrValueTooLarge), errors.Is(err, ErrKeyTooLong), errors.Is(err, ErrEmptyKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrReadOnly):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"personalMonorepo/distributedDataStore/contract"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startTestGRPC serves db over gRPC on a free port and returns a client of
+// it, both stopped when the test ends.
+func startTestGRPC(t *testing.T, db *Database) contract.StoreClient {
+	t.Helper()
+
+	server, addr, err := StartGRPCServer(db, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return contract.NewStoreClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir())
+	client := startTestGRPC(t, db)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, key := range []string{"a:1", "a:2", "b:1"} {
+		_, err := client.Put(ctx, &contract.PutRequest{Key: key, Value: []byte("v-" + key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := client.Get(ctx, &contract.GetRequest{Key: "a:2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Value) != "v-a:2" {
+		t.Fatalf("got %q, want %q", got.Value, "v-a:2")
+	}
+
+	scan, err := client.Scan(ctx, &contract.ScanRequest{Prefix: "a:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Entries) != 2 || scan.Entries[0].Key != "a:1" || scan.Entries[1].Key != "a:2" {
+		t.Fatalf("scan of a: returned %v", scan.Entries)
+	}
+
+	many, err := client.GetMany(ctx, &contract.GetManyRequest{Keys: []string{"b:1", "missing", "b:1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(many.Entries) != 1 || many.Entries[0].Key != "b:1" {
+		t.Fatalf("GetMany returned %v", many.Entries)
+	}
+
+	deleted, err := client.Delete(ctx, &contract.DeleteRequest{Key: "a:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.Existed {
+		t.Fatal("deleting a:1 didn't find it")
+	}
+	checkContents(t, db, map[string]string{"a:2": "v-a:2", "b:1": "v-b:1"})
+}
+
+func TestGRPCServerErrorCodes(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir(), WithMaxValueSize(4))
+	client := startTestGRPC(t, db)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	checkCode := func(err error, want codes.Code) {
+		t.Helper()
+		if got := status.Code(err); got != want {
+			t.Fatalf("got %v (%v), want %v", got, err, want)
+		}
+	}
+
+	_, err := client.Get(ctx, &contract.GetRequest{Key: "missing"})
+	checkCode(err, codes.NotFound)
+	_, err = client.Put(ctx, &contract.PutRequest{Key: "", Value: []byte("v")})
+	checkCode(err, codes.InvalidArgument)
+	_, err = client.Put(ctx, &contract.PutRequest{Key: "big", Value: []byte("too large")})
+	checkCode(err, codes.InvalidArgument)
+
+	err = db.Freeze(FreezeFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Put(ctx, &contract.PutRequest{Key: "k", Value: []byte("v")})
+	checkCode(err, codes.Unavailable)
+	db.Unfreeze()
+}
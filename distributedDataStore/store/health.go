@@ -0,0 +1,45 @@
+package store
+
+import "fmt"
+
+// WithHealthBufferThreshold makes Health fail once records or more are
+// waiting for a flush, which means the periodic flush isn't keeping up or
+// isn't running. It defaults to the bound set with WithMaxBuffered, zero with
+// neither set leaves the buffer out of the check.
+func WithHealthBufferThreshold(records int) Option {
+	return func(db *Database) {
+		db.healthBuffered = records
+	}
+}
+
+// Health returns nil if the database can serve reads and writes, ErrClosed
+// once it's closed, and an error wrapping ErrUnhealthy if its log file isn't
+// open, its last sync failed or too many records wait for a flush, see
+// WithHealthBufferThreshold. A read-only database and one created with
+// NewMemoryDatabase only need to be open. It only holds logFileLock for a few
+// reads, so it's cheap enough for frequent load balancer probes.
+func (db *Database) Health() error {
+	db.logFileLock.Lock()
+	closed, open, syncErr, buffered := db.closed, db.store != nil, db.syncErr, len(db.writeAhead)
+	db.logFileLock.Unlock()
+
+	threshold := db.healthBuffered
+	if threshold == 0 {
+		threshold = db.maxBuffered
+	}
+
+	switch {
+	case closed:
+		return ErrClosed
+	case db.readOnly, db.memory:
+		return nil
+	case !open:
+		return fmt.Errorf("%w: log file isn't open", ErrUnhealthy)
+	case syncErr != nil:
+		return fmt.Errorf("%w: last sync failed: %v", ErrUnhealthy, syncErr)
+	case threshold > 0 && buffered >= threshold:
+		return fmt.Errorf("%w: %d records waiting for a flush", ErrUnhealthy, buffered)
+	default:
+		return nil
+	}
+}
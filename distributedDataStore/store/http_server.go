@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// kvResponse is the JSON body returned for GET /kv/{key}. Value is base64
+// encoded by encoding/json.
+type kvResponse struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// NewHTTPHandler returns a handler exposing db as a small REST API:
+//
+//	GET    /kv/{key}    200 with the value, 404 if missing
+//	PUT    /kv/{key}    204, the request body is the value, retries with the
+//	                    Idempotency-Key header of a write apply once, see
+//	                    SetIdempotent
+//	DELETE /kv/{key}    204
+//	GET    /metrics     Prometheus metrics
+//	GET    /debug/vars  the same counters through expvar, see PublishExpvar
+//	GET    /healthz     200 if Health passes, 503 with the reason otherwise
+func NewHTTPHandler(db *Database) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	PublishExpvar()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		err := db.Health()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/kv/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/kv/")
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			value, err := db.GetContext(r.Context(), key)
+			if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(kvResponse{Key: key, Value: value})
+		case http.MethodPut:
+			body := r.Body
+			if db.maxValueSize > 0 {
+				// Don't buffer more of an oversized value than needed to
+				// tell it's too large
+				body = http.MaxBytesReader(w, body, int64(db.maxValueSize)+1)
+			}
+			value, err := io.ReadAll(body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, ErrValueTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// Retries carrying the key of a write that went through don't
+			// apply it again
+			_, err = db.setIdempotent(r.Context(), key, value, r.Header.Get("Idempotency-Key"))
+			if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			// Deleting a missing key succeeds too, DELETE is idempotent
+			_, err := db.DeleteContext(r.Context(), key)
+			if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// StartHTTPServer serves NewHTTPHandler(db) on addr in the background. The
+// returned server can be stopped with Close or Shutdown.
+func StartHTTPServer(db *Database, addr string) (*http.Server, net.Addr, error) {
+	sugar := db.logger.Sugar()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := &http.Server{Handler: NewHTTPHandler(db)}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			sugar.Errorf("HTTP server stopped: %v", err)
+		}
+	}()
+
+	sugar.Infof("Serving HTTP on %s", listener.Addr())
+	return server, listener.Addr(), nil
+}
+
+// writeHTTPError maps database errors onto HTTP status codes.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrClosed), errors.Is(err, ErrFrozen), errors.Is(err, ErrPartialReplay):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrBufferFull):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, ErrValueTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrKeyTooLong), errors.Is(err, ErrEmptyKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrTokenReused):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
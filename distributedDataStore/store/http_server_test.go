@@ -0,0 +1,119 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// serveTestHTTP sends a request to handler and returns the recorded response.
+func serveTestHTTP(handler http.Handler, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPHandler(t *testing.T) {
+	db := NewMemoryDatabase(WithMaxValueSize(16))
+	handler := NewHTTPHandler(db)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "put", method: http.MethodPut, path: "/kv/a", body: "1", want: http.StatusNoContent},
+		{name: "get", method: http.MethodGet, path: "/kv/a", want: http.StatusOK},
+		{name: "get missing", method: http.MethodGet, path: "/kv/missing", want: http.StatusNotFound},
+		{name: "no key", method: http.MethodGet, path: "/kv/", want: http.StatusBadRequest},
+		{name: "too large", method: http.MethodPut, path: "/kv/b", body: strings.Repeat("x", 17), want: http.StatusRequestEntityTooLarge},
+		{name: "wrong method", method: http.MethodPost, path: "/kv/a", want: http.StatusMethodNotAllowed},
+		{name: "delete", method: http.MethodDelete, path: "/kv/a", want: http.StatusNoContent},
+		{name: "delete missing", method: http.MethodDelete, path: "/kv/a", want: http.StatusNoContent},
+		{name: "get deleted", method: http.MethodGet, path: "/kv/a", want: http.StatusNotFound},
+		{name: "healthz", method: http.MethodGet, path: "/healthz", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := serveTestHTTP(handler, tt.method, tt.path, tt.body, nil)
+		if rec.Code != tt.want {
+			t.Fatalf("%s: got %d %q, want %d", tt.name, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestHTTPHandlerGetBody(t *testing.T) {
+	db := NewMemoryDatabase()
+	mustSet(t, db, "greeting", "hello")
+
+	rec := serveTestHTTP(NewHTTPHandler(db), http.MethodGet, "/kv/greeting", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	var resp kvResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Key != "greeting" || string(resp.Value) != "hello" {
+		t.Fatalf("got %+v", resp)
+	}
+}
+
+func TestHTTPHandlerIdempotencyKey(t *testing.T) {
+	db := NewMemoryDatabase()
+	handler := NewHTTPHandler(db)
+	header := http.Header{"Idempotency-Key": []string{"token-1"}}
+
+	rec := serveTestHTTP(handler, http.MethodPut, "/kv/a", "1", header)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	mustSet(t, db, "a", "2")
+
+	// The retry doesn't apply again over the later write
+	rec = serveTestHTTP(handler, http.MethodPut, "/kv/a", "1", header)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("retry got %d %q", rec.Code, rec.Body.String())
+	}
+	checkContents(t, db, map[string]string{"a": "2"})
+
+	rec = serveTestHTTP(handler, http.MethodPut, "/kv/b", "1", header)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reusing the token for another key got %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestHTTPHandlerRetryableErrors(t *testing.T) {
+	limited := NewMemoryDatabase(WithRateLimit(rate.NewLimiter(rate.Every(time.Hour), 1), RateLimitFail))
+	handler := NewHTTPHandler(limited)
+	rec := serveTestHTTP(handler, http.MethodPut, "/kv/a", "1", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = serveTestHTTP(handler, http.MethodPut, "/kv/a", "2", nil)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("rate limited write got %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	frozen := NewMemoryDatabase()
+	err := frozen.Freeze(FreezeFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frozen.Unfreeze()
+	rec = serveTestHTTP(NewHTTPHandler(frozen), http.MethodPut, "/kv/a", "1", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("write while frozen got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultIdempotencyTTL is how long SetIdempotent remembers a token by
+	// default, well past the retries of a client timing out
+	defaultIdempotencyTTL = 10 * time.Minute
+	// defaultIdempotencyTokens bounds the tokens remembered by default
+	defaultIdempotencyTokens = 10000
+)
+
+// WithIdempotencyWindow sets how long SetIdempotent remembers a token, and how
+// many tokens it remembers at most, the oldest being forgotten first once
+// there are more. A zero ttl or max keeps the default, 10 minutes and 10000
+// tokens.
+func WithIdempotencyWindow(ttl time.Duration, max int) Option {
+	return func(db *Database) {
+		if ttl > 0 {
+			db.idempotency.ttl = ttl
+		}
+		if max > 0 {
+			db.idempotency.max = max
+		}
+	}
+}
+
+// SetIdempotent is Set for writes a client may retry, like after timing out
+// on a request that went through. The first write with a token is applied and
+// its result, error included, remembered, and a write repeating the token
+// within the window set with WithIdempotencyWindow returns that result
+// instead of being applied again. A token stands for a single write: reusing
+// it for another key or value within the window fails with an error wrapping
+// ErrTokenReused and writes nothing. A write made while one with the same
+// token is in flight waits for it. Tokens of writes that failed without
+// applying anything are forgotten, so the retry applies. Tokens are only kept
+// in memory, a retry after a restart applies again. An empty token makes it a
+// plain Set.
+func (db *Database) SetIdempotent(key string, value []byte, token string) (Result, error) {
+	return db.setIdempotent(context.Background(), key, value, token)
+}
+
+// setIdempotent is SetIdempotent, aborting with ctx.Err() like SetContext.
+func (db *Database) setIdempotent(ctx context.Context, key string, value []byte, token string) (Result, error) {
+	if token == "" {
+		return db.SetContext(ctx, key, value)
+	}
+
+	sum := sha256.Sum256(value)
+	for {
+		write, first := db.idempotency.claim(token, key, sum, db.clock.Now())
+		if write.key != key || write.sum != sum {
+			return Unchanged, fmt.Errorf("%w: token %q was used for key %q", ErrTokenReused, token, write.key)
+		}
+		if first {
+			result, err := db.SetContext(ctx, key, value)
+			// Set only fails after applying the write when followers
+			// didn't get it, retrying wouldn't help them either
+			applied := err == nil || result != Unchanged
+			db.idempotency.finish(write, result, err, applied, db.clock.Now())
+			return result, err
+		}
+
+		select {
+		case <-write.done:
+		case <-ctx.Done():
+			return Unchanged, ctx.Err()
+		}
+		if write.applied {
+			return write.result, write.err
+		}
+		// The write it waited for applied nothing, try again
+	}
+}
+
+// idempotentWrite is a write made with a token, see SetIdempotent.
+type idempotentWrite struct {
+	token string
+	// key and sum, the SHA-256 of the value, tell a retry from another write
+	// reusing the token
+	key string
+	sum [sha256.Size]byte
+	// done is closed once the write returned, setting the fields below
+	done      chan struct{}
+	result    Result
+	err       error
+	applied   bool
+	expiresAt time.Time
+}
+
+// idempotencyWindow remembers the tokens of recent writes.
+type idempotencyWindow struct {
+	ttl time.Duration
+	max int
+
+	mu     sync.Mutex
+	writes map[string]*idempotentWrite
+	// order holds the remembered writes that returned, oldest first, which
+	// with a single ttl is also the order they expire in
+	order []*idempotentWrite
+}
+
+// claim returns the write remembered for token, or a new one of key and the
+// value summed to sum and true if there is none, which the caller must make and
+// finish.
+func (w *idempotencyWindow) claim(token, key string, sum [sha256.Size]byte, now time.Time) (*idempotentWrite, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.order) > 0 && !now.Before(w.order[0].expiresAt) {
+		w.forgetOldest()
+	}
+
+	if write, ok := w.writes[token]; ok {
+		return write, false
+	}
+	if w.writes == nil {
+		w.writes = make(map[string]*idempotentWrite)
+	}
+	write := &idempotentWrite{token: token, key: key, sum: sum, done: make(chan struct{})}
+	w.writes[token] = write
+	return write, true
+}
+
+// finish records the outcome of a write claimed with claim, remembering it for
+// ttl if it was applied and forgetting it otherwise.
+func (w *idempotencyWindow) finish(write *idempotentWrite, result Result, err error, applied bool, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	write.result, write.err, write.applied = result, err, applied
+	close(write.done)
+	if !applied {
+		delete(w.writes, write.token)
+		return
+	}
+
+	write.expiresAt = now.Add(w.ttl)
+	w.order = append(w.order, write)
+	for len(w.order) > w.max {
+		w.forgetOldest()
+	}
+}
+
+// forgetOldest forgets the oldest remembered write. Callers must hold mu.
+func (w *idempotencyWindow) forgetOldest() {
+	write := w.order[0]
+	w.order[0] = nil
+	w.order = w.order[1:]
+	delete(w.writes, write.token)
+}
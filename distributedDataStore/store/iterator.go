@@ -0,0 +1,98 @@
+package store
+
+import "sort"
+
+// Iterator walks the keyspace in key order over a consistent snapshot taken
+// when it was created, later writes don't show up in it. A new iterator is
+// positioned at the first key:
+//
+//	it := db.NewIterator()
+//	defer it.Close()
+//	for it.Seek("user/"); it.Valid(); it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+//
+// The snapshot is the one View takes: it holds every shard's read lock just
+// long enough to mark the shards shared, and each shard copies its keys on its
+// first write after that, so writers aren't blocked while the iterator is in
+// use. The iterator merges the shards' sorted keys as it goes rather than
+// collecting them up front, and pins the memory of the keyspace as of the
+// snapshot until it's closed.
+type Iterator struct {
+	shards []shardView
+	now    int64
+	// pos holds the position of the iterator in the keys of each shard, cur
+	// is the shard holding the current key, -1 past the last one
+	pos []int
+	cur int
+}
+
+// NewIterator returns an iterator over a snapshot of the live keys. On a closed
+// database the iterator is empty.
+func (db *Database) NewIterator() *Iterator {
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return &Iterator{cur: -1}
+	}
+
+	v := db.snapshot()
+	it := &Iterator{shards: v.shards, now: v.now, pos: make([]int, len(v.shards))}
+	it.settle()
+	return it
+}
+
+// settle skips the expired keys at the position of each shard and moves the
+// iterator to the smallest key left.
+func (it *Iterator) settle() {
+	it.cur = -1
+	for i, s := range it.shards {
+		for it.pos[i] < len(s.keys) && s.expiredAt(s.keys[it.pos[i]], it.now) {
+			it.pos[i]++
+		}
+		if it.pos[i] < len(s.keys) && (it.cur < 0 || s.keys[it.pos[i]] < it.Key()) {
+			it.cur = i
+		}
+	}
+}
+
+// Seek moves the iterator to the first key at or after key.
+func (it *Iterator) Seek(key string) {
+	for i, s := range it.shards {
+		it.pos[i] = sort.SearchStrings(s.keys, key)
+	}
+	it.settle()
+}
+
+// Next moves the iterator to the next key.
+func (it *Iterator) Next() {
+	if it.cur >= 0 {
+		it.pos[it.cur]++
+		it.settle()
+	}
+}
+
+// Valid reports whether the iterator is positioned at a key.
+func (it *Iterator) Valid() bool {
+	return it.cur >= 0
+}
+
+// Key returns the key at the current position. It must only be called while
+// Valid.
+func (it *Iterator) Key() string {
+	return it.shards[it.cur].keys[it.pos[it.cur]]
+}
+
+// Value returns a copy of the value at the current position. It must only be
+// called while Valid.
+func (it *Iterator) Value() []byte {
+	return copyValue(it.shards[it.cur].data[it.Key()])
+}
+
+// Close releases the snapshot. The iterator is empty afterwards.
+func (it *Iterator) Close() {
+	it.shards = nil
+	it.pos = nil
+	it.cur = -1
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIteratorMergesShardsOverASnapshot(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	db := openTestDatabase(t, t.TempDir(), WithShards(4), WithClock(clock))
+	var want []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		mustSet(t, db, key, "value-"+key)
+		want = append(want, key)
+	}
+	err := db.SetWithTTL("key-05x", []byte("expired"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+
+	it := db.NewIterator()
+	defer it.Close()
+
+	// Writes after the snapshot don't show up in it
+	mustSet(t, db, "key-00", "changed")
+	mustDelete(t, db, "key-10")
+	mustSet(t, db, "key-99", "new")
+
+	var got []string
+	for ; it.Valid(); it.Next() {
+		if value := string(it.Value()); value != "value-"+it.Key() {
+			t.Fatalf("iterator has %q at %q, want its value as of the snapshot", value, it.Key())
+		}
+		got = append(got, it.Key())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterated %v, want %v", got, want)
+	}
+
+	it.Seek("key-05")
+	it.Next()
+	if !it.Valid() || it.Key() != "key-06" {
+		t.Fatal("Next after Seek didn't skip the expired key-05x to key-06")
+	}
+	it.Seek("key-2")
+	if it.Valid() {
+		t.Fatalf("Seek past the last key left the iterator at %q", it.Key())
+	}
+
+	it.Close()
+	if it.Valid() {
+		t.Fatal("closed iterator is still valid")
+	}
+	it.Seek("")
+	it.Next()
+}
@@ -0,0 +1,65 @@
+package store
+
+import "fmt"
+
+// WithMaxValueSize makes writes of values longer than n bytes fail with
+// ErrValueTooLarge before anything is logged. Zero, the default, means no
+// limit.
+func WithMaxValueSize(n int) Option {
+	return func(db *Database) {
+		db.maxValueSize = n
+	}
+}
+
+// WithMaxKeyLength makes writes of keys longer than n bytes fail with
+// ErrKeyTooLong before anything is logged. Zero, the default, means no limit.
+func WithMaxKeyLength(n int) Option {
+	return func(db *Database) {
+		db.maxKeyLength = n
+	}
+}
+
+// WithEmptyKeys allows the empty key, which Get, Set, Delete and the other
+// calls naming a key reject with ErrEmptyKey by default. It otherwise only
+// shows up in a Scan, where the empty prefix matches every key anyway, so it's
+// almost always a bug. Replay applies a record of the empty key either way.
+func WithEmptyKeys() Option {
+	return func(db *Database) {
+		db.emptyKeys = true
+	}
+}
+
+// keyLimits bounds the keys and values of writes, for a Database or a
+// TieredStore.
+type keyLimits struct {
+	// maxValueSize and maxKeyLength bound what can be written, zero means no
+	// limit
+	maxValueSize int
+	maxKeyLength int
+	// emptyKeys allows the empty key, see WithEmptyKeys
+	emptyKeys bool
+}
+
+// checkKey returns ErrEmptyKey for the empty key, unless it's allowed.
+func (l *keyLimits) checkKey(key string) error {
+	if key == "" && !l.emptyKeys {
+		return ErrEmptyKey
+	}
+	return nil
+}
+
+// checkLimits returns an error if key or value are over the configured limits,
+// or key is empty.
+func (l *keyLimits) checkLimits(key string, value []byte) error {
+	err := l.checkKey(key)
+	if err != nil {
+		return err
+	}
+	if l.maxKeyLength > 0 && len(key) > l.maxKeyLength {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLong, len(key), l.maxKeyLength)
+	}
+	if l.maxValueSize > 0 && len(value) > l.maxValueSize {
+		return fmt.Errorf("%w: %d bytes for key %q, the limit is %d", ErrValueTooLarge, len(value), key, l.maxValueSize)
+	}
+	return nil
+}
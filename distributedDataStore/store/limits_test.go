@@ -0,0 +1,147 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteLimitBoundaries(t *testing.T) {
+	db := NewMemoryDatabase(WithMaxValueSize(8), WithMaxKeyLength(4))
+
+	tests := []struct {
+		key   string
+		value string
+		want  error
+	}{
+		{key: "k", value: "", want: nil},
+		{key: "k", value: strings.Repeat("v", 8), want: nil},
+		{key: "k", value: strings.Repeat("v", 9), want: ErrValueTooLarge},
+		{key: "kkkk", value: "v", want: nil},
+		{key: "kkkkk", value: "v", want: ErrKeyTooLong},
+		{key: "", value: "v", want: ErrEmptyKey},
+	}
+	for _, tt := range tests {
+		_, err := db.Set(tt.key, []byte(tt.value))
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("Set of a %d byte key and %d byte value returned %v, want %v", len(tt.key), len(tt.value), err, tt.want)
+		}
+		_, err = db.Validate(tt.key, []byte(tt.value))
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("Validate of a %d byte key and %d byte value returned %v, want %v", len(tt.key), len(tt.value), err, tt.want)
+		}
+	}
+	checkContents(t, db, map[string]string{"k": strings.Repeat("v", 8), "kkkk": "v"})
+}
+
+func TestWriteLimitsApplyToBatches(t *testing.T) {
+	db := NewMemoryDatabase(WithMaxValueSize(8))
+	b := db.Batch()
+	b.Set("ok", []byte("v"))
+	b.Set("big", []byte(strings.Repeat("v", 9)))
+	err := b.Commit()
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("batch with a value over the limit returned %v, want ErrValueTooLarge", err)
+	}
+	// The batch is applied whole or not at all
+	checkContents(t, db, map[string]string{})
+}
+
+func TestZeroLimitsMeanNoLimit(t *testing.T) {
+	db := NewMemoryDatabase()
+	mustSet(t, db, strings.Repeat("k", 4096), strings.Repeat("v", 1<<20))
+}
+
+func TestEmptyKeyRejected(t *testing.T) {
+	appendMerge := func(existing, operand []byte) []byte {
+		return append(append([]byte(nil), existing...), operand...)
+	}
+	db := NewMemoryDatabase(WithMergeOperator(appendMerge))
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{name: "Set", op: func() error {
+			_, err := db.Set("", []byte("v"))
+			return err
+		}},
+		{name: "Get", op: func() error {
+			_, err := db.Get("")
+			return err
+		}},
+		{name: "Delete", op: func() error {
+			_, err := db.Delete("")
+			return err
+		}},
+		{name: "GetMany", op: func() error {
+			_, err := db.GetMany([]string{"k", ""})
+			return err
+		}},
+		{name: "batch", op: func() error {
+			b := db.Batch()
+			b.Set("k", []byte("v"))
+			b.Delete("")
+			return b.Commit()
+		}},
+		{name: "CompareAndSwap", op: func() error {
+			_, err := db.CompareAndSwap("", nil, []byte("v"))
+			return err
+		}},
+		{name: "CompareAndDelete", op: func() error {
+			_, err := db.CompareAndDelete("", nil)
+			return err
+		}},
+		{name: "Increment", op: func() error {
+			_, err := db.Increment("", 1)
+			return err
+		}},
+		{name: "Merge", op: func() error {
+			return db.Merge("", []byte("v"))
+		}},
+	}
+	for _, tt := range tests {
+		err := tt.op()
+		if !errors.Is(err, ErrEmptyKey) {
+			t.Fatalf("%s returned %v, want ErrEmptyKey", tt.name, err)
+		}
+	}
+	checkContents(t, db, map[string]string{})
+}
+
+func TestWithEmptyKeysAllowsEmptyKey(t *testing.T) {
+	db := NewMemoryDatabase(WithEmptyKeys())
+	mustSet(t, db, "", "empty")
+	mustSet(t, db, "k", "v")
+	value, err := db.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "empty" {
+		t.Fatalf("got %q, want %q", value, "empty")
+	}
+	entries, err := db.Scan("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != "" {
+		t.Fatalf("scan of every key returned %v, want the empty key first", entries)
+	}
+	mustDelete(t, db, "")
+	checkContents(t, db, map[string]string{"k": "v"})
+}
+
+func TestReplayAppliesLegacyEmptyKey(t *testing.T) {
+	// A log written before empty keys were rejected
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir, WithEmptyKeys())
+	mustSet(t, db, "", "legacy")
+	mustSet(t, db, "k", "v")
+
+	db = reopenTestDatabase(t, db, dir)
+	checkContents(t, db, map[string]string{"": "legacy", "k": "v"})
+	_, err := db.Get("")
+	if !errors.Is(err, ErrEmptyKey) {
+		t.Fatalf("Get returned %v, want ErrEmptyKey", err)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestOpenLogFileTwiceFails(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir)
+	mustSet(t, db, "key", "value")
+
+	second := NewDatabase(dir, "test", MinRotateSize, WithLogger(zap.NewNop()))
+	err := second.OpenLogFile()
+	if !errors.Is(err, ErrLocked) {
+		_ = second.Close()
+		t.Fatalf("second open returned %v, want ErrLocked", err)
+	}
+
+	// Closing the first releases the lock
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = openTestDatabase(t, dir)
+	checkContents(t, db, map[string]string{"key": "value"})
+}
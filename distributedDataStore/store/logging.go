@@ -0,0 +1,60 @@
+package store
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Per-operation logs are sampled per message and second: the first
+// defaultLogSampleFirst entries are logged, then every
+// defaultLogSampleThereafter-th.
+const (
+	defaultLogSampleFirst      = 100
+	defaultLogSampleThereafter = 100
+)
+
+// WithLogger sets the logger the database logs to, the global zap logger by
+// default. Every Set, Delete, rotation and compaction is logged at debug level
+// with its key, size and latency, so they only show up when the logger's
+// level enables debug.
+func WithLogger(logger *zap.Logger) Option {
+	return func(db *Database) {
+		db.logger = logger
+	}
+}
+
+// WithLogSampling sets how per-operation debug logs are sampled: in every
+// second the first of each operation are logged, then only every
+// thereafter-th. Zero thereafter drops the rest of the second. Other logs
+// aren't sampled.
+func WithLogSampling(first, thereafter int) Option {
+	return func(db *Database) {
+		db.logSampleFirst = first
+		db.logSampleThereafter = thereafter
+	}
+}
+
+// sampledLogger wraps logger so entries with the same message are sampled.
+func sampledLogger(logger *zap.Logger, first, thereafter int) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, time.Second, first, thereafter)
+	}))
+}
+
+// logOp logs a single key operation at debug level. Fields are only built
+// when the entry is going to be written, so this is cheap on the hot path
+// while debug logging is off.
+func (db *Database) logOp(op, key string, size int, start time.Time, err error) {
+	ce := db.opLogger.Check(zapcore.DebugLevel, op)
+	if ce == nil {
+		return
+	}
+	ce.Write(
+		zap.String("key", key),
+		zap.Int("bytes", size),
+		zap.Duration("latency", time.Since(start)),
+		zap.Error(err),
+	)
+}
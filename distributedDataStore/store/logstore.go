@@ -0,0 +1,107 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LogStore is where the database keeps its write-ahead log. Records are the
+// encoded payloads of log entries, the store is responsible for framing them
+// and for getting them back in the order they were appended.
+//
+// The database serializes its calls under logFileLock, so implementations
+// don't need to be safe for concurrent use by it.
+type LogStore interface {
+	// Append adds a record to the end of the log.
+	Append(record []byte) error
+	// ReadAll calls fn for every record in the log, oldest first, stopping at
+	// the first error.
+	ReadAll(fn func(record []byte) error) error
+	// Sync makes every record appended so far durable.
+	Sync() error
+	// Rotate seals the records appended so far and starts a new segment,
+	// without changing what ReadAll returns.
+	Rotate() error
+	// Truncate takes back the last n records appended to the active
+	// segment, those of a write that failed before it could be made
+	// durable. A store that can't returns ErrNotSupported, and replay may
+	// then apply the failed write.
+	Truncate(n int) error
+}
+
+// WithLogStore makes the database keep its log in store instead of the log
+// file it was created with. OpenLogFile is a no-op then. Compaction and
+// checkpoints work on log files and aren't available with other stores.
+func WithLogStore(store LogStore) Option {
+	return func(db *Database) {
+		db.store = store
+	}
+}
+
+// MemoryLogStore is a LogStore that keeps the log in memory, for tests and for
+// databases that don't need to survive a restart of the process.
+type MemoryLogStore struct {
+	mu sync.Mutex
+	// segments holds the records of each segment, the last one is active
+	segments [][][]byte
+}
+
+// NewMemoryLogStore returns an empty in-memory log.
+func NewMemoryLogStore() *MemoryLogStore {
+	return &MemoryLogStore{segments: [][][]byte{nil}}
+}
+
+func (s *MemoryLogStore) Append(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep a copy, the caller may reuse its buffer
+	copied := make([]byte, len(record))
+	copy(copied, record)
+
+	last := len(s.segments) - 1
+	s.segments[last] = append(s.segments[last], copied)
+	return nil
+}
+
+func (s *MemoryLogStore) ReadAll(fn func(record []byte) error) error {
+	// Iterate over a copy so fn doesn't run under the lock
+	s.mu.Lock()
+	var records [][]byte
+	for _, segment := range s.segments {
+		records = append(records, segment...)
+	}
+	s.mu.Unlock()
+
+	for _, record := range records {
+		err := fn(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryLogStore) Sync() error {
+	return nil
+}
+
+func (s *MemoryLogStore) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.segments = append(s.segments, nil)
+	return nil
+}
+
+func (s *MemoryLogStore) Truncate(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := len(s.segments) - 1
+	if n > len(s.segments[last]) {
+		return fmt.Errorf("active segment holds %d records, can't take back %d", len(s.segments[last]), n)
+	}
+	s.segments[last] = s.segments[last][:len(s.segments[last])-n]
+	return nil
+}
@@ -0,0 +1,25 @@
+package store
+
+// NewMemoryDatabase returns a database that keeps its keys only in memory,
+// for caches and tests that don't need them to survive the process. It has no
+// log: writes go straight to memory, without encoding a record or waiting on
+// a sync, and are lost on Close. OpenLogFile and ReplayWriteAheadLog are
+// no-ops, Compact and Checkpoint have nothing to do, and what needs log files,
+// like ReplayUntilTime or TailFrom, fails with ErrNotSupported. Options that
+// configure the log, like WithLogStore or WithShardLogs, are ignored, the others
+// apply as they do to NewDatabase. Unlike a database created with NewDatabase
+// and never opened, it reports itself healthy.
+func NewMemoryDatabase(opts ...Option) *Database {
+	return NewDatabase("", "", 0, append(opts, inMemory())...)
+}
+
+// inMemory drops the log of the database, see NewMemoryDatabase. It goes
+// after every other option, so it wins over those that set up a log.
+func inMemory() Option {
+	return func(db *Database) {
+		db.memory = true
+		db.store = nil
+		db.shardLogs = false
+		db.auditPath = ""
+	}
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryDatabase(t *testing.T) {
+	db := NewMemoryDatabase()
+	err := db.OpenLogFile()
+	if err != nil {
+		t.Fatalf("OpenLogFile returned %v, want a no-op", err)
+	}
+	stats, err := db.ReplayWriteAheadLog(nil)
+	if err != nil || stats.Records != 0 {
+		t.Fatalf("ReplayWriteAheadLog returned %+v, %v, want a no-op", stats, err)
+	}
+	err = db.Health()
+	if err != nil {
+		t.Fatalf("Health returned %v, want healthy", err)
+	}
+
+	mustSet(t, db, "a", "1")
+	mustSet(t, db, "b", "1")
+	mustDelete(t, db, "b")
+	b := db.Batch()
+	b.Set("c", []byte("1"))
+	b.Set("a", []byte("2"))
+	err = b.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, db, map[string]string{"a": "2", "c": "1"})
+	err = db.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to compact or checkpoint, and nothing to read back
+	err = db.Compact()
+	if err != nil {
+		t.Fatalf("Compact returned %v, want a no-op", err)
+	}
+	err = db.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint returned %v, want a no-op", err)
+	}
+	_, err = db.TailFrom(0)
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("TailFrom returned %v, want ErrNotSupported", err)
+	}
+	_, err = db.ReplayUntilTime(time.Now())
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("ReplayUntilTime returned %v, want ErrNotSupported", err)
+	}
+	checkContents(t, db, map[string]string{"a": "2", "c": "1"})
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Set("a", []byte("3"))
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Set after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestMemoryDatabaseIgnoresLogOptions(t *testing.T) {
+	store := NewMemoryLogStore()
+	db := NewMemoryDatabase(WithLogStore(store), WithShardLogs(), WithSyncMode(SyncOnCommit))
+	err := db.OpenLogFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSet(t, db, "a", "1")
+
+	records := 0
+	err = store.ReadAll(func([]byte) error {
+		records++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != 0 {
+		t.Fatalf("the log store given got %d records, want none", records)
+	}
+}
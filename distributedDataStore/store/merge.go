@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// MergeFunc combines the current value of a key with a merge operand into its
+// new value, existing being nil for a missing key. It must not modify its
+// arguments, and must always give the same result for the same arguments:
+// replay and followers apply it again to the records Merge logs.
+type MergeFunc func(existing, operand []byte) []byte
+
+// WithMergeOperator registers the function Merge combines values with. A
+// database replaying a log with merge records needs the same one, and so do
+// its followers.
+func WithMergeOperator(fn MergeFunc) Option {
+	return func(db *Database) {
+		db.merge = fn
+	}
+}
+
+// Merge sets key to the merge operator applied to its current value and
+// operand, under the shard lock, so concurrent merges of a key never lose an
+// update, like a counter or a list append done as a read-modify-write by the
+// caller would. The key keeps its TTL and metadata, if it has them.
+//
+// Only the operand is logged, in a MERGE record that replay applies to the
+// value as of the records before it, and watchers get a MERGE event carrying
+// the operand. Merging a missing key logs the merged value as an INSERT. The
+// merged value is subject to WithMaxValueSize, the operand isn't.
+func (db *Database) Merge(key string, operand []byte) (err error) {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if db.merge == nil {
+		return ErrNoMergeOperator
+	}
+	err = db.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = db.admit(context.Background(), 1, len(key)+len(operand))
+	if err != nil {
+		return err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	s.mu.Lock()
+	if db.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+
+	err = db.mergeLocked(key, operand)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return leader.awaitAcks(seq)
+}
+
+// mergeLocked is Merge for callers that already hold the write lock of the
+// key's shard.
+func (db *Database) mergeLocked(key string, operand []byte) error {
+	s := db.shardFor(key)
+	existing, ok := s.lookup(key)
+	merged := db.merge(existing, operand)
+	err := db.checkLimits(key, merged)
+	if err != nil {
+		return err
+	}
+
+	logEntry := &contract.LogEntry{
+		Op:        MERGE,
+		Key:       key,
+		Value:     operand,
+		ExpiresAt: s.expiry[key],
+		Version:   db.versionSeq.Add(1),
+		Timestamp: db.writeTimestamp(),
+		Metadata:  copyMetadata(s.meta[key].metadata),
+	}
+	if !ok {
+		// Replay can't tell the key was missing from it having expired
+		// since, the merged value stands on its own
+		logEntry.Op = INSERT
+		logEntry.Value = merged
+		logEntry.ExpiresAt = 0
+		logEntry.Metadata = nil
+	}
+
+	err = db.writeLogEntries(logEntry)
+	if err != nil {
+		return err
+	}
+
+	s.put(key, merged, logEntry.Version)
+	s.setExpiry(key, logEntry.ExpiresAt)
+	s.setMeta(key, logEntry.Timestamp, logEntry.Metadata)
+	return nil
+}
+
+// applyMerge replays a MERGE record. The key it merged into can only be
+// missing if it has expired since, so has the merged value then. Callers must
+// hold the write lock of the key's shard.
+func (db *Database) applyMerge(entry *contract.LogEntry) error {
+	if db.merge == nil {
+		return fmt.Errorf("%w to replay the merge into key %q", ErrNoMergeOperator, entry.Key)
+	}
+
+	s := db.shardFor(entry.Key)
+	if entry.ExpiresAt != 0 && entry.ExpiresAt <= db.clock.Now().UnixNano() {
+		s.remove(entry.Key)
+		return nil
+	}
+
+	existing, _ := s.lookup(entry.Key)
+	s.put(entry.Key, db.merge(existing, entry.Value), db.observeVersion(entry.Version))
+	s.setExpiry(entry.Key, entry.ExpiresAt)
+	s.setMeta(entry.Key, db.observeTimestamp(entry.Timestamp), entry.Metadata)
+	return nil
+}
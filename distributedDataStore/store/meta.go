@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Meta describes the last write to a key, see GetWithMeta.
+type Meta struct {
+	// Timestamp is when the key was written, zero for keys last written
+	// before writes were timestamped
+	Timestamp time.Time
+	// Metadata is what SetWithMeta attached to the value, nil if nothing was
+	Metadata map[string]string
+}
+
+// recordMeta is the Meta of a key as it's held in memory.
+type recordMeta struct {
+	timestamp int64
+	metadata  map[string]string
+}
+
+// SetWithMeta is Set that attaches metadata to the value, like its content
+// type or the node it came from. Metadata is stored in the log record of the
+// write, so it survives replay, compaction and replication, and is returned by
+// GetWithMeta and with watch events. It belongs to the value: any later write
+// of the key without metadata clears it. Keep it small, it's held in memory
+// with every key.
+func (db *Database) SetWithMeta(key string, value []byte, metadata map[string]string) error {
+	setsTotal.Inc()
+	_, err := db.set(context.Background(), key, value, 0, metadata)
+	return err
+}
+
+// GetWithMeta is Get that also returns when key was written and the metadata
+// of its value.
+func (db *Database) GetWithMeta(key string) ([]byte, Meta, error) {
+	getsTotal.Inc()
+
+	err := db.checkKey(key)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+
+	s := db.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db.closed {
+		return nil, Meta{}, ErrClosed
+	}
+
+	value, ok := s.lookup(key)
+	if !ok {
+		getMissesTotal.Inc()
+		return nil, Meta{}, ErrKeyNotFound
+	}
+
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	meta := s.meta[key]
+	return copied, Meta{Timestamp: writeTime(meta.timestamp), Metadata: copyMetadata(meta.metadata)}, nil
+}
+
+// setMeta records when key was written and the metadata of its value, and
+// indexes the metadata. Callers must hold the shard lock for writing.
+func (s *shard) setMeta(key string, timestamp int64, metadata map[string]string) {
+	s.tags.remove(key, s.meta[key].metadata)
+	s.tags.add(key, metadata)
+	if timestamp == 0 && len(metadata) == 0 {
+		delete(s.meta, key)
+		return
+	}
+	s.meta[key] = recordMeta{timestamp: timestamp, metadata: metadata}
+}
+
+// unadorned reports whether key has neither a TTL nor metadata, so writing
+// its current value again without either changes nothing. Callers must hold
+// the shard lock.
+func (s *shard) unadorned(key string) bool {
+	return s.expiry[key] == 0 && len(s.meta[key].metadata) == 0
+}
+
+// GetIfModifiedSince is Get for conditional reads, like HTTP's
+// If-Modified-Since: it returns the value of key only if it was written after
+// since, and ErrNotModified otherwise. Keys last written before writes were
+// timestamped always count as modified.
+func (db *Database) GetIfModifiedSince(key string, since time.Time) ([]byte, error) {
+	value, meta, err := db.GetWithMeta(key)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.Timestamp.IsZero() && !meta.Timestamp.After(since) {
+		return nil, ErrNotModified
+	}
+	return value, nil
+}
+
+// writeTimestamp returns the timestamp of a new write in unix nanoseconds. It
+// never goes back, even if the clock does, like after an NTP adjustment, so a
+// write never looks older than one before it. It runs ahead of the clock
+// instead until the clock catches up.
+func (db *Database) writeTimestamp() int64 {
+	now := db.clock.Now().UnixNano()
+	for {
+		last := db.lastTimestamp.Load()
+		next := now
+		if next <= last {
+			next = last + 1
+		}
+		if db.lastTimestamp.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// observeTimestamp returns the timestamp of a replayed or replicated record,
+// making sure the timestamps handed out later are greater, so a clock that's
+// behind after a restart doesn't date new writes before older ones.
+func (db *Database) observeTimestamp(timestamp int64) int64 {
+	for {
+		last := db.lastTimestamp.Load()
+		if last >= timestamp || db.lastTimestamp.CompareAndSwap(last, timestamp) {
+			return timestamp
+		}
+	}
+}
+
+// writeTime converts the timestamp of a log record to a time, the zero time
+// for records without one.
+func writeTime(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, timestamp)
+}
+
+func equalMetadata(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// copyMetadata copies metadata so the caller can't change what's stored, nil
+// if it's empty.
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered with prometheus.DefaultRegisterer and exposed on
+// /metrics by the HTTP server. They're process wide, a process running several
+// databases reports their sum.
+var (
+	metricsFactory = promauto.With(prometheus.DefaultRegisterer)
+
+	setsTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Name: "datastore_sets_total",
+		Help: "Number of Set calls.",
+	})
+	deletesTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Name: "datastore_deletes_total",
+		Help: "Number of Delete calls.",
+	})
+	getsTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Name: "datastore_gets_total",
+		Help: "Number of Get calls.",
+	})
+	getMissesTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Name: "datastore_get_misses_total",
+		Help: "Number of Get calls for a missing key.",
+	})
+	rotationsTotal = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Name: "datastore_log_rotations_total",
+		Help: "Number of times the log file was rotated.",
+	})
+	keysGauge = metricsFactory.NewGauge(prometheus.GaugeOpts{
+		Name: "datastore_keys",
+		Help: "Number of keys held in memory, including expired keys not swept yet.",
+	})
+	replayDuration = metricsFactory.NewGauge(prometheus.GaugeOpts{
+		Name: "datastore_replay_duration_seconds",
+		Help: "Duration of the last replay of the log.",
+	})
+	logFileSizeGauge = metricsFactory.NewGauge(prometheus.GaugeOpts{
+		Name: "datastore_log_file_size_bytes",
+		Help: "Size of the active log file.",
+	})
+	compactionReclaimedBytes = metricsFactory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "datastore_compaction_reclaimed_bytes",
+		Help:    "Bytes of log reclaimed by each compaction.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+	replicationLagRecords = metricsFactory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_replication_lag_records",
+		Help: "Records a follower hasn't acknowledged yet, by follower address.",
+	}, []string{"follower"})
+	replicationLagBytes = metricsFactory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_replication_lag_bytes",
+		Help: "Encoded size of the records a follower hasn't acknowledged yet, by follower address.",
+	}, []string{"follower"})
+	setDuration = metricsFactory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "datastore_set_duration_seconds",
+		Help:    "Latency of Set calls, including the log write.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	})
+)
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// WithMmapReplay makes ReplayWriteAheadLog map each log file into memory and
+// parse its records from the mapping, instead of reading every record with
+// its own system calls. Values are still copied out of the mapping, which is
+// released once the file is replayed. On platforms without mmap the files are
+// read as usual.
+func WithMmapReplay() Option {
+	return func(db *Database) {
+		db.mmapReplay = true
+	}
+}
+
+// mapLogFile returns a reader over the first size bytes of file mapped into
+// memory and a func releasing the mapping once done with it. It returns false
+// when the file can't be mapped, in which case it should be read directly.
+func mapLogFile(file *os.File, size int64) (io.ReaderAt, func(), bool) {
+	if size == 0 || int64(int(size)) != size {
+		return nil, nil, false
+	}
+
+	data, err := mmapFile(file, int(size))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return bytes.NewReader(data), func() { _ = munmapFile(data) }, true
+}
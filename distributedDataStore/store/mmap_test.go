@@ -0,0 +1,57 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// BenchmarkReplay replays a log of a million records, reading each record
+// with its own system calls and from a memory mapping.
+func BenchmarkReplay(b *testing.B) {
+	const records, keys = 1000000, 100000
+	dir := b.TempDir()
+	// A single file, so it's the reads being measured rather than rotations
+	db := NewDatabase(dir, "test", 1<<30, WithLogger(zap.NewNop()), WithSyncMode(SyncNone), WithWriteBuffer(1<<20), WithValueDedup(false))
+	err := db.OpenLogFile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := []byte("value of a hundred bytes or so, about what a small record holds, padded out to the right length....")
+	for i := 0; i < records; i++ {
+		_, err = db.Set(fmt.Sprintf("key-%d", i%keys), value)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	err = db.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	modes := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "read"},
+		{name: "mmap", opts: []Option{WithMmapReplay()}},
+	}
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			opts := append([]Option{WithLogger(zap.NewNop())}, m.opts...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				db := NewDatabase(dir, "test", 1<<30, opts...)
+				stats, err := db.ReplayWriteAheadLog(nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if stats.Records != records {
+					b.Fatalf("replayed %d records, want %d", stats.Records, records)
+				}
+				_ = db.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"errors"
+	"personalMonorepo/distributedDataStore/contract"
+	"runtime"
+	"time"
+)
+
+// WithParallelReplay makes ReplayWriteAheadLog read and decode several log
+// files at once, up to one per CPU, while the records decoded so far are
+// applied. Records are still applied one at a time in log order, so the result
+// is the same as a sequential replay, only the decoding moves off the critical
+// path. It pays off for logs made of many segments, at the cost of holding the
+// decoded records of a few segments in memory.
+func WithParallelReplay() Option {
+	return func(db *Database) {
+		db.parallelReplay = true
+	}
+}
+
+// decodedSegment holds the records of a log file decoded ahead of being
+// applied.
+type decodedSegment struct {
+	records []decodedRecord
+	skipped int
+	err     error
+}
+
+type decodedRecord struct {
+	entry *contract.LogEntry
+	// size is the size of the record in the log
+	size int64
+}
+
+// replayParallel replays files in order, decoding the ones ahead of the file
+// being applied concurrently.
+func (r *replayer) replayParallel(files []segmentStart) error {
+	db := r.db
+	sugar := db.logger.Sugar()
+
+	decoded := make([]chan decodedSegment, len(files))
+	for i := range decoded {
+		decoded[i] = make(chan decodedSegment, 1)
+	}
+
+	// slots bounds the files decoded but not applied yet, so memory use
+	// doesn't grow with the number of segments
+	slots := make(chan struct{}, runtime.GOMAXPROCS(0))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, file := range files {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(out chan<- decodedSegment, file segmentStart) {
+				out <- db.decodeSegment(file.path, file.start, r.deadline)
+			}(decoded[i], file)
+		}
+	}()
+
+	for i, file := range files {
+		sugar.Infof("Replaying segment %s", file.path)
+		segment := <-decoded[i]
+		r.stats.SkippedCorrupt += segment.skipped
+		var stopped *replayDeadlineError
+		if segment.err != nil && !errors.As(segment.err, &stopped) {
+			return segment.err
+		}
+
+		for _, record := range segment.records {
+			err := r.replayEntry(record.entry, record.size)
+			if err != nil {
+				return err
+			}
+		}
+		if stopped != nil {
+			// The records read before the deadline are applied, so the
+			// position it stopped at holds
+			return segment.err
+		}
+		r.finish(file.path)
+		<-slots
+	}
+
+	return nil
+}
+
+// decodeSegment reads and decodes the records of the log file at path from
+// offset start on, until deadline if it's set.
+func (db *Database) decodeSegment(path string, start int64, deadline time.Time) decodedSegment {
+	var segment decodedSegment
+	opts := db.replayReadOptions()
+	opts.deadline = deadline
+	segment.skipped, segment.err = readLogFile(db.logger, path, start, opts, func(header logHeader, record []byte) error {
+		entry := &contract.LogEntry{}
+		err := db.decodeLogEntry(header, record, entry)
+		if err != nil {
+			return err
+		}
+		segment.records = append(segment.records, decodedRecord{
+			entry: entry,
+			size:  int64(recordPrefixSize(header.version) + len(record)),
+		})
+		return nil
+	})
+	return segment
+}
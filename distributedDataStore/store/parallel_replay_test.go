@@ -0,0 +1,104 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// writeRandomLog writes a random history of sets, deletes and batches over a
+// small keyspace to the database in dir, rotating the log now and then so it
+// spans many segments, and returns what the database holds in the end.
+func writeRandomLog(t *testing.T, dir string, rng *rand.Rand) map[string]string {
+	t.Helper()
+
+	db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit))
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key-%d", rng.Intn(50))
+		switch n := rng.Intn(100); {
+		case n < 60:
+			mustSet(t, db, key, fmt.Sprintf("value-%d", i))
+		case n < 80:
+			mustDelete(t, db, key)
+		case n < 95:
+			b := db.Batch()
+			for j := rng.Intn(5); j >= 0; j-- {
+				other := fmt.Sprintf("key-%d", rng.Intn(50))
+				if rng.Intn(3) == 0 {
+					b.Delete(other)
+				} else {
+					b.Set(other, []byte(fmt.Sprintf("batch-%d-%d", i, j)))
+				}
+			}
+			err := b.Commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+		default:
+			rotateTestLog(t, db)
+		}
+	}
+
+	want := make(map[string]string)
+	db.ForEach(func(key string, value []byte) bool {
+		want[key] = string(value)
+		return true
+	})
+	err := db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return want
+}
+
+// replayTestDatabase replays the database in dir without opening its log for
+// writing.
+func replayTestDatabase(t *testing.T, dir string, opts ...Option) (*Database, ReplayStats) {
+	t.Helper()
+
+	opts = append([]Option{WithLogger(zap.NewNop())}, opts...)
+	db := NewDatabase(dir, "test", MinRotateSize, opts...)
+	t.Cleanup(func() { _ = db.Close() })
+	stats, err := db.ReplayWriteAheadLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, stats
+}
+
+func TestParallelReplayMatchesSequentialReplay(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			dir := t.TempDir()
+			want := writeRandomLog(t, dir, rand.New(rand.NewSource(seed)))
+			if segments := len(segmentNames(t, dir)); segments < 10 {
+				t.Fatalf("log has %d segments, too few to replay in parallel", segments)
+			}
+
+			sequential, sequentialStats := replayTestDatabase(t, dir)
+			parallel, parallelStats := replayTestDatabase(t, dir, WithParallelReplay())
+			checkContents(t, sequential, want)
+			checkContents(t, parallel, want)
+
+			for key := range want {
+				_, v1, err := sequential.GetVersion(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, v2, err := parallel.GetVersion(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v1 != v2 {
+					t.Fatalf("key %q at version %d replayed sequentially, %d in parallel", key, v1, v2)
+				}
+			}
+			sequentialStats.Elapsed, parallelStats.Elapsed = 0, 0
+			if sequentialStats != parallelStats {
+				t.Fatalf("sequential replay %+v, parallel %+v", sequentialStats, parallelStats)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"time"
+)
+
+// errReplayLimit stops a point-in-time replay once it reaches its limit.
+var errReplayLimit = errors.New("replay limit reached")
+
+// ReplayWriteAheadLogUntil returns a throwaway, read-only database holding the
+// state as of log offset offset: every record that ends at or before it is
+// applied, counting offsets like TailFrom does. A batch is only applied if its
+// commit comes before the offset. The database itself is left untouched, and
+// the returned one should be closed once it's no longer needed.
+//
+// It replays the log from its oldest segment rather than from the checkpoint,
+// so it only goes back as far as the log still does: segments removed by
+// WithSegmentRetention are gone, and compaction folds the segments it seals
+// into the state they end in. It needs the log files, a database with another
+// LogStore or with shard logs returns ErrNotSupported.
+func (db *Database) ReplayWriteAheadLogUntil(offset int64) (*Database, error) {
+	return db.replayUntil(db.clock, func(entry *contract.LogEntry, end int64) bool {
+		return end > offset
+	})
+}
+
+// ReplayUntilTime is ReplayWriteAheadLogUntil for the state as of t: every
+// record written at or before t is applied. The returned database reads its
+// time from a ManualClock standing at t, so keys that had expired by t read as
+// missing, and those that expired since still read as set.
+func (db *Database) ReplayUntilTime(t time.Time) (*Database, error) {
+	until := t.UnixNano()
+	return db.replayUntil(NewManualClock(t), func(entry *contract.LogEntry, end int64) bool {
+		// Timestamps never go back, the first record after t ends it. Batch
+		// markers and records of older logs have none.
+		return entry.Timestamp != 0 && entry.Timestamp > until
+	})
+}
+
+// replayUntil replays the log into a fresh read-only database until stop
+// returns true for a record, given the log offset right after it.
+func (db *Database) replayUntil(clock Clock, stop func(entry *contract.LogEntry, end int64) bool) (*Database, error) {
+	if db.shardLogs || db.memory {
+		return nil, ErrNotSupported
+	}
+	if _, ok := db.store.(*FileLogStore); db.store != nil && !ok {
+		return nil, ErrNotSupported
+	}
+
+	past := NewDatabase(filepath.Dir(db.logFile), db.name, db.rotateSize,
+		WithReadOnly(), WithShards(len(db.shards)), WithLogger(db.logger), WithClock(clock))
+	past.aead = db.aead
+	past.strictReplay = db.strictReplay
+	past.merge = db.merge
+
+	// Keep compaction from removing segments while they're read
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+	lockShards(past.shards)
+	defer unlockShards(past.shards)
+
+	segments, err := discoverSegments(db.logFile)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replayer{db: past, stats: &ReplayStats{}, started: time.Now()}
+	var base int64
+	for _, segment := range segments {
+		size, err := r.replayUntil(segment, base, stop)
+		if errors.Is(err, errReplayLimit) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		base += size
+	}
+	past.rebuildBloomsLocked()
+
+	db.logger.Sugar().Infof("Replayed %d records into a point-in-time copy, %d inserts, %d updates, %d deletes",
+		r.stats.Records, r.stats.Inserts, r.stats.Updates, r.stats.Deletes)
+	return past, nil
+}
+
+// replayUntil replays the records of the log file at path, which starts at log
+// offset base, until stop returns true, and returns the size of the file. A
+// record cut short at the end of the file ends it as it does replay.
+func (r *replayer) replayUntil(path string, base int64, stop func(entry *contract.LogEntry, end int64) bool) (int64, error) {
+	db := r.db
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Not written yet
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	header, offset, err := readLogHeader(file)
+	if err != nil {
+		return 0, err
+	}
+
+	for offset < info.Size() {
+		payload, next, err := readRecord(file, offset, info.Size(), header.version)
+		if err == io.EOF || next > info.Size() {
+			break
+		}
+		if err == ErrCorruptRecord && !db.strictReplay {
+			db.logger.Sugar().Warnf("Skipping corrupt record at offset %d of %s", offset, path)
+			offset = next
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		entry := &contract.LogEntry{}
+		err = db.decodeLogEntry(header, payload, entry)
+		if err != nil {
+			return 0, err
+		}
+		if stop(entry, base+next) {
+			return 0, errReplayLimit
+		}
+		err = r.replayEntry(entry, next-offset)
+		if err != nil {
+			return 0, err
+		}
+		offset = next
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,319 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// ErrNotLeader is returned by writes sent to a Raft node that isn't the
+// leader, wrapped in a NotLeaderError naming the current leader.
+var ErrNotLeader = errors.New("not the raft leader")
+
+// NotLeaderError redirects a write to the leader of the cluster. Leader is
+// empty while no leader is known, during an election for example.
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return fmt.Sprintf("%s, no leader elected", ErrNotLeader)
+	}
+	return fmt.Sprintf("%s, leader is %s", ErrNotLeader, e.Leader)
+}
+
+func (e *NotLeaderError) Unwrap() error {
+	return ErrNotLeader
+}
+
+// defaultRaftApplyTimeout bounds how long a write waits to be committed.
+const defaultRaftApplyTimeout = 10 * time.Second
+
+// RaftNode replicates the writes of a Database through a Raft log, so a write
+// only succeeds once a majority of the cluster has it and a minority failing
+// can't lose it.
+//
+// Every Raft command is an encoded LogEntry. Once committed it is applied to
+// the database, which writes it to its own log like any other write. Snapshots
+// of the state machine are written in the log file format, from the same live
+// records compaction keeps.
+//
+// The Raft log itself is kept in memory in this first cut, only snapshots are
+// written under the node's directory. A node that restarts rejoins from the
+// leader's snapshot rather than its own Raft log.
+type RaftNode struct {
+	db        *Database
+	raft      *raft.Raft
+	transport *raft.NetworkTransport
+}
+
+// StartRaft starts a Raft node with the given id, talking to its peers on
+// addr and keeping snapshots in dir. Bootstrapping makes it the only voter of a
+// new cluster, other nodes are added to it with Join.
+func StartRaft(db *Database, id, addr, dir string, bootstrap bool) (*RaftNode, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(id)
+	config.Logger = hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Warn})
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransport(addr, tcpAddr, 3, 10*time.Second, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(filepath.Join(dir, "snapshots"), 2, io.Discard)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	store := raft.NewInmemStore()
+	node := &RaftNode{db: db, transport: transport}
+	node.raft, err = raft.NewRaft(config, &raftFSM{db: db}, store, store, snapshots, transport)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	if bootstrap {
+		err = node.raft.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{{ID: config.LocalID, Address: transport.LocalAddr()}},
+		}).Error()
+		if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			node.Close()
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// Join adds the node with the given id listening on addr to the cluster as a
+// voter. It must be called on the leader.
+func (n *RaftNode) Join(id, addr string) error {
+	return n.leaderErr(n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0).Error())
+}
+
+// Leave removes the node with the given id from the cluster. It must be
+// called on the leader.
+func (n *RaftNode) Leave(id string) error {
+	return n.leaderErr(n.raft.RemoveServer(raft.ServerID(id), 0, 0).Error())
+}
+
+// Leader returns the Raft address of the current leader, empty while there is
+// none.
+func (n *RaftNode) Leader() string {
+	return string(n.raft.Leader())
+}
+
+// IsLeader reports whether this node is the leader.
+func (n *RaftNode) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Set sets key to value once a majority of the cluster has committed it.
+// Followers return a NotLeaderError naming the leader to retry against.
+func (n *RaftNode) Set(key string, value []byte) error {
+	_, err := n.apply(&contract.LogEntry{Op: INSERT, Key: key, Value: value})
+	return err
+}
+
+// SetWithTTL is Set for a key that expires after ttl.
+func (n *RaftNode) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	_, err := n.apply(&contract.LogEntry{
+		Op:        INSERT,
+		Key:       key,
+		Value:     value,
+		ExpiresAt: n.db.clock.Now().Add(ttl).UnixNano(),
+	})
+	return err
+}
+
+// Delete deletes key once a majority of the cluster has committed it, and
+// reports whether it was set. Followers return a NotLeaderError naming the
+// leader to retry against.
+func (n *RaftNode) Delete(key string) (bool, error) {
+	resp, err := n.apply(&contract.LogEntry{Op: DELETE, Key: key})
+	existed, _ := resp.(bool)
+	return existed, err
+}
+
+// Get reads key from the local database. After Barrier returns on the leader
+// this sees every write committed before it, followers may lag behind.
+func (n *RaftNode) Get(key string) ([]byte, error) {
+	return n.db.Get(key)
+}
+
+// Barrier waits until every write committed so far has been applied to the
+// local database. It must be called on the leader.
+func (n *RaftNode) Barrier() error {
+	return n.leaderErr(n.raft.Barrier(defaultRaftApplyTimeout).Error())
+}
+
+// Close leaves the cluster running without this node and stops it. The
+// database is left open.
+func (n *RaftNode) Close() error {
+	err := n.raft.Shutdown().Error()
+	if closeErr := n.transport.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// apply commits entry and returns what the state machine responded with.
+func (n *RaftNode) apply(entry *contract.LogEntry) (interface{}, error) {
+	if entry.Op != DELETE {
+		// Reject oversized writes before they take up room in the Raft log
+		err := n.db.checkLimits(entry.Key, entry.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cmd, err := proto.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	future := n.raft.Apply(cmd, defaultRaftApplyTimeout)
+	err = n.leaderErr(future.Error())
+	if err != nil {
+		return nil, err
+	}
+
+	// Errors returned by the state machine come back as the response
+	resp := future.Response()
+	if err, ok := resp.(error); ok {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// leaderErr turns the errors Raft returns on followers into a redirect to the
+// leader.
+func (n *RaftNode) leaderErr(err error) error {
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		return &NotLeaderError{Leader: n.Leader()}
+	}
+	return err
+}
+
+// raftFSM applies committed Raft commands to a Database.
+type raftFSM struct {
+	db *Database
+}
+
+func (f *raftFSM) Apply(log *raft.Log) interface{} {
+	entry := &contract.LogEntry{}
+	err := proto.Unmarshal(log.Data, entry)
+	if err != nil {
+		return err
+	}
+
+	db := f.db
+
+	// Committed commands can't be turned away, they wait out a freeze
+	db.freezeLock.RLock()
+	defer db.freezeLock.RUnlock()
+	s := db.shardFor(entry.Key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	switch entry.Op {
+	case INSERT, UPDATE:
+		_, err := db.setLocked(entry.Key, entry.Value, entry.ExpiresAt, entry.Metadata)
+		return err
+	case DELETE:
+		existed, err := db.deleteLocked(entry.Key)
+		if err != nil {
+			return err
+		}
+		return existed
+	default:
+		return fmt.Errorf("%w %d for key %q", ErrUnknownOp, entry.Op, entry.Key)
+	}
+}
+
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	db := f.db
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	return &raftSnapshot{db: db, entries: db.liveEntries()}, nil
+}
+
+// Restore replaces the state of the database with a snapshot written by
+// raftSnapshot.Persist.
+func (f *raftFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	data, err := io.ReadAll(snapshot)
+	if err != nil {
+		return err
+	}
+
+	r := bytes.NewReader(data)
+	header, offset, err := readLogHeader(r)
+	if err != nil {
+		return err
+	}
+
+	var entries []*contract.LogEntry
+	for offset < int64(len(data)) {
+		var record []byte
+		record, offset, err = readRecord(r, offset, int64(len(data)), header.version)
+		if err != nil {
+			return err
+		}
+
+		entry := &contract.LogEntry{}
+		err = f.db.decodeLogEntry(header, record, entry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	return f.db.replaceState(entries)
+}
+
+// raftSnapshot is a copy of the live records of a database taken when Raft
+// asked for a snapshot.
+type raftSnapshot struct {
+	db      *Database
+	entries []*contract.LogEntry
+}
+
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := s.db.writeEntries(sink, s.entries)
+	if err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *raftSnapshot) Release() {}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"personalMonorepo/distributedDataStore/contract"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitPolicy decides what a write does when a limiter set with
+// WithRateLimit or WithByteRateLimit has no tokens left for it.
+type RateLimitPolicy int
+
+const (
+	// RateLimitBlock makes the write wait for its tokens.
+	RateLimitBlock RateLimitPolicy = iota
+	// RateLimitFail fails the write with ErrRateLimited without writing
+	// anything, leaving it to the caller to back off and retry.
+	RateLimitFail
+)
+
+// writeLimit admits writes through a token bucket.
+type writeLimit struct {
+	limiter *rate.Limiter
+	policy  RateLimitPolicy
+}
+
+// WithRateLimit admits writes through limiter, taking a token for every key a
+// write sets or deletes, a batch or transaction taking one per key. The policy
+// picks between waiting for tokens and failing with ErrRateLimited. Every call
+// that may write is admitted before it finds out whether it does, BulkLoad,
+// Truncate and the eviction of expired keys aren't. A limiter can be shared by
+// several databases to bound their writes together.
+func WithRateLimit(limiter *rate.Limiter, policy RateLimitPolicy) Option {
+	return func(db *Database) {
+		db.opLimit = &writeLimit{limiter: limiter, policy: policy}
+	}
+}
+
+// WithByteRateLimit is WithRateLimit taking a token for every byte of the keys
+// and values written instead. A write of more bytes than the limiter's burst
+// always fails with ErrRateLimited.
+func WithByteRateLimit(limiter *rate.Limiter, policy RateLimitPolicy) Option {
+	return func(db *Database) {
+		db.byteLimit = &writeLimit{limiter: limiter, policy: policy}
+	}
+}
+
+// admit takes the tokens for a write of ops keys and bytes bytes of keys and
+// values, waiting for them until ctx is done under RateLimitBlock, and then
+// lets the write through the freeze, see enterWrite. A write admitted must call
+// exitWrite once it's done. It must be called before taking any lock, so a
+// waiting write doesn't hold up others.
+//
+// A write the database turns away anyway fails before it takes any tokens,
+// see writeGate.
+func (db *Database) admit(ctx context.Context, ops, bytes int) error {
+	err := db.writeGate()
+	if err != nil {
+		return err
+	}
+	err = db.opLimit.take(ctx, ops)
+	if err != nil {
+		return err
+	}
+	err = db.byteLimit.take(ctx, bytes)
+	if err != nil {
+		return err
+	}
+	return db.enterWrite(ctx)
+}
+
+// writeGate returns the error a write would fail with whatever its tokens: the
+// database is closed, read-only, holding a partial replay that wasn't accepted
+// or frozen under FreezeFail. The write path checks again under its locks,
+// this only keeps the writes it catches from spending tokens.
+func (db *Database) writeGate() error {
+	select {
+	case <-db.done:
+		return ErrClosed
+	default:
+	}
+
+	switch {
+	case db.readOnly:
+		return ErrReadOnly
+	case db.partialReplay.Load() && !db.partialAccepted.Load():
+		return ErrPartialReplay
+	case db.freezing.Load() && FreezePolicy(db.freezePolicy.Load()) == FreezeFail:
+		return ErrFrozen
+	}
+	return nil
+}
+
+// admitEntries is admit for a batch of records.
+func (db *Database) admitEntries(entries []*contract.LogEntry) error {
+	if db.opLimit == nil && db.byteLimit == nil {
+		return db.enterWrite(context.Background())
+	}
+
+	bytes := 0
+	for _, entry := range entries {
+		bytes += len(entry.Key) + len(entry.Value)
+	}
+	return db.admit(context.Background(), len(entries), bytes)
+}
+
+func (l *writeLimit) take(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	if n > l.limiter.Burst() && l.limiter.Limit() != rate.Inf {
+		return fmt.Errorf("%w: a write of %d tokens never fits a burst of %d", ErrRateLimited, n, l.limiter.Burst())
+	}
+
+	if l.policy == RateLimitFail {
+		if !l.limiter.AllowN(time.Now(), n) {
+			return ErrRateLimited
+		}
+		return nil
+	}
+	return l.limiter.WaitN(ctx, n)
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRejectedWritesKeepTheirTokens(t *testing.T) {
+	// A single token that's only replenished long after the test is over
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	db := openTestDatabase(t, t.TempDir(), WithRateLimit(limiter, RateLimitFail))
+
+	err := db.Freeze(FreezeFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Set("key", []byte("value"))
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("write during a freeze returned %v, want ErrFrozen", err)
+	}
+	db.Unfreeze()
+
+	db.partialReplay.Store(true)
+	_, err = db.Set("key", []byte("value"))
+	if !errors.Is(err, ErrPartialReplay) {
+		t.Fatalf("write during a partial replay returned %v, want ErrPartialReplay", err)
+	}
+	db.partialReplay.Store(false)
+
+	// Neither rejected write spent the token
+	mustSet(t, db, "key", "value")
+	_, err = db.Set("other", []byte("value"))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("write past the token returned %v, want ErrRateLimited", err)
+	}
+}
@@ -0,0 +1,309 @@
+package store
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// A log file starts with a header made of a four byte magic, a format version
+// byte and, from version 2 on, a byte identifying the codec the record payloads
+// are encoded with. Every record after the header is framed as
+//
+//	| length (4 bytes LE) | CRC32C of length (4 bytes LE) | CRC32C of payload (4 bytes LE) | payload |
+//
+// so a corrupt length is caught before it's used to find the end of the record.
+// From version 3 on the payload starts with a flags byte saying how the rest of
+// it was compressed, see payloadFlag. Versions 1 to 3 frame records without
+// the checksum of the length.
+//
+// Version 1 logs have no codec byte and are always proto encoded. Files that
+// don't start with the magic are legacy logs written before the header
+// existed. Their records are the length prefix followed directly by the
+// payload, without a checksum.
+const (
+	logMagic = "DDSL"
+
+	legacyFormatVersion  byte = 0
+	crcFormatVersion     byte = 1
+	codecFormatVersion   byte = 2
+	flagsFormatVersion   byte = 3
+	lengthFormatVersion  byte = 4
+	currentFormatVersion      = lengthFormatVersion
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// logHeader describes how the records of a log file are written.
+type logHeader struct {
+	version byte
+	codec   Codec
+}
+
+func encodeLogHeader(codec Codec) []byte {
+	header := make([]byte, 0, len(logMagic)+2)
+	header = append(header, logMagic...)
+	return append(header, currentFormatVersion, codec.ID())
+}
+
+// tornHeader reports whether file, the whole content of a log file, is the
+// start of a header that was cut short, by a crash while a fresh log was being
+// stamped. A legacy log can't start like that, its first record's length
+// would have to be over a gigabyte.
+func tornHeader(file []byte) bool {
+	n := len(file)
+	if n == 0 || n >= len(logMagic)+2 {
+		return false
+	}
+	if n <= len(logMagic) {
+		return string(file) == logMagic[:n]
+	}
+	// Version 1 headers end after the version byte
+	version := file[len(logMagic)]
+	return string(file[:len(logMagic)]) == logMagic && version >= codecFormatVersion && version <= currentFormatVersion
+}
+
+// readLogHeader returns the header of the log and the offset of its first
+// record.
+func readLogHeader(r io.ReaderAt) (logHeader, int64, error) {
+	header := make([]byte, len(logMagic)+2)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return logHeader{}, 0, err
+	}
+
+	if tornHeader(header[:n]) {
+		// Nothing was logged after the header, so the file is an empty log
+		return logHeader{version: currentFormatVersion, codec: ProtoCodec}, int64(n), nil
+	}
+	if n < len(logMagic)+1 || string(header[:len(logMagic)]) != logMagic {
+		return logHeader{version: legacyFormatVersion, codec: ProtoCodec}, 0, nil
+	}
+
+	version := header[len(logMagic)]
+	switch {
+	case version > currentFormatVersion:
+		return logHeader{}, 0, fmt.Errorf("%w %d", ErrUnsupportedFormat, version)
+	case version < codecFormatVersion:
+		return logHeader{version: version, codec: ProtoCodec}, int64(len(logMagic) + 1), nil
+	case n < len(header):
+		return logHeader{}, 0, fmt.Errorf("truncated log header: %w", io.ErrUnexpectedEOF)
+	}
+
+	codec, err := codecByID(header[len(logMagic)+1])
+	if err != nil {
+		return logHeader{}, 0, err
+	}
+
+	return logHeader{version: version, codec: codec}, int64(len(header)), nil
+}
+
+// recordEncoder encodes and decodes log records with the codec, compression
+// and encryption options a Database or TieredStore was created with.
+type recordEncoder struct {
+	// codec encodes the records of newly created log files
+	codec           Codec
+	compression     Compression
+	compressMinSize int
+	// aead encrypts record payloads when encryption at rest is on
+	aead cipher.AEAD
+}
+
+// encodeLogEntry encodes entry as a framed record of a log with the given
+// header.
+func (e *recordEncoder) encodeLogEntry(header logHeader, entry *contract.LogEntry) ([]byte, error) {
+	payload, err := e.encodeEntry(header, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeRecord(header.version, payload), nil
+}
+
+// encodeEntry encodes entry as the payload of a record of a log with the given
+// header.
+func (e *recordEncoder) encodeEntry(header logHeader, entry *contract.LogEntry) ([]byte, error) {
+	payload, err := header.codec.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.version >= flagsFormatVersion {
+		payload, err = e.encodePayload(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+// decodeLogEntry decodes the payload of a record read from a log with the
+// given header.
+func (e *recordEncoder) decodeLogEntry(header logHeader, payload []byte, entry *contract.LogEntry) error {
+	if header.version >= flagsFormatVersion {
+		var err error
+		payload, err = e.decodePayload(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return header.codec.Unmarshal(payload, entry)
+}
+
+// currentHeader describes the format records are written in.
+func (e *recordEncoder) currentHeader() logHeader {
+	return logHeader{version: currentFormatVersion, codec: e.codec}
+}
+
+// reencode converts the payload of a record of a log with the given header to
+// the current format.
+func (e *recordEncoder) reencode(header logHeader, payload []byte) ([]byte, error) {
+	entry := &contract.LogEntry{}
+	err := e.decodeLogEntry(header, payload, entry)
+	if err != nil {
+		return nil, err
+	}
+	return e.encodeEntry(e.currentHeader(), entry)
+}
+
+// encodeRecord frames a payload for a log written in the given format version.
+func encodeRecord(version byte, payload []byte) []byte {
+	if version == legacyFormatVersion {
+		buf := make([]byte, 4, 4+len(payload))
+		binary.LittleEndian.PutUint32(buf, uint32(len(payload)))
+		return append(buf, payload...)
+	}
+
+	prefixSize := recordPrefixSize(version)
+	buf := make([]byte, prefixSize, prefixSize+len(payload))
+	binary.LittleEndian.PutUint32(buf, uint32(len(payload)))
+	if version >= lengthFormatVersion {
+		binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(buf[:4], crcTable))
+	}
+	binary.LittleEndian.PutUint32(buf[prefixSize-4:], crc32.Checksum(payload, crcTable))
+	return append(buf, payload...)
+}
+
+// recordPrefixSize returns the number of bytes framing each record payload in a
+// log written in the given format version.
+func recordPrefixSize(version byte) int {
+	switch {
+	case version == legacyFormatVersion:
+		return 4
+	case version < lengthFormatVersion:
+		return 8
+	default:
+		return 12
+	}
+}
+
+// recordLength returns the payload length held by the prefix of a record,
+// failing with ErrCorruptRecord if it doesn't match its checksum.
+func recordLength(prefix []byte, version byte) (int64, error) {
+	if version >= lengthFormatVersion && crc32.Checksum(prefix[:4], crcTable) != binary.LittleEndian.Uint32(prefix[4:]) {
+		return 0, ErrCorruptRecord
+	}
+	return int64(binary.LittleEndian.Uint32(prefix)), nil
+}
+
+// intactPayload reports whether payload matches the checksum in the prefix of
+// its record. Legacy records have none, they always do.
+func intactPayload(prefix, payload []byte, version byte) bool {
+	if version == legacyFormatVersion {
+		return true
+	}
+	return crc32.Checksum(payload, crcTable) == binary.LittleEndian.Uint32(prefix[len(prefix)-4:])
+}
+
+// readRecord reads the record starting at offset of a log whose first size
+// bytes can be read, and returns its payload along with the offset of the next
+// record. A checksum failure is reported as ErrCorruptRecord together with the
+// offset of the next intact record, size if there's none, so callers can
+// choose to skip it. A record running past size, or whose length fails its
+// checksum, is reported as io.EOF, a partially written last record, when its
+// length matches its checksum or nothing intact follows the record's start.
+// Otherwise the length is what's corrupt, and it's an ErrCorruptRecord too.
+// Legacy records have no checksum at all, so one running past size is always
+// io.EOF.
+func readRecord(r io.ReaderAt, offset, size int64, version byte) ([]byte, int64, error) {
+	prefixSize := int64(recordPrefixSize(version))
+	if offset+prefixSize > size {
+		return nil, offset, io.EOF
+	}
+
+	// reading the length (and checksums) of the encoded item before reading each item
+	buf := make([]byte, prefixSize)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return nil, offset, err
+	}
+
+	// Check the length before trusting it, a corrupt one could otherwise
+	// have us allocate gigabytes or take the rest of the log for a torn tail
+	itemSize, err := recordLength(buf, version)
+	if err != nil || offset+prefixSize+itemSize > size {
+		if err == nil && (version == legacyFormatVersion || version >= lengthFormatVersion) {
+			// The length is all there is to go by, or checks out
+			return nil, offset, io.EOF
+		}
+		next, err := nextIntactRecord(r, offset, size, version)
+		if err != nil {
+			return nil, offset, err
+		}
+		if next == size {
+			return nil, offset, io.EOF
+		}
+		return nil, next, ErrCorruptRecord
+	}
+
+	// reading the actual encoded item
+	item := make([]byte, itemSize)
+	if _, err := r.ReadAt(item, offset+prefixSize); err != nil {
+		return nil, offset, err
+	}
+	next := offset + prefixSize + itemSize
+
+	if !intactPayload(buf, item, version) {
+		if version < lengthFormatVersion {
+			// The length may be what's corrupt, so the next record isn't
+			// necessarily where it says
+			next, err = nextIntactRecord(r, offset, size, version)
+			if err != nil {
+				return nil, offset, err
+			}
+		}
+		return nil, next, ErrCorruptRecord
+	}
+
+	return item, next, nil
+}
+
+// nextIntactRecord returns the offset of the first record after offset whose
+// prefix and payload match their checksums and fit in size, size if there's
+// none. It reads the rest of the log, so it's only meant for recovering from
+// a corrupt record, not for a log in good shape.
+func nextIntactRecord(r io.ReaderAt, offset, size int64, version byte) (int64, error) {
+	rest := make([]byte, size-offset)
+	if _, err := r.ReadAt(rest, offset); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	prefixSize := recordPrefixSize(version)
+	for i := 1; i+prefixSize <= len(rest); i++ {
+		prefix := rest[i : i+prefixSize]
+		itemSize, err := recordLength(prefix, version)
+		// Every record holds at least a byte, a run of zeroes doesn't
+		// frame an empty one
+		if err != nil || itemSize == 0 || itemSize > int64(len(rest)-i-prefixSize) {
+			continue
+		}
+		if intactPayload(prefix, rest[i+prefixSize:i+prefixSize+int(itemSize)], version) {
+			return offset + int64(i), nil
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// PartialReplay describes where ReplayWriteAheadLogWithTimeout stopped.
+type PartialReplay struct {
+	// Segment is the log file replay stopped in and Offset the offset in it
+	// of the first record left unread. Records of a batch whose commit comes
+	// after it are read but not applied.
+	Segment string
+	Offset  int64
+	// RemainingBytes is the size of the log left unread. RemainingRecords is
+	// an estimate of the records it holds, from the average size of the
+	// records replayed, since counting them would mean reading them.
+	RemainingBytes   int64
+	RemainingRecords int
+}
+
+// replayDeadlineError stops a replay at its deadline, at offset of the log
+// file at path, which is size bytes long.
+type replayDeadlineError struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+func (e *replayDeadlineError) Error() string {
+	return fmt.Sprintf("replay deadline reached at offset %d of %s", e.offset, e.path)
+}
+
+// ReplayWriteAheadLogWithTimeout is ReplayWriteAheadLog giving up once d has
+// passed, say on a disk so slow replay would never finish. If it runs out of
+// time it returns a nil error along with the stats of the records applied so
+// far and where it stopped, and the database serves reads from the keyspace
+// loaded up to there. Replay checks the time between records, so a read the
+// disk never returns from still holds it up.
+//
+// Writes fail with ErrPartialReplay until AcceptPartialReplay lets them
+// through, and Compact and Checkpoint, which would replace the log with the
+// partial keyspace, fail with it until a replay reads the whole log. Calling
+// ReplayWriteAheadLog or ReplayWriteAheadLogWithTimeout again starts over from
+// an empty keyspace. It needs the log files and returns ErrNotSupported with
+// another LogStore or with shard logs.
+func (db *Database) ReplayWriteAheadLogWithTimeout(d time.Duration) (ReplayStats, *PartialReplay, error) {
+	if db.shardLogs {
+		return ReplayStats{}, nil, ErrNotSupported
+	}
+	if _, ok := db.store.(*FileLogStore); db.store != nil && !ok {
+		return ReplayStats{}, nil, ErrNotSupported
+	}
+
+	stats, stopped, err := db.replayWriteAheadLog(nil, time.Now().Add(d))
+	if err != nil || stopped == nil {
+		return stats, nil, err
+	}
+
+	partial := &PartialReplay{
+		Segment:        stopped.path,
+		Offset:         stopped.offset,
+		RemainingBytes: stopped.size - stopped.offset,
+	}
+	db.compactLock.Lock()
+	err = db.withLogFiles(func(segments []string) error {
+		after := false
+		for _, segment := range segments {
+			if after {
+				info, err := os.Stat(segment)
+				if err != nil {
+					return err
+				}
+				partial.RemainingBytes += info.Size()
+			}
+			after = after || segment == stopped.path
+		}
+		return nil
+	})
+	db.compactLock.Unlock()
+	if err != nil {
+		return stats, partial, err
+	}
+	if stats.Records > 0 {
+		partial.RemainingRecords = int(partial.RemainingBytes * int64(stats.Records) / stats.Bytes)
+	}
+	return stats, partial, nil
+}
+
+// AcceptPartialReplay lets writes through after ReplayWriteAheadLogWithTimeout
+// ran out of time, on top of the keyspace it loaded. They're logged after the
+// records it didn't read, so the next full replay applies them after those,
+// and may end up with different values than the writes saw: an Increment of a
+// key that wasn't read yet counts from zero now, but from the unread value
+// then. Compact and Checkpoint keep failing with ErrPartialReplay.
+func (db *Database) AcceptPartialReplay() error {
+	if !db.partialReplay.Load() {
+		return nil
+	}
+
+	// Whether the unread records end in a batch a crash kept from committing
+	// is unknown, close it in case, like OpenLogFile does
+	db.logFileLock.Lock()
+	db.openBatch = !db.readOnly
+	db.logFileLock.Unlock()
+	err := db.closeOpenBatch()
+	if err != nil {
+		return err
+	}
+
+	db.partialAccepted.Store(true)
+	db.logger.Sugar().Warnf("Accepting writes on top of a partial replay")
+	return nil
+}
@@ -0,0 +1,817 @@
+package store
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"personalMonorepo/distributedDataStore/contract"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ErrReplicationTimeout is returned by writes that were applied locally but
+// weren't acknowledged by enough followers in time.
+var ErrReplicationTimeout = errors.New("timed out waiting for followers")
+
+// ErrTooStale is returned by follower reads when the follower may be further
+// behind the leader than the read allows.
+var ErrTooStale = errors.New("follower is too stale")
+
+// ErrFrameTooLarge is returned when a replication peer sends, or a leader would
+// send, a frame over maxFrameSize. The connection is dropped.
+var ErrFrameTooLarge = errors.New("replication frame too large")
+
+// maxFrameKeyValue bounds the key and value a replication frame carries
+// between them, and maxFrameSize the whole frame, leaving room for its run id,
+// sequence numbers and metadata. A record bigger than that can't be
+// replicated.
+const (
+	maxFrameKeyValue = 64 << 20
+	maxFrameSize     = maxFrameKeyValue + 1<<20
+)
+
+// replicationHeartbeatInterval is how often an idle leader tells its
+// followers they're caught up, which bounds how fresh a follower read on an
+// idle leader can be.
+const replicationHeartbeatInterval = 100 * time.Millisecond
+
+// ReplicationLeader streams every record written to a Database to the
+// followers connected to it.
+//
+// Each record gets a sequence number, and the most recent records are kept in
+// a bounded backlog. A follower reconnecting with an offset still covered by
+// the backlog catches up from there, anything else gets a full sync: a
+// snapshot of the current state followed by the live stream. Records are
+// sent one per frame, so one whose key and value add up to more than 64MiB
+// can't be replicated.
+type ReplicationLeader struct {
+	db       *Database
+	runID    string
+	listener net.Listener
+
+	mu sync.Mutex
+	// cond is broadcast when records are appended, acks arrive or the leader
+	// is closed
+	cond        *sync.Cond
+	backlog     []replicatedRecord
+	backlogSize int
+	lastSeq     uint64
+	// bytes is the total encoded size of the records appended so far
+	bytes  int64
+	acked  map[net.Conn]uint64
+	closed bool
+	// position maps every streaming follower to the last record it had when
+	// its stream started, which counts as acknowledged for its lag
+	position map[net.Conn]uint64
+	// beats counts heartbeat ticks, serve sends a heartbeat to an idle
+	// follower on each one
+	beats uint64
+
+	requiredAcks int
+	ackTimeout   time.Duration
+
+	// lagPolicy, maxLagRecords, maxLagBytes and lagTimeout bound the lag of
+	// followers, see LimitLag
+	lagPolicy     LagPolicy
+	maxLagRecords uint64
+	maxLagBytes   int64
+	lagTimeout    time.Duration
+}
+
+// replicatedRecord is a record of the backlog. start is the total encoded size
+// of the records before it.
+type replicatedRecord struct {
+	seq   uint64
+	entry *contract.LogEntry
+	start int64
+}
+
+// defaultReplicationBacklog is the backlog size used when none is given.
+const defaultReplicationBacklog = 10000
+
+// ServeReplication makes db a replication leader accepting followers on addr.
+// backlogSize bounds how many recent records are kept for followers catching
+// up after a disconnect, zero picks a default.
+func ServeReplication(db *Database, addr string, backlogSize int) (*ReplicationLeader, error) {
+	if backlogSize <= 0 {
+		backlogSize = defaultReplicationBacklog
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	runID := make([]byte, 8)
+	_, err = rand.Read(runID)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	leader := &ReplicationLeader{
+		db:          db,
+		runID:       hex.EncodeToString(runID),
+		listener:    listener,
+		backlogSize: backlogSize,
+		acked:       make(map[net.Conn]uint64),
+		position:    make(map[net.Conn]uint64),
+	}
+	leader.cond = sync.NewCond(&leader.mu)
+
+	db.logFileLock.Lock()
+	db.leader = leader
+	db.logFileLock.Unlock()
+
+	go leader.accept()
+	go leader.heartbeat()
+
+	db.logger.Sugar().Infof("Serving replication on %s", listener.Addr())
+	return leader, nil
+}
+
+// Addr returns the address followers connect to.
+func (l *ReplicationLeader) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// RequireAcks makes every write, from Set and Delete to batches, transactions
+// and bulk loads, wait until n followers applied it, or fail with
+// ErrReplicationTimeout after timeout. Zero disables waiting.
+func (l *ReplicationLeader) RequireAcks(n int, timeout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.requiredAcks = n
+	l.ackTimeout = timeout
+}
+
+// Close stops accepting followers and disconnects the current ones.
+func (l *ReplicationLeader) Close() error {
+	db := l.db
+	db.logFileLock.Lock()
+	if db.leader == l {
+		db.leader = nil
+	}
+	db.logFileLock.Unlock()
+
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.acked {
+		_ = conn.Close()
+	}
+	l.cond.Broadcast()
+	l.mu.Unlock()
+
+	return l.listener.Close()
+}
+
+// append adds freshly written records to the backlog. It's called with
+// logFileLock held, so records are sequenced in log order.
+func (l *ReplicationLeader) append(entries []*contract.LogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range entries {
+		l.lastSeq++
+		l.backlog = append(l.backlog, replicatedRecord{seq: l.lastSeq, entry: entry, start: l.bytes})
+		l.bytes += int64(proto.Size(entry))
+	}
+	if len(l.backlog) > l.backlogSize {
+		l.backlog = append(l.backlog[:0:0], l.backlog[len(l.backlog)-l.backlogSize:]...)
+	}
+	l.cond.Broadcast()
+}
+
+// replicationPosition returns the replication leader, if any, and the sequence
+// number of the last record handed to it.
+func (db *Database) replicationPosition() (*ReplicationLeader, uint64) {
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.leader == nil {
+		return nil, 0
+	}
+	return db.leader, db.leader.sequence()
+}
+
+// sequence returns the sequence number of the last record appended.
+func (l *ReplicationLeader) sequence() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastSeq
+}
+
+// awaitAcks blocks until enough followers acknowledged seq, and under LagBlock
+// until no follower lags too far behind. It's a no-op on a nil leader.
+func (l *ReplicationLeader) awaitAcks(seq uint64) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.awaitLag()
+	if l.requiredAcks == 0 {
+		return nil
+	}
+
+	timedOut := false
+	timer := time.AfterFunc(l.ackTimeout, func() {
+		l.mu.Lock()
+		timedOut = true
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	for {
+		acks := 0
+		for _, acked := range l.acked {
+			if acked >= seq {
+				acks++
+			}
+		}
+		if acks >= l.requiredAcks {
+			return nil
+		}
+		if timedOut || l.closed {
+			return ErrReplicationTimeout
+		}
+		l.cond.Wait()
+	}
+}
+
+func (l *ReplicationLeader) accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.serve(conn)
+	}
+}
+
+// serve streams records to a single follower until it disconnects.
+func (l *ReplicationLeader) serve(conn net.Conn) {
+	sugar := l.db.logger.Sugar()
+	defer func() {
+		l.mu.Lock()
+		delete(l.acked, conn)
+		if _, ok := l.position[conn]; ok {
+			delete(l.position, conn)
+			replicationLagRecords.DeleteLabelValues(conn.RemoteAddr().String())
+			replicationLagBytes.DeleteLabelValues(conn.RemoteAddr().String())
+		}
+		l.cond.Broadcast()
+		l.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	req := &contract.FollowRequest{}
+	err := readFrame(reader, req)
+	if err != nil {
+		sugar.Warnf("Bad follow request from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.acked[conn] = 0
+	l.mu.Unlock()
+
+	go l.readAcks(conn, reader)
+
+	writer := bufio.NewWriter(conn)
+	next, ok := l.resumeFrom(req)
+	if !ok {
+		next, err = l.fullSync(writer)
+		if err != nil {
+			sugar.Warnf("Full sync of follower %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+	sugar.Infof("Follower %s streaming from record %d", conn.RemoteAddr(), next)
+
+	l.mu.Lock()
+	if _, ok := l.acked[conn]; !ok {
+		// Dropped while syncing
+		l.mu.Unlock()
+		return
+	}
+	l.position[conn] = next - 1
+	beat := l.beats
+	l.mu.Unlock()
+
+	for {
+		l.mu.Lock()
+		for !l.closed && l.lastSeq < next && l.beats == beat {
+			l.cond.Wait()
+		}
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		beat = l.beats
+		leaderSeq := l.lastSeq
+		if leaderSeq < next {
+			// Nothing new to send, let the follower know it has everything
+			l.mu.Unlock()
+			err = writeFrame(writer, &contract.ReplicationFrame{
+				Kind:      contract.ReplicationFrame_HEARTBEAT,
+				RunId:     l.runID,
+				Seq:       leaderSeq,
+				LeaderSeq: leaderSeq,
+			})
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if len(l.backlog) == 0 || l.backlog[0].seq > next {
+			// The follower fell out of the backlog, it has to reconnect and
+			// take a full sync
+			l.mu.Unlock()
+			sugar.Warnf("Follower %s fell behind the replication backlog", conn.RemoteAddr())
+			return
+		}
+		records := append([]replicatedRecord(nil), l.backlog[next-l.backlog[0].seq:]...)
+		l.mu.Unlock()
+
+		for _, record := range records {
+			err = writeFrame(writer, &contract.ReplicationFrame{
+				Kind:      contract.ReplicationFrame_RECORD,
+				RunId:     l.runID,
+				Seq:       record.seq,
+				Entry:     record.entry,
+				LeaderSeq: leaderSeq,
+			})
+			if errors.Is(err, ErrFrameTooLarge) {
+				sugar.Errorf("Can't replicate record %d to %s: %v", record.seq, conn.RemoteAddr(), err)
+			}
+			if err != nil {
+				return
+			}
+		}
+		err = writer.Flush()
+		if err != nil {
+			return
+		}
+		next = records[len(records)-1].seq + 1
+	}
+}
+
+// resumeFrom returns the next record to send a follower that asks to resume,
+// or false if it needs a full sync instead.
+func (l *ReplicationLeader) resumeFrom(req *contract.FollowRequest) (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if req.RunId != l.runID || req.Offset > l.lastSeq {
+		return 0, false
+	}
+	if l.tooFar(l.lagFrom(req.Offset)) {
+		// Catching up would leave it past the bound, so would a drop for
+		// lagging
+		return 0, false
+	}
+	if req.Offset == l.lastSeq {
+		return req.Offset + 1, true
+	}
+	if len(l.backlog) == 0 || l.backlog[0].seq > req.Offset+1 {
+		return 0, false
+	}
+	return req.Offset + 1, true
+}
+
+// fullSync sends a snapshot of the database and returns the sequence number
+// the live stream continues from.
+func (l *ReplicationLeader) fullSync(writer *bufio.Writer) (uint64, error) {
+	db := l.db
+
+	// Records are appended to the backlog under the write lock of their
+	// shard, so read locking every shard pins the snapshot to a sequence number
+	rLockShards(db.shards)
+	entries := db.liveEntries()
+	seq := l.sequence()
+	rUnlockShards(db.shards)
+
+	err := writeFrame(writer, &contract.ReplicationFrame{
+		Kind:  contract.ReplicationFrame_RESET,
+		RunId: l.runID,
+		Seq:   seq,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		err = writeFrame(writer, &contract.ReplicationFrame{
+			Kind:  contract.ReplicationFrame_RECORD,
+			RunId: l.runID,
+			Entry: entry,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = writeFrame(writer, &contract.ReplicationFrame{
+		Kind:      contract.ReplicationFrame_SYNCED,
+		RunId:     l.runID,
+		Seq:       seq,
+		LeaderSeq: seq,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return seq + 1, writer.Flush()
+}
+
+// heartbeat wakes the streams of idle followers every
+// replicationHeartbeatInterval until the leader is closed.
+func (l *ReplicationLeader) heartbeat() {
+	ticker := time.NewTicker(replicationHeartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		l.beats++
+		l.checkLag()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}
+}
+
+func (l *ReplicationLeader) readAcks(conn net.Conn, reader *bufio.Reader) {
+	for {
+		ack := &contract.ReplicationAck{}
+		err := readFrame(reader, ack)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+
+		l.mu.Lock()
+		if _, ok := l.acked[conn]; ok {
+			l.acked[conn] = ack.Seq
+		}
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}
+}
+
+// ReplicationFollower keeps a Database in sync with a ReplicationLeader,
+// reconnecting and catching up whenever the connection drops.
+type ReplicationFollower struct {
+	db         *Database
+	leaderAddr string
+	done       chan struct{}
+
+	mu     sync.Mutex
+	conn   net.Conn
+	runID  string
+	offset uint64
+	// caughtUpAt is when the follower last applied every record the leader
+	// had written, zero until it first catches up
+	caughtUpAt time.Time
+}
+
+// FollowLeader starts replicating into db from the leader at leaderAddr.
+func FollowLeader(db *Database, leaderAddr string) *ReplicationFollower {
+	follower := &ReplicationFollower{
+		db:         db,
+		leaderAddr: leaderAddr,
+		done:       make(chan struct{}),
+	}
+
+	go follower.run()
+
+	return follower
+}
+
+// Offset returns the sequence number of the last record applied from the
+// leader.
+func (f *ReplicationFollower) Offset() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.offset
+}
+
+// Staleness returns how far behind the leader the follower may be, the time
+// since it last had every record the leader had written. It's false until the
+// follower first caught up. Staleness is measured on the follower's clock from
+// when it received the leader's position, so network delay isn't included.
+func (f *ReplicationFollower) Staleness() (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.caughtUpAt.IsZero() {
+		return 0, false
+	}
+	return f.db.clock.Now().Sub(f.caughtUpAt), true
+}
+
+// GetWithMaxStaleness reads key from the follower's database if the follower
+// caught up with the leader within maxStaleness, and returns the staleness of
+// the read along with the value. A follower further behind fails with
+// ErrTooStale, so the caller can read from the leader instead.
+func (f *ReplicationFollower) GetWithMaxStaleness(key string, maxStaleness time.Duration) ([]byte, time.Duration, error) {
+	staleness, ok := f.Staleness()
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: never caught up with %s", ErrTooStale, f.leaderAddr)
+	}
+	if staleness > maxStaleness {
+		return nil, staleness, fmt.Errorf("%w: %s behind %s", ErrTooStale, staleness, f.leaderAddr)
+	}
+
+	value, err := f.db.Get(key)
+	return value, staleness, err
+}
+
+// Close stops following the leader.
+func (f *ReplicationFollower) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return nil
+	default:
+	}
+
+	close(f.done)
+	if f.conn != nil {
+		return f.conn.Close()
+	}
+	return nil
+}
+
+func (f *ReplicationFollower) run() {
+	sugar := f.db.logger.Sugar()
+
+	for {
+		err := f.follow()
+		select {
+		case <-f.done:
+			return
+		default:
+		}
+
+		sugar.Warnf("Lost replication stream from %s, reconnecting: %v", f.leaderAddr, err)
+		select {
+		case <-f.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// follow runs a single replication session until the connection fails.
+func (f *ReplicationFollower) follow() error {
+	conn, err := net.Dial("tcp", f.leaderAddr)
+	if err != nil {
+		return err
+	}
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+
+	f.mu.Lock()
+	select {
+	case <-f.done:
+		f.mu.Unlock()
+		return nil
+	default:
+	}
+	f.conn = conn
+	req := &contract.FollowRequest{RunId: f.runID, Offset: f.offset}
+	f.mu.Unlock()
+
+	writer := bufio.NewWriter(conn)
+	err = writeFrame(writer, req)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	var snapshot, batch []*contract.LogEntry
+	syncing, inBatch := false, false
+
+	for {
+		frame := &contract.ReplicationFrame{}
+		err = readFrame(reader, frame)
+		if err != nil {
+			return err
+		}
+
+		switch frame.Kind {
+		case contract.ReplicationFrame_RESET:
+			snapshot = snapshot[:0]
+			syncing = true
+			continue
+		case contract.ReplicationFrame_HEARTBEAT:
+			f.mu.Lock()
+			if !syncing && !inBatch && f.offset >= frame.LeaderSeq {
+				f.caughtUpAt = f.db.clock.Now()
+			}
+			f.mu.Unlock()
+			continue
+		case contract.ReplicationFrame_SYNCED:
+			err = f.db.replaceState(snapshot)
+			if err != nil {
+				return err
+			}
+			snapshot = nil
+			syncing = false
+		case contract.ReplicationFrame_RECORD:
+			if syncing {
+				snapshot = append(snapshot, frame.Entry)
+				continue
+			}
+
+			// Apply batches as a unit, the same way replay does
+			switch frame.Entry.Op {
+			case BATCH_BEGIN:
+				batch = append(batch[:0], frame.Entry)
+				inBatch = true
+			case BATCH_COMMIT:
+				err = f.db.applyReplicated(append(batch, frame.Entry)...)
+				batch = batch[:0]
+				inBatch = false
+			default:
+				if inBatch {
+					batch = append(batch, frame.Entry)
+				} else {
+					err = f.db.applyReplicated(frame.Entry)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if inBatch {
+				continue
+			}
+		}
+
+		f.mu.Lock()
+		f.runID = frame.RunId
+		f.offset = frame.Seq
+		if frame.Seq >= frame.LeaderSeq {
+			f.caughtUpAt = f.db.clock.Now()
+		}
+		f.mu.Unlock()
+
+		err = writeFrame(writer, &contract.ReplicationAck{Seq: frame.Seq})
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// applyReplicated writes records received from the leader to the local log and
+// applies them.
+func (db *Database) applyReplicated(entries ...*contract.LogEntry) error {
+	// The leader's records can't be turned away, they wait out a freeze
+	db.freezeLock.RLock()
+	defer db.freezeLock.RUnlock()
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	shards := db.shardsOf(keys)
+	lockShards(shards)
+	defer unlockShards(shards)
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	err := db.writeLogEntries(entries...)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Op == BATCH_BEGIN || entry.Op == BATCH_COMMIT {
+			continue
+		}
+		err = db.applyLogEntry(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceState atomically swaps the contents of the database for entries,
+// logging the difference as a single batch.
+func (db *Database) replaceState(entries []*contract.LogEntry) error {
+	db.freezeLock.RLock()
+	defer db.freezeLock.RUnlock()
+
+	lockShards(db.shards)
+	defer unlockShards(db.shards)
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	keep := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		keep[entry.Key] = true
+	}
+
+	logEntries := []*contract.LogEntry{{Op: BATCH_BEGIN}}
+	for _, s := range db.shards {
+		for _, key := range s.keys {
+			if !keep[key] {
+				logEntries = append(logEntries, &contract.LogEntry{Op: DELETE, Key: key})
+			}
+		}
+	}
+	logEntries = append(logEntries, entries...)
+	logEntries = append(logEntries, &contract.LogEntry{Op: BATCH_COMMIT})
+
+	err := db.writeLogEntries(logEntries...)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range logEntries[1 : len(logEntries)-1] {
+		err = db.applyLogEntry(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes a length-prefixed proto message to w.
+func writeFrame(w io.Writer, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxFrameSize {
+		// The peer would drop the connection on it
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrFrameTooLarge, len(data), maxFrameSize)
+	}
+
+	buf := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	_, err = w.Write(append(buf, data...))
+	return err
+}
+
+// readFrame reads a length-prefixed proto message written by writeFrame. A
+// length over maxFrameSize fails with ErrFrameTooLarge before anything is
+// allocated for it, callers drop the connection on any error.
+func readFrame(r io.Reader, msg proto.Message) error {
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return err
+	}
+
+	size := binary.LittleEndian.Uint32(buf)
+	if size > maxFrameSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrFrameTooLarge, size, maxFrameSize)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(data, msg)
+}
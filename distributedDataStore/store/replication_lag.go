@@ -0,0 +1,171 @@
+package store
+
+import (
+	"net"
+	"time"
+)
+
+// LagPolicy decides what the leader does about a follower lagging further
+// behind than LimitLag allows.
+type LagPolicy int
+
+const (
+	// LagDrop disconnects the follower, which then takes a full sync when it
+	// reconnects. Writes never wait for it.
+	LagDrop LagPolicy = iota
+	// LagBlock makes writes wait for the follower to catch up, and drops it
+	// like LagDrop if it hasn't by the timeout.
+	LagBlock
+)
+
+// FollowerLag is how far behind the leader a follower is, see
+// ReplicationLeader.Lag.
+type FollowerLag struct {
+	// Addr is the address the follower connected from
+	Addr string
+	// Records is the number of records the follower hasn't acknowledged yet,
+	// and Bytes their encoded size, counted from the first record the
+	// backlog still holds if the follower is further behind than that
+	Records uint64
+	Bytes   int64
+}
+
+// LimitLag bounds how far behind a follower may lag, by records, by bytes of
+// encoded records, or both, zero leaving either out. A follower is behind by
+// the records it hasn't acknowledged yet, the snapshot of a full sync counting
+// as acknowledged. The policy picks between dropping a follower past the
+// bound, checked on every heartbeat, and making Set and Delete wait until it's
+// back under it, for up to timeout before it's dropped. A dropped follower, and
+// any follower asking to resume from further back than the bound, takes a full
+// sync, so a slow follower never holds up the leader for long and is never
+// left behind unnoticed.
+func (l *ReplicationLeader) LimitLag(policy LagPolicy, maxRecords uint64, maxBytes int64, timeout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lagPolicy = policy
+	l.maxLagRecords = maxRecords
+	l.maxLagBytes = maxBytes
+	l.lagTimeout = timeout
+	l.cond.Broadcast()
+}
+
+// Lag returns how far behind the leader every connected follower is.
+func (l *ReplicationLeader) Lag() []FollowerLag {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lags := make([]FollowerLag, 0, len(l.position))
+	for conn := range l.position {
+		records, bytes := l.lag(conn)
+		lags = append(lags, FollowerLag{Addr: conn.RemoteAddr().String(), Records: records, Bytes: bytes})
+	}
+	return lags
+}
+
+// lag returns how many records, and how many bytes of records, the follower
+// on conn is behind. Callers must hold l.mu.
+func (l *ReplicationLeader) lag(conn net.Conn) (uint64, int64) {
+	position := l.position[conn]
+	if acked := l.acked[conn]; acked > position {
+		position = acked
+	}
+	return l.lagFrom(position)
+}
+
+// lagFrom returns how far behind a follower having every record up to seq is.
+// Callers must hold l.mu.
+func (l *ReplicationLeader) lagFrom(seq uint64) (uint64, int64) {
+	if seq >= l.lastSeq || len(l.backlog) == 0 {
+		return 0, 0
+	}
+
+	first := l.backlog[0].seq
+	start := l.backlog[0].start
+	if seq+1 > first {
+		start = l.backlog[seq+1-first].start
+	}
+	return l.lastSeq - seq, l.bytes - start
+}
+
+// tooFar reports whether a follower the given number of records and bytes
+// behind is past the bound set with LimitLag. Callers must hold l.mu.
+func (l *ReplicationLeader) tooFar(records uint64, bytes int64) bool {
+	return l.maxLagRecords > 0 && records > l.maxLagRecords ||
+		l.maxLagBytes > 0 && bytes > l.maxLagBytes
+}
+
+// laggards returns the followers past the bound set with LimitLag. Callers
+// must hold l.mu.
+func (l *ReplicationLeader) laggards() []net.Conn {
+	var laggards []net.Conn
+	for conn := range l.position {
+		if l.tooFar(l.lag(conn)) {
+			laggards = append(laggards, conn)
+		}
+	}
+	return laggards
+}
+
+// drop disconnects a follower lagging too far behind. It no longer counts for
+// acks or lag from here on, its stream ends with the first write that fails.
+// Callers must hold l.mu.
+func (l *ReplicationLeader) drop(conn net.Conn) {
+	records, bytes := l.lag(conn)
+	l.db.logger.Sugar().Warnf("Dropping follower %s, %d records and %d bytes behind, it has to take a full sync",
+		conn.RemoteAddr(), records, bytes)
+	delete(l.acked, conn)
+	delete(l.position, conn)
+	replicationLagRecords.DeleteLabelValues(conn.RemoteAddr().String())
+	replicationLagBytes.DeleteLabelValues(conn.RemoteAddr().String())
+	_ = conn.Close()
+	l.cond.Broadcast()
+}
+
+// checkLag updates the lag metrics and, under LagDrop, drops the followers
+// past the bound. It runs on every heartbeat. Callers must hold l.mu.
+func (l *ReplicationLeader) checkLag() {
+	for conn := range l.position {
+		records, bytes := l.lag(conn)
+		replicationLagRecords.WithLabelValues(conn.RemoteAddr().String()).Set(float64(records))
+		replicationLagBytes.WithLabelValues(conn.RemoteAddr().String()).Set(float64(bytes))
+	}
+	if l.lagPolicy != LagDrop {
+		return
+	}
+	for _, conn := range l.laggards() {
+		l.drop(conn)
+	}
+}
+
+// awaitLag holds up a write under LagBlock while a follower is past the bound
+// set with LimitLag, for up to the timeout, and then drops the followers still
+// past it. Callers must hold l.mu.
+func (l *ReplicationLeader) awaitLag() {
+	if l.lagPolicy != LagBlock || len(l.laggards()) == 0 {
+		return
+	}
+
+	timedOut := false
+	timer := time.AfterFunc(l.lagTimeout, func() {
+		l.mu.Lock()
+		timedOut = true
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	for {
+		laggards := l.laggards()
+		if len(laggards) == 0 || l.closed || l.lagPolicy != LagBlock {
+			return
+		}
+		if timedOut {
+			for _, conn := range laggards {
+				l.drop(conn)
+			}
+			return
+		}
+		l.cond.Wait()
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"personalMonorepo/distributedDataStore/contract"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds, failing the test if it doesn't within
+// timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sameContents reports whether a and b hold the same keys and values.
+func sameContents(a, b *Database) bool {
+	want := make(map[string]string)
+	a.ForEach(func(key string, value []byte) bool {
+		want[key] = string(value)
+		return true
+	})
+	same := true
+	n := 0
+	b.ForEach(func(key string, value []byte) bool {
+		n++
+		got, ok := want[key]
+		same = ok && got == string(value)
+		return same
+	})
+	return same && n == len(want)
+}
+
+func TestLaggingFollowerIsDroppedAndResyncs(t *testing.T) {
+	db := NewMemoryDatabase()
+	leader, err := ServeReplication(db, "127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+	const maxLag = 10
+	leader.LimitLag(LagDrop, maxLag, 0, 0)
+
+	replica := NewMemoryDatabase()
+	follower := FollowLeader(replica, leader.Addr().String())
+	defer follower.Close()
+	mustSet(t, db, "key-0", "value")
+	waitFor(t, 5*time.Second, "the follower to catch up", func() bool { return sameContents(db, replica) })
+
+	// A frozen follower can't apply, and so can't acknowledge, anything
+	err = replica.Freeze(FreezeBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5*maxLag; i++ {
+		mustSet(t, db, fmt.Sprintf("key-%d", i), "value")
+	}
+	waitFor(t, 5*time.Second, "the follower to be dropped", func() bool { return len(leader.Lag()) == 0 })
+
+	// Writes go on without it, and it misses a delete too
+	mustDelete(t, db, "key-0")
+	mustSet(t, db, "after", "drop")
+	replica.Unfreeze()
+
+	// It's too far behind to resume, so it reconnects for a full sync,
+	// which takes away what the leader deleted meanwhile
+	waitFor(t, 10*time.Second, "the follower to resync", func() bool { return sameContents(db, replica) })
+	waitFor(t, 5*time.Second, "the follower to stream again", func() bool { return len(leader.Lag()) == 1 })
+	mustSet(t, db, "streamed", "value")
+	waitFor(t, 5*time.Second, "the follower to stream the next write", func() bool { return sameContents(db, replica) })
+}
+
+func TestLeaderDropsPeerSendingOversizedFrame(t *testing.T) {
+	db := NewMemoryDatabase()
+	leader, err := ServeReplication(db, "127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	conn, err := net.Dial("tcp", leader.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A follow request claiming to be almost 4GiB long
+	_, err = conn.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("read from the leader returned %v, want the connection closed", err)
+	}
+
+	err = readFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), &contract.FollowRequest{})
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("readFrame returned %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -0,0 +1,48 @@
+package store
+
+// Result is what a Set did to its key.
+type Result int
+
+const (
+	// Unchanged means the key already held the value and nothing was written
+	Unchanged Result = iota
+	// Inserted means the key was missing or expired and now holds the value
+	Inserted
+	// Updated means the key held another value, which was overwritten
+	Updated
+)
+
+func (r Result) String() string {
+	switch r {
+	case Unchanged:
+		return "unchanged"
+	case Inserted:
+		return "inserted"
+	case Updated:
+		return "updated"
+	default:
+		return "unknown"
+	}
+}
+
+// WithValueDedup sets whether a write of the value, TTL and metadata a key
+// already holds is skipped, as it is by default: Set returns Unchanged without
+// logging anything, and the key keeps its version and write time. Turning it
+// off saves comparing every value with the one it replaces, which adds up for
+// large values, and makes every write log a record and bump the version of
+// its key, so SetIfVersion and Txn see it as a change and watchers get an
+// event for it. Set then only returns Inserted or Updated. It applies to
+// Set, SetWithTTL, SetWithMeta, batches and Validate.
+func WithValueDedup(enabled bool) Option {
+	return func(db *Database) {
+		db.noDedup = !enabled
+	}
+}
+
+// resultOf returns the Result of logging a record with op.
+func resultOf(op uint32) Result {
+	if op == INSERT {
+		return Inserted
+	}
+	return Updated
+}
@@ -0,0 +1,157 @@
+package store
+
+import "os"
+
+// WithSegmentRetention bounds the rotated segments kept next to the log file
+// to maxSegments of them and maxTotalBytes of their size, zero leaving either
+// unbounded. After every rotation the oldest segments over the bounds are
+// removed in the background, but only once the checkpoint covers them, so no
+// record is removed that replay would still need. When the checkpoint doesn't
+// cover them yet, a new checkpoint is taken first.
+//
+// From then on recovery depends on the checkpoint: if it becomes unreadable,
+// replay falls back to the segments that are left. LogReaders still reading a
+// removed segment fail with ErrSegmentRemoved. It only applies to the
+// FileLogStore, and never to a read-only database.
+func WithSegmentRetention(maxSegments int, maxTotalBytes int64) Option {
+	return func(db *Database) {
+		db.retention = &segmentRetention{
+			maxSegments:   maxSegments,
+			maxTotalBytes: maxTotalBytes,
+			requests:      make(chan struct{}, 1),
+		}
+	}
+}
+
+// segmentRetention holds the bounds set with WithSegmentRetention.
+type segmentRetention struct {
+	maxSegments   int
+	maxTotalBytes int64
+	// requests wakes up the background goroutine enforcing the bounds
+	requests chan struct{}
+}
+
+// requestRetention asks for the retention bounds to be enforced, without
+// waiting for it. It's called after rotations, under logFileLock.
+func (db *Database) requestRetention() {
+	select {
+	case db.retention.requests <- struct{}{}:
+	default:
+		// A request is pending already
+	}
+}
+
+// retainSegments enforces the retention bounds whenever asked to. It stops
+// when the database is closed.
+func (db *Database) retainSegments() {
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-db.retention.requests:
+			err := db.enforceRetention()
+			if err != nil {
+				db.logger.Sugar().Errorf("Failed to remove segments past the retention limit: %v", err)
+			}
+		}
+	}
+}
+
+// enforceRetention removes the oldest segments past the retention bounds, after
+// taking a checkpoint if the current one doesn't cover them.
+func (db *Database) enforceRetention() error {
+	sugar := db.logger.Sugar()
+
+	// Replay and compaction read and remove segments under compactLock too
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	db.logFileLock.Lock()
+	_, ok := db.store.(*FileLogStore)
+	closed := db.closed
+	db.logFileLock.Unlock()
+	if closed || !ok {
+		return nil
+	}
+
+	sealed, excess, err := db.excessSegments()
+	if err != nil || excess == 0 {
+		return err
+	}
+
+	position, err := db.readCheckpoint(db.checkpointFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if position == nil || sealed[excess-1] > position.segment {
+		// Checkpointing seals the active file, so this adds a segment
+		// but covers all of them
+		err = db.checkpoint()
+		if err != nil {
+			return err
+		}
+		sealed, excess, err = db.excessSegments()
+		if err != nil || excess == 0 {
+			return err
+		}
+		position, err = db.readCheckpoint(db.checkpointFile())
+		if err != nil {
+			return err
+		}
+	}
+
+	removed := 0
+	for _, segment := range sealed[:excess] {
+		if segment > position.segment {
+			break
+		}
+		err = os.Remove(segment)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+	}
+
+	sugar.Infof("Removed %d segments past the retention limit, up to the checkpoint at %s", removed, position.segment)
+	return nil
+}
+
+// excessSegments returns the rotated segments of the log, oldest first, and
+// how many of the oldest would have to go to stay within the retention
+// bounds.
+func (db *Database) excessSegments() ([]string, int, error) {
+	segments, err := discoverSegments(db.logFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(segments) > 0 && segments[len(segments)-1] == db.logFile {
+		segments = segments[:len(segments)-1]
+	}
+
+	excess := 0
+	if db.retention.maxSegments > 0 && len(segments) > db.retention.maxSegments {
+		excess = len(segments) - db.retention.maxSegments
+	}
+
+	if db.retention.maxTotalBytes > 0 {
+		sizes := make([]int64, len(segments))
+		var total int64
+		for i, segment := range segments {
+			info, err := os.Stat(segment)
+			if err != nil {
+				return nil, 0, err
+			}
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+		for i := 0; i < excess; i++ {
+			total -= sizes[i]
+		}
+		for excess < len(segments) && total > db.retention.maxTotalBytes {
+			total -= sizes[excess]
+			excess++
+		}
+	}
+
+	return segments, excess, nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSegmentRetentionKeepsData(t *testing.T) {
+	padding := strings.Repeat("x", 1024)
+	tests := []struct {
+		name          string
+		maxSegments   int
+		maxTotalBytes int64
+	}{
+		{name: "max segments", maxSegments: 3},
+		{name: "max total bytes", maxTotalBytes: 25 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := openTestDatabase(t, dir, WithSyncMode(SyncOnCommit), WithSegmentRetention(tt.maxSegments, tt.maxTotalBytes))
+			want := make(map[string]string)
+			var first string
+			// Every round writes keys the later ones don't, so the
+			// oldest segments hold records nothing else does
+			for round := 0; round < 10; round++ {
+				for i := 0; i < 10; i++ {
+					key := fmt.Sprintf("key-%02d", round*5+i)
+					value := fmt.Sprintf("round-%d-%s", round, padding)
+					mustSet(t, db, key, value)
+					want[key] = value
+				}
+				key := fmt.Sprintf("key-%02d", round*5)
+				mustDelete(t, db, key)
+				delete(want, key)
+				rotateTestLog(t, db)
+				if round == 0 {
+					first = segmentNames(t, dir)[0]
+				}
+			}
+
+			// Retention runs in the background after every rotation, make
+			// sure it caught up with the last one
+			err := db.enforceRetention()
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := segmentNames(t, dir)
+			if tt.maxSegments > 0 && len(names) > tt.maxSegments {
+				t.Fatalf("%d segments %v left, want at most %d", len(names), names, tt.maxSegments)
+			}
+			var total int64
+			for _, name := range names {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				total += info.Size()
+			}
+			if tt.maxTotalBytes > 0 && total > tt.maxTotalBytes {
+				t.Fatalf("segments take %d bytes, want at most %d", total, tt.maxTotalBytes)
+			}
+			if len(names) == 0 || names[0] == first {
+				t.Fatalf("segments %v left, the oldest should have been removed", names)
+			}
+
+			// What the removed segments held comes back from the checkpoint
+			db = reopenTestDatabase(t, db, dir)
+			checkContents(t, db, want)
+		})
+	}
+}
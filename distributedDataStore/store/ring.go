@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"personalMonorepo/distributedDataStore/contract"
+	"sort"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoNodes is returned by a Ring that has no nodes to route to.
+var ErrNoNodes = errors.New("ring has no nodes")
+
+// defaultVirtualNodes is the number of points each node gets on the ring when
+// none is given.
+const defaultVirtualNodes = 128
+
+// Ring is a client that spreads keys over several database nodes with
+// consistent hashing, and talks to the owning node over gRPC.
+//
+// Every node is placed on a hash ring at several virtual points, and a key is
+// owned by the first point at or after its hash. Adding or removing a node
+// only moves the keys between its points and their neighbours, about 1/N of
+// them, while the virtual points keep the share of each node even. The ring
+// doesn't move any data itself, keys routed to a new owner read as missing
+// until they're written again.
+type Ring struct {
+	virtualNodes int
+
+	mu sync.RWMutex
+	// points is the sorted list of virtual node hashes, owners maps each of
+	// them to the address of its node
+	points  []uint32
+	owners  map[uint32]string
+	clients map[string]*ringNode
+}
+
+type ringNode struct {
+	conn   *grpc.ClientConn
+	client contract.StoreClient
+}
+
+// NewRing returns an empty ring placing every node at virtualNodes points,
+// zero picks a default.
+func NewRing(virtualNodes int) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	return &Ring{
+		virtualNodes: virtualNodes,
+		owners:       make(map[uint32]string),
+		clients:      make(map[string]*ringNode),
+	}
+}
+
+// Add connects to the gRPC endpoint of a node at addr and places it on the
+// ring. Adding a node twice is a no-op.
+func (r *Ring) Add(addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[addr]; ok {
+		return nil
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	r.clients[addr] = &ringNode{conn: conn, client: contract.NewStoreClient(conn)}
+
+	for i := 0; i < r.virtualNodes; i++ {
+		point := ringHash(addr + "#" + strconv.Itoa(i))
+		if _, taken := r.owners[point]; taken {
+			// Vanishingly rare, the first node to claim a point keeps it
+			continue
+		}
+		r.owners[point] = addr
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return nil
+}
+
+// Remove takes the node at addr off the ring and closes its connection. Its
+// keys are routed to the nodes following its points.
+func (r *Ring) Remove(addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	node, ok := r.clients[addr]
+	if !ok {
+		return nil
+	}
+	delete(r.clients, addr)
+
+	points := r.points[:0]
+	for _, point := range r.points {
+		if r.owners[point] == addr {
+			delete(r.owners, point)
+			continue
+		}
+		points = append(points, point)
+	}
+	r.points = points
+
+	return node.conn.Close()
+}
+
+// Owner returns the address of the node owning key.
+func (r *Ring) Owner(key string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.ownerLocked(key)
+}
+
+func (r *Ring) ownerLocked(key string) (string, error) {
+	if len(r.points) == 0 {
+		return "", ErrNoNodes
+	}
+
+	hash := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		// Past the last point, wrap around to the first
+		i = 0
+	}
+	return r.owners[r.points[i]], nil
+}
+
+// Get reads key from the node owning it.
+func (r *Ring) Get(key string) ([]byte, error) {
+	client, err := r.clientFor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(context.Background(), &contract.GetRequest{Key: key})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return resp.Value, nil
+}
+
+// Set writes key to the node owning it.
+func (r *Ring) Set(key string, value []byte) error {
+	client, err := r.clientFor(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Put(context.Background(), &contract.PutRequest{Key: key, Value: value})
+	return fromStatus(err)
+}
+
+// Delete deletes key from the node owning it and reports whether it was set.
+func (r *Ring) Delete(key string) (bool, error) {
+	client, err := r.clientFor(key)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Delete(context.Background(), &contract.DeleteRequest{Key: key})
+	if err != nil {
+		return false, fromStatus(err)
+	}
+	return resp.Existed, nil
+}
+
+// Close closes the connections to every node.
+func (r *Ring) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var firstErr error
+	for addr, node := range r.clients {
+		err := node.conn.Close()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("closing connection to %s: %w", addr, err)
+		}
+	}
+	r.clients = make(map[string]*ringNode)
+	r.owners = make(map[uint32]string)
+	r.points = nil
+	return firstErr
+}
+
+func (r *Ring) clientFor(key string) (contract.StoreClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	addr, err := r.ownerLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	return r.clients[addr].client, nil
+}
+
+// ringHash places s on the ring. FNV-1a alone leaves the hashes of similar
+// strings, like the virtual points addr#0, addr#1 and so on of a node, close
+// together, so its result goes through the finalizer of MurmurHash3 to spread
+// them over the whole ring.
+func ringHash(s string) uint32 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9a62d1b3f53
+	x ^= x >> 33
+	return uint32(x >> 32)
+}
+
+// fromStatus maps gRPC status codes back onto database errors, the reverse of
+// toStatus.
+func fromStatus(err error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound:
+		return ErrKeyNotFound
+	case codes.Unavailable:
+		if s, _ := status.FromError(err); s.Message() == ErrClosed.Error() {
+			return ErrClosed
+		}
+	}
+	return err
+}
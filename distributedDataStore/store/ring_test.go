@@ -0,0 +1,117 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingSpreadsKeysEvenly(t *testing.T) {
+	const keys = 100000
+
+	ring := NewRing(0)
+	defer ring.Close()
+	for i := 0; i < 4; i++ {
+		// Dialing is lazy, nothing needs to listen on these
+		err := ring.Add(fmt.Sprintf("127.0.0.1:%d", 7000+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner, err := ring.Owner(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before[key] = owner
+	}
+	checkShares(t, before, 4)
+
+	err := ring.Add("127.0.0.1:7004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := make(map[string]string, keys)
+	moved := 0
+	for key, old := range before {
+		owner, err := ring.Owner(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after[key] = owner
+		if owner != old {
+			if owner != "127.0.0.1:7004" {
+				t.Fatalf("key %s moved from %s to %s, not to the new node", key, old, owner)
+			}
+			moved++
+		}
+	}
+	checkShares(t, after, 5)
+
+	// About 1/5 of the keys move to the new node
+	share := float64(moved) / keys
+	if share < 0.15 || share > 0.25 {
+		t.Fatalf("%.1f%% of the keys moved, want about 20%%", 100*share)
+	}
+}
+
+func TestRingRemoveMovesOnlyItsKeys(t *testing.T) {
+	ring := NewRing(0)
+	defer ring.Close()
+	for i := 0; i < 3; i++ {
+		err := ring.Add(fmt.Sprintf("127.0.0.1:%d", 7000+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		before[key], _ = ring.Owner(key)
+	}
+	err := ring.Remove("127.0.0.1:7001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, old := range before {
+		owner, _ := ring.Owner(key)
+		if old != "127.0.0.1:7001" && owner != old {
+			t.Fatalf("key %s moved from %s to %s though its node stayed", key, old, owner)
+		}
+		if owner == "127.0.0.1:7001" {
+			t.Fatalf("key %s still routed to the removed node", key)
+		}
+	}
+}
+
+func TestRingWithoutNodes(t *testing.T) {
+	ring := NewRing(0)
+	_, err := ring.Owner("key")
+	if err != ErrNoNodes {
+		t.Fatalf("got %v, want ErrNoNodes", err)
+	}
+}
+
+// checkShares fails t unless each of the n nodes owns a fair share of the keys
+// in owners, within a third of fair either way.
+func checkShares(t *testing.T, owners map[string]string, n int) {
+	t.Helper()
+
+	counts := make(map[string]int)
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	if len(counts) != n {
+		t.Fatalf("%d nodes own keys, want %d", len(counts), n)
+	}
+	fair := float64(len(owners)) / float64(n)
+	for owner, count := range counts {
+		if float64(count) < fair*2/3 || float64(count) > fair*4/3 {
+			t.Errorf("%s owns %.1f%% of the keys, want about %.1f%%", owner,
+				100*float64(count)/float64(len(owners)), 100/float64(n))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+import "time"
+
+// WithRotateInterval rotates the log file once it's been written to for
+// interval, whatever its size, on top of the rotation by size. Both count from
+// the last rotation, so a file rotated for its size isn't rotated again for its
+// age right after. Zero, the default, only rotates by size. It only applies to
+// the FileLogStore.
+func WithRotateInterval(interval time.Duration) Option {
+	return func(db *Database) {
+		db.rotateInterval = interval
+	}
+}
+
+// rotateOnInterval rotates the log file each time it reaches rotateInterval of
+// age. It stops when the database is closed.
+func (db *Database) rotateOnInterval() {
+	wait := db.rotateInterval
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-db.clock.After(wait):
+			wait = db.rotateIfDue()
+		}
+	}
+}
+
+// rotateIfDue rotates the log file if it's reached rotateInterval of age and
+// holds any records, and returns how long until the next rotation is due.
+func (db *Database) rotateIfDue() time.Duration {
+	// Rotation by size happens under logFileLock too, so the two never race
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	store, ok := db.store.(*FileLogStore)
+	if db.closed || !ok || db.frozen.Load() {
+		return db.rotateInterval
+	}
+
+	age := db.clock.Now().Sub(store.openedAt)
+	if age < db.rotateInterval {
+		// Rotated by size since the timer was set
+		return db.rotateInterval - age
+	}
+	if store.size == store.start {
+		// Don't seal empty segments, wait for the next interval instead
+		return db.rotateInterval
+	}
+
+	err := store.Rotate()
+	if err != nil {
+		db.logger.Sugar().Errorf("Failed to rotate log file by age: %v", err)
+	}
+	return db.rotateInterval
+}
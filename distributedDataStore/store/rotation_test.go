@@ -0,0 +1,109 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotationUnderConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir)
+	const writers, writes = 4, 300
+	padding := strings.Repeat("x", 512)
+
+	done := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(2)
+	// Rotations by hand and by size, and the flushes writing to the file
+	// being rotated, race with the writes and reads below
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			db.logFileLock.Lock()
+			err := db.store.(*FileLogStore).Rotate()
+			db.logFileLock.Unlock()
+			if err == nil {
+				err = db.Flush()
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, err = db.SegmentSizes()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, err := db.Scan("writer-")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, err = db.Get("writer-0-key-0")
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	want := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				key := fmt.Sprintf("writer-%d-key-%d", w, i%20)
+				value := fmt.Sprintf("%d-%s", i, padding)
+				_, err := db.Set(key, []byte(value))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				want[key] = value
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	background.Wait()
+	if t.Failed() {
+		return
+	}
+
+	segments, err := db.SegmentSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 {
+		t.Fatalf("log has %d files, the test should have rotated it more", len(segments))
+	}
+
+	// Every write landed in some segment, in order
+	db = reopenTestDatabase(t, db, dir)
+	checkContents(t, db, want)
+}
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KeyValue is a single entry returned by a scan.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// put sets key in the shard at the given version and keeps the sorted index in
+// sync. Callers must hold the shard lock for writing.
+func (s *shard) put(key string, value []byte, version uint64) {
+	s.unshare()
+	old, ok := s.data[key]
+	if !ok {
+		i := sort.SearchStrings(s.keys, key)
+		s.keys = append(s.keys, "")
+		copy(s.keys[i+1:], s.keys[i:])
+		s.keys[i] = key
+		keysGauge.Inc()
+
+		if len(s.keys) > s.bloom.capacity {
+			// The filter would fill up and stop ruling keys out
+			s.rebuildBloom()
+		} else {
+			s.bloom.add(key)
+		}
+	}
+	s.data[key] = value
+	s.versions[key] = version
+	s.valueBytes += int64(len(value) - len(old))
+}
+
+// remove deletes key from the shard and the sorted index. Callers must hold the
+// shard lock for writing.
+func (s *shard) remove(key string) {
+	value, ok := s.data[key]
+	if !ok {
+		return
+	}
+	s.unshare()
+	i := sort.SearchStrings(s.keys, key)
+	s.keys = append(s.keys[:i], s.keys[i+1:]...)
+	delete(s.data, key)
+	if expiresAt, ok := s.expiry[key]; ok {
+		s.unindexExpiry(expiresAt)
+		delete(s.expiry, key)
+	}
+	delete(s.versions, key)
+	s.tags.remove(key, s.meta[key].metadata)
+	delete(s.meta, key)
+	s.valueBytes -= int64(len(value))
+	keysGauge.Dec()
+}
+
+// Range returns copies of the entries with keys in [start, end), sorted by key. An empty
+// end means there is no upper bound. The result is a consistent snapshot taken
+// under the read lock of every shard, later writes don't affect it.
+func (db *Database) Range(start, end string) ([]KeyValue, error) {
+	return db.RangeContext(context.Background(), start, end)
+}
+
+// RangeContext is Range, aborting with ctx.Err() if ctx is done before the
+// scan gets hold of the locks or while it runs.
+func (db *Database) RangeContext(ctx context.Context, start, end string) ([]KeyValue, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	result := db.collect(start, func(key string) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		return end == "" || key < end
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Scan returns copies of the entries whose key starts with prefix, sorted by
+// key. An empty prefix returns every entry.
+func (db *Database) Scan(prefix string) ([]KeyValue, error) {
+	return db.ScanContext(context.Background(), prefix)
+}
+
+// ScanContext is Scan, aborting with ctx.Err() if ctx is done before the scan
+// gets hold of the locks or while it runs.
+func (db *Database) ScanContext(ctx context.Context, prefix string) ([]KeyValue, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	result := db.collect(prefix, func(key string) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		return strings.HasPrefix(key, prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ScanKeys is like Scan but returns only the keys, which avoids copying the
+// values when the caller just wants to list what exists.
+func (db *Database) ScanKeys(prefix string) ([]string, error) {
+	return db.ScanKeysContext(context.Background(), prefix)
+}
+
+// ScanKeysContext is ScanKeys, aborting with ctx.Err() if ctx is done before
+// the scan gets hold of the locks or while it runs.
+func (db *Database) ScanKeysContext(ctx context.Context, prefix string) ([]string, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	entries := db.collectShared(prefix, func(key string) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		return strings.HasPrefix(key, prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.Key)
+	}
+
+	return result, nil
+}
+
+// ScanPage returns a page of Scan: at most limit entries whose key starts with
+// prefix and sorts after the cursor after, sorted by key, plus the cursor of
+// the next page, empty once there is none. Start with an empty after. Each
+// page is a consistent snapshot, but pages aren't one together: a key written
+// between pages is listed only if it sorts after the cursor, and one deleted
+// is missing from the pages after. Every key present throughout is listed
+// exactly once.
+func (db *Database) ScanPage(prefix, after string, limit int) ([]KeyValue, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid page limit %d, it must be positive", limit)
+	}
+
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return nil, "", ErrClosed
+	}
+
+	start := prefix
+	if after > start {
+		start = after
+	}
+
+	// The page can't hold more than limit entries of any one shard, and
+	// one more tells whether there's a next page
+	now := db.clock.Now().UnixNano()
+	result := make([]KeyValue, 0)
+	for _, s := range db.shards {
+		n := 0
+		for i := sort.SearchStrings(s.keys, start); i < len(s.keys) && n <= limit; i++ {
+			key := s.keys[i]
+			if !strings.HasPrefix(key, prefix) {
+				break
+			}
+			if key == after || s.expiredAt(key, now) {
+				continue
+			}
+			result = append(result, KeyValue{Key: key, Value: copyValue(s.data[key])})
+			n++
+		}
+	}
+	sortByKey(result)
+
+	if len(result) <= limit {
+		return result, "", nil
+	}
+	result = result[:limit]
+	return result, result[limit-1].Key, nil
+}
+
+// collect returns copies of the live entries from start on for as long as in
+// holds, sorted by key. Each shard's sorted index keeps this O(log n + k) per
+// shard, plus sorting the merged result. Callers must hold every shard lock.
+func (db *Database) collect(start string, in func(key string) bool) []KeyValue {
+	result := db.collectShared(start, in)
+	for i := range result {
+		result[i].Value = copyValue(result[i].Value)
+	}
+	return result
+}
+
+// collectShared is collect without copying the values, for callers that don't
+// hand them out.
+func (db *Database) collectShared(start string, in func(key string) bool) []KeyValue {
+	now := db.clock.Now().UnixNano()
+	result := make([]KeyValue, 0)
+	for _, s := range db.shards {
+		for i := sort.SearchStrings(s.keys, start); i < len(s.keys); i++ {
+			key := s.keys[i]
+			if !in(key) {
+				break
+			}
+			if s.expiredAt(key, now) {
+				continue
+			}
+			result = append(result, KeyValue{Key: key, Value: s.data[key]})
+		}
+	}
+	sortByKey(result)
+	return result
+}
+
+// Len returns the number of live keys, 0 once the database is closed.
+func (db *Database) Len() int {
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return 0
+	}
+
+	now := db.clock.Now().UnixNano()
+	n := 0
+	for _, s := range db.shards {
+		n += s.liveCount(now)
+	}
+	return n
+}
+
+// Keys returns a snapshot of every live key, sorted.
+func (db *Database) Keys() []string {
+	keys := make([]string, 0)
+	db.forEach(func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// ForEach calls fn for every live key in key order, with a copy of its value,
+// until fn returns false. Unlike Scan it doesn't build the whole result up
+// front. It holds the read lock of every shard throughout, so fn sees a
+// consistent snapshot but must not write to the database.
+func (db *Database) ForEach(fn func(key string, value []byte) bool) {
+	db.forEach(func(key string, value []byte) bool {
+		return fn(key, copyValue(value))
+	})
+}
+
+// forEach is ForEach handing fn the stored values, which it must not modify
+// or keep.
+func (db *Database) forEach(fn func(key string, value []byte) bool) {
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return
+	}
+
+	// Merge the shards' sorted indexes, the shard count is small enough to
+	// pick the next key by a linear pass over their heads
+	now := db.clock.Now().UnixNano()
+	heads := make([]int, len(db.shards))
+	for {
+		next := -1
+		for i, s := range db.shards {
+			if heads[i] < len(s.keys) && (next < 0 || s.keys[heads[i]] < db.shards[next].keys[heads[next]]) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return
+		}
+
+		s := db.shards[next]
+		key := s.keys[heads[next]]
+		heads[next]++
+		if s.expiredAt(key, now) {
+			continue
+		}
+		if !fn(key, s.data[key]) {
+			return
+		}
+	}
+}
+
+// copyValue returns a copy of a stored value for handing out, a caller
+// mutating the stored slice would silently corrupt the in-memory database.
+func copyValue(value []byte) []byte {
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied
+}
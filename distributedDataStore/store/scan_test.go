@@ -0,0 +1,111 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScanPrefix(t *testing.T) {
+	db := NewMemoryDatabase(WithShards(4))
+	for _, key := range []string{"user:2", "user:1", "user:10", "users", "order:1", "use"} {
+		mustSet(t, db, key, "v-"+key)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "user:", want: []string{"user:1", "user:10", "user:2"}},
+		{prefix: "user", want: []string{"user:1", "user:10", "user:2", "users"}},
+		{prefix: "order:", want: []string{"order:1"}},
+		{prefix: "missing", want: []string{}},
+		{prefix: "", want: []string{"order:1", "use", "user:1", "user:10", "user:2", "users"}},
+	}
+	for _, tt := range tests {
+		entries, err := db.Scan(tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if string(entry.Value) != "v-"+entry.Key {
+				t.Fatalf("key %q holds %q", entry.Key, entry.Value)
+			}
+			keys = append(keys, entry.Key)
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Fatalf("Scan(%q) got %v, want %v", tt.prefix, keys, tt.want)
+		}
+
+		onlyKeys, err := db.ScanKeys(tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(onlyKeys, tt.want) {
+			t.Fatalf("ScanKeys(%q) got %v, want %v", tt.prefix, onlyKeys, tt.want)
+		}
+	}
+}
+
+func TestScanHandsOutCopies(t *testing.T) {
+	db := NewMemoryDatabase()
+	mustSet(t, db, "key", "value")
+
+	entries, err := db.Scan("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0].Value[0] = 'X'
+	page, _, err := db.ScanPage("", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page[0].Value[0] = 'Y'
+
+	checkContents(t, db, map[string]string{"key": "value"})
+}
+
+func TestLenCountsLiveKeys(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	db := openTestDatabase(t, t.TempDir(), WithShards(4), WithClock(clock))
+	setWithTTL := func(key string, ttl time.Duration) {
+		t.Helper()
+		err := db.SetWithTTL(key, []byte("value"), ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkLen := func(want int) {
+		t.Helper()
+		if n := db.Len(); n != want {
+			t.Fatalf("Len returned %d, want %d", n, want)
+		}
+	}
+
+	mustSet(t, db, "a", "1")
+	setWithTTL("b", time.Second)
+	setWithTTL("c", time.Second)
+	setWithTTL("d", time.Hour)
+	checkLen(4)
+
+	// c's TTL moves out, b is deleted before it expires, a gets one
+	setWithTTL("c", time.Hour)
+	mustDelete(t, db, "b")
+	setWithTTL("a", time.Second)
+	checkLen(3)
+
+	// Expired keys stop counting before the sweep evicts them
+	clock.Advance(time.Minute)
+	checkLen(2)
+	clock.Advance(2 * time.Hour)
+	checkLen(0)
+
+	mustSet(t, db, "e", "5")
+	checkLen(1)
+	err := db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLen(0)
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"os"
+	"personalMonorepo/distributedDataStore/contract"
+	"time"
+)
+
+// SegmentInfo describes a file of the log, see Segments.
+type SegmentInfo struct {
+	Path string
+	// Size is the size of the file on disk, without the records a write
+	// buffer still holds back
+	Size int64
+	// Active is set on the file being appended to, the others are sealed
+	Active bool
+	// Records is the number of intact records, batch markers included, and
+	// MinTimestamp and MaxTimestamp the earliest and latest write time they
+	// carry, zero if none does. SegmentSizes leaves them zero.
+	Records      int
+	MinTimestamp time.Time
+	MaxTimestamp time.Time
+}
+
+// Segments lists the files of the log, oldest first, with the number of
+// records in each and the write times they span, to tell when to compact or
+// prune. It reads every record to count them, holding up writes meanwhile,
+// use SegmentSizes when sizes will do. Records failing their checksum aren't
+// counted. It fails with ErrNotSupported for logs not kept in files by the
+// FileLogStore.
+func (db *Database) Segments() ([]SegmentInfo, error) {
+	return db.segments(true)
+}
+
+// SegmentSizes is Segments without reading the files, only their sizes are
+// filled in.
+func (db *Database) SegmentSizes() ([]SegmentInfo, error) {
+	return db.segments(false)
+}
+
+func (db *Database) segments(scan bool) ([]SegmentInfo, error) {
+	if db.shardLogs {
+		return nil, ErrNotSupported
+	}
+	if _, ok := db.store.(*FileLogStore); db.store != nil && !ok {
+		return nil, ErrNotSupported
+	}
+
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	var infos []SegmentInfo
+	err := db.withLogFiles(func(segments []string) error {
+		for _, path := range segments {
+			stat, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			info := SegmentInfo{Path: path, Size: stat.Size(), Active: path == db.logFile}
+			if scan {
+				err = db.scanSegment(&info)
+				if err != nil {
+					return err
+				}
+			}
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// scanSegment counts the records of the segment at info.Path into info,
+// leaving the file as it is.
+func (db *Database) scanSegment(info *SegmentInfo) error {
+	var min, max int64
+	opts := logReadOptions{readOnly: true}
+	_, err := readLogFile(db.logger, info.Path, 0, opts, func(header logHeader, record []byte) error {
+		entry := &contract.LogEntry{}
+		err := db.decodeLogEntry(header, record, entry)
+		if err != nil {
+			return err
+		}
+		info.Records++
+		// Batch markers and records of older logs carry no time
+		if entry.Timestamp == 0 {
+			return nil
+		}
+		if min == 0 || entry.Timestamp < min {
+			min = entry.Timestamp
+		}
+		if entry.Timestamp > max {
+			max = entry.Timestamp
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if min != 0 {
+		info.MinTimestamp = time.Unix(0, min)
+		info.MaxTimestamp = time.Unix(0, max)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultShardCount is the number of shards used when none is given.
+const defaultShardCount = 16
+
+// shard is one partition of the in-memory database. Keys are spread over the
+// shards by hash, so reads and writes of unrelated keys don't contend on the
+// same lock. Operations spanning several shards lock them in index order.
+type shard struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+	// keys is a sorted index over the keys of data, used for ordered scans
+	keys []string
+	// expiry maps keys set with a TTL to their expiry in unix nanoseconds
+	expiry map[string]int64
+	// expiries is a sorted index over the values of expiry, so the keys
+	// expired by a given time can be counted without scanning it
+	expiries []int64
+	// pending holds the durable state of keys with writes that aren't synced
+	// yet, see Durable
+	pending map[string]*durableState
+	// bloom holds every key of data, so lookups of absent keys can usually
+	// skip the map
+	bloom *bloomFilter
+	// versions maps keys to the version of their last write, see Txn
+	versions map[string]uint64
+	// valueBytes is the total size of the values in data
+	valueBytes int64
+	// meta maps keys to when they were last written and the metadata of
+	// their value, see GetWithMeta
+	meta map[string]recordMeta
+	// tags indexes the keys of meta by their metadata, see QueryByTag
+	tags tagIndex
+	// clock tells when keys expire, it's the database's
+	clock Clock
+	// shared is set while a Snapshot may be reading data, keys and expiry,
+	// which are then copied before they're next changed, see View
+	shared atomic.Bool
+}
+
+func newShard(clock Clock) *shard {
+	return &shard{
+		clock:    clock,
+		data:     make(map[string][]byte),
+		expiry:   make(map[string]int64),
+		pending:  make(map[string]*durableState),
+		bloom:    newBloomFilter(0),
+		versions: make(map[string]uint64),
+		meta:     make(map[string]recordMeta),
+		tags:     make(tagIndex),
+	}
+}
+
+// reset empties the shard. Callers must hold the shard lock for writing.
+func (s *shard) reset() {
+	keysGauge.Sub(float64(len(s.data)))
+	s.data = make(map[string][]byte)
+	s.keys = nil
+	s.expiry = make(map[string]int64)
+	s.expiries = nil
+	s.pending = make(map[string]*durableState)
+	s.bloom = newBloomFilter(0)
+	s.versions = make(map[string]uint64)
+	s.valueBytes = 0
+	s.meta = make(map[string]recordMeta)
+	s.tags = make(tagIndex)
+	s.shared.Store(false)
+}
+
+// WithShards sets the number of shards the in-memory database is split into,
+// 16 by default. One shard serializes every operation on a single lock.
+func WithShards(n int) Option {
+	return func(db *Database) {
+		if n < 1 {
+			n = 1
+		}
+		db.shardCount = n
+	}
+}
+
+// shardFor returns the shard holding key.
+func (db *Database) shardFor(key string) *shard {
+	return db.shards[shardIndex(key, len(db.shards))]
+}
+
+// shardIndex hashes key with FNV-1a, inlined so lookups don't allocate.
+func shardIndex(key string, n int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
+
+// shardsOf returns the distinct shards holding keys, in the order they must be
+// locked in.
+func (db *Database) shardsOf(keys []string) []*shard {
+	used := make([]bool, len(db.shards))
+	for _, key := range keys {
+		used[shardIndex(key, len(db.shards))] = true
+	}
+
+	shards := make([]*shard, 0, len(keys))
+	for i, s := range db.shards {
+		if used[i] {
+			shards = append(shards, s)
+		}
+	}
+	return shards
+}
+
+func lockShards(shards []*shard) {
+	for _, s := range shards {
+		s.mu.Lock()
+	}
+}
+
+func unlockShards(shards []*shard) {
+	for _, s := range shards {
+		s.mu.Unlock()
+	}
+}
+
+func rLockShards(shards []*shard) {
+	for _, s := range shards {
+		s.mu.RLock()
+	}
+}
+
+func rUnlockShards(shards []*shard) {
+	for _, s := range shards {
+		s.mu.RUnlock()
+	}
+}
+
+// lockContext locks mu for writing unless ctx is done first. A mutex can't be
+// abandoned while waiting for it, so ctx is checked before and after taking it.
+func lockContext(ctx context.Context, mu *sync.RWMutex) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	err = ctx.Err()
+	if err != nil {
+		mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// rLockContext is lockContext for the read lock.
+func rLockContext(ctx context.Context, mu *sync.RWMutex) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	mu.RLock()
+	err = ctx.Err()
+	if err != nil {
+		mu.RUnlock()
+		return err
+	}
+	return nil
+}
+
+// size returns the number of keys held, expired or not, unlike Len. Callers
+// must hold every shard lock.
+func (db *Database) size() int {
+	n := 0
+	for _, s := range db.shards {
+		n += len(s.data)
+	}
+	return n
+}
+
+// sortByKey orders entries gathered from several shards by key.
+func sortByKey(entries []KeyValue) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+}
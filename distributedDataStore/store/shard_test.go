@@ -0,0 +1,50 @@
+package store
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkShards compares a single shard to the default 16 on a 50/50 mix
+// of reads and writes from concurrent callers. The database is in memory, so
+// the locks are all there is to contend on. Run it on several CPUs, with -cpu
+// 4 for instance, for the shards to make a difference.
+func BenchmarkShards(b *testing.B) {
+	const keys = 10000
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprintf("key-%d", i)
+	}
+	value := []byte("value")
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("%d shards", shards), func(b *testing.B) {
+			db := NewMemoryDatabase(WithShards(shards), WithValueDedup(false))
+			for _, name := range names {
+				_, err := db.Set(name, value)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			var callers atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Every caller walks the keys from a different place
+				offset := int(callers.Add(1)) * 1000
+				for i := 0; pb.Next(); i++ {
+					key := names[(offset+i*7919)%keys]
+					var err error
+					if i%2 == 0 {
+						_, err = db.Get(key)
+					} else {
+						_, err = db.Set(key, value)
+					}
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,550 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WithShardLogs gives every shard a log file of its own, <logFile>.shard<NN>,
+// instead of the single log, so writes to keys of different shards don't
+// share a file. Under SyncOnCommit their fsyncs run in parallel, outside
+// logFileLock, and a write returns once every earlier write is durable too.
+//
+// Every record carries its position in the global write order, which replay
+// merges the shard logs by. A record missing from the merged order, lost in a
+// crash before it was synced, ends the log there: the records after it are
+// dropped, so the database always recovers to a prefix of its writes. Replay
+// the log before writing to it, the numbering carries on from the last record
+// replayed.
+//
+// Shard logs are never rotated, whatever the rotate size given to NewDatabase.
+// Compaction, checkpoints, retention, tailing and write buffering need the
+// single log and aren't available with them: OpenLogFile fails if
+// WithCompactionPolicy, WithSegmentRetention, WithRotateInterval,
+// WithWriteBuffer or WithLogStore is given too.
+func WithShardLogs() Option {
+	return func(db *Database) {
+		db.shardLogs = true
+	}
+}
+
+// checkShardLogOptions fails if an option given along with WithShardLogs needs
+// the single log.
+func (db *Database) checkShardLogOptions() error {
+	var conflicts []string
+	if db.compactionPolicy != nil {
+		conflicts = append(conflicts, "WithCompactionPolicy")
+	}
+	if db.retention != nil {
+		conflicts = append(conflicts, "WithSegmentRetention")
+	}
+	if db.rotateInterval > 0 {
+		conflicts = append(conflicts, "WithRotateInterval")
+	}
+	if db.writeBuffer > 0 {
+		conflicts = append(conflicts, "WithWriteBuffer")
+	}
+	if db.store != nil {
+		conflicts = append(conflicts, "WithLogStore")
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("WithShardLogs can't be combined with %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// shardLogStore is the LogStore of a database with WithShardLogs. It's only
+// ever written through appendTo, which routes records to the log of their
+// key's shard.
+type shardLogStore struct {
+	logs []*FileLogStore
+	// dirty marks the logs appended to since Sync last synced them
+	dirty []bool
+}
+
+// shardLogPath returns the path of the log of shard i.
+func (db *Database) shardLogPath(i int) string {
+	return fmt.Sprintf("%s.shard%02d", db.logFile, i)
+}
+
+// openShardLogStore opens the log of every shard of the database.
+func (db *Database) openShardLogStore() (*shardLogStore, error) {
+	store := &shardLogStore{dirty: make([]bool, len(db.shards))}
+	for i := range db.shards {
+		// Only compaction would make rotating them worth it, and it isn't
+		// available, see checkShardLogOptions
+		log, err := openFileLogStore(db.shardLogPath(i), math.MaxInt64, db.codec, db.strictReplay, db.clock, db.logger)
+		if err != nil {
+			_ = store.Close()
+			return nil, err
+		}
+		log.reencode = db.reencode
+		store.logs = append(store.logs, log)
+	}
+	return store, nil
+}
+
+// Append appends a record to the log of the first shard. Records belonging to
+// a key go through appendTo.
+func (s *shardLogStore) Append(record []byte) error {
+	return s.appendTo(0, record)
+}
+
+// appendTo appends a record to the log of shard i. Callers must hold
+// logFileLock.
+func (s *shardLogStore) appendTo(i int, record []byte) error {
+	s.dirty[i] = true
+	return s.logs[i].Append(record)
+}
+
+// appendAll appends each record to the log of the shard at the same index in
+// shards, like FileLogStore.appendAll: if one fails the ones before it are
+// taken back, and so are all of them by the returned func.
+func (s *shardLogStore) appendAll(records [][]byte, shards []int) (func(), error) {
+	undos := make([]func(), 0, len(records))
+	undo := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+
+	for i, record := range records {
+		undos = append(undos, s.logs[shards[i]].mark())
+		err := s.appendTo(shards[i], record)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+	}
+	return undo, nil
+}
+
+// ReadAll isn't supported, ordering the records of the shard logs takes
+// decoding them, which replay does itself, see replayShardLogs.
+func (s *shardLogStore) ReadAll(fn func(record []byte) error) error {
+	return ErrNotSupported
+}
+
+// Sync syncs the shard logs appended to since the last Sync, in parallel.
+// Callers must hold logFileLock.
+func (s *shardLogStore) Sync() error {
+	var shards []int
+	for i, dirty := range s.dirty {
+		if dirty {
+			shards = append(shards, i)
+		}
+	}
+
+	err := s.syncLogs(shards)
+	if err != nil {
+		return err
+	}
+	for _, i := range shards {
+		s.dirty[i] = false
+	}
+	return nil
+}
+
+// syncLogs syncs the logs of the given shards in parallel. It only syncs the
+// files, which never change since shard logs aren't rotated, so it doesn't
+// need logFileLock.
+func (s *shardLogStore) syncLogs(shards []int) error {
+	switch len(shards) {
+	case 0:
+		return nil
+	case 1:
+		return s.logs[shards[0]].file.Sync()
+	}
+
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for n, i := range shards {
+		wg.Add(1)
+		go func(n, i int) {
+			defer wg.Done()
+			errs[n] = s.logs[i].file.Sync()
+		}(n, i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shardLogStore) Rotate() error {
+	return ErrNotSupported
+}
+
+// Truncate isn't supported, the last records appended are spread over the
+// shard logs. Writes through the Database take theirs back with appendAll.
+func (s *shardLogStore) Truncate(n int) error {
+	return ErrNotSupported
+}
+
+func (s *shardLogStore) Close() error {
+	var err error
+	for _, log := range s.logs {
+		if closeErr := log.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// shardIndexesOf returns the indexes of the shards holding the keys of
+// entries, each once.
+func (db *Database) shardIndexesOf(entries []*contract.LogEntry) []int {
+	used := make([]bool, len(db.shards))
+	var shards []int
+	for _, entry := range entries {
+		i := shardIndex(entry.Key, len(db.shards))
+		if !used[i] {
+			used[i] = true
+			shards = append(shards, i)
+		}
+	}
+	return shards
+}
+
+// commitShardLogs is writeLogEntries for shard logs under SyncOnCommit. Records
+// are numbered and appended under logFileLock, but only the logs they went to
+// are synced, after it's released, so writes to other shards can sync
+// meanwhile. It returns once every record up to the last one is synced, so a
+// write is never acknowledged while an earlier one could still be lost, which
+// would drop it on replay.
+func (db *Database) commitShardLogs(store *shardLogStore, logEntries []*contract.LogEntry) error {
+	db.logFileLock.Lock()
+	err := db.shardLogErr
+	if err == nil {
+		err = db.appendLogFile(logEntries...)
+	}
+	if err != nil {
+		db.logFileLock.Unlock()
+		return err
+	}
+	last := db.logSeq
+	if db.leader != nil {
+		db.leader.append(logEntries)
+	}
+	db.logFileLock.Unlock()
+
+	err = store.syncLogs(db.shardIndexesOf(logEntries))
+
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if err != nil {
+		// The records are in the log but may not survive a crash, and the
+		// ones after them would be dropped with them, so nothing written from
+		// here on can be acknowledged either
+		db.syncErr = err
+		db.shardLogErr = fmt.Errorf("shard log failed to sync: %w", err)
+		db.shardLogSynced.Broadcast()
+		return err
+	}
+	db.markShardLogsSynced(last-uint64(len(logEntries))+1, last)
+
+	for db.syncedSeq.Load() < last && db.shardLogErr == nil {
+		db.shardLogSynced.Wait()
+	}
+	if db.syncedSeq.Load() < last {
+		return db.shardLogErr
+	}
+	return nil
+}
+
+// markShardLogsSynced records that the records first to last are synced, and
+// moves syncedSeq past every record synced without a gap. Callers must hold
+// logFileLock.
+func (db *Database) markShardLogsSynced(first, last uint64) {
+	db.shardLogRanges[first] = last
+
+	synced := db.syncedSeq.Load()
+	for advanced := true; advanced; {
+		advanced = false
+		for rangeFirst, rangeLast := range db.shardLogRanges {
+			if rangeFirst > synced+1 {
+				continue
+			}
+			if rangeLast > synced {
+				synced = rangeLast
+			}
+			delete(db.shardLogRanges, rangeFirst)
+			advanced = true
+		}
+	}
+
+	if synced > db.syncedSeq.Load() {
+		db.syncedSeq.Store(synced)
+		db.syncErr = nil
+		db.lastSync = db.clock.Now()
+		db.releaseWatched(synced)
+		db.shardLogSynced.Broadcast()
+	}
+}
+
+// shardLogSuffix matches what follows <logFile>.shard in the name of a shard log
+var shardLogSuffix = regexp.MustCompile(`^\d{2,}$`)
+
+// discoverShardLogs returns the files of every shard log of the database,
+// whatever the number of shards they were written with: its sealed segments
+// from oldest to newest, then the log itself.
+func (db *Database) discoverShardLogs() ([][]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(db.logFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(db.logFile) + ".shard"
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, prefix) && shardLogSuffix.MatchString(name[len(prefix):]) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var logs [][]string
+	for _, name := range names {
+		// A log is only sealed when it's reopened with another format
+		files, err := discoverSegments(filepath.Join(filepath.Dir(db.logFile), name))
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, files)
+	}
+	return logs, nil
+}
+
+// shardLogReader reads the records of one shard log, one ahead of the merge.
+type shardLogReader struct {
+	files []string
+	// file is files[current], header its format and size its size
+	current int
+	file    *os.File
+	header  logHeader
+	size    int64
+	// entry is the next record, nil once the log is exhausted, and record
+	// what it was decoded from. It starts at offset and ends at end.
+	entry  *contract.LogEntry
+	record []byte
+	offset int64
+	end    int64
+	// torn is set when the log ended on a record that couldn't be read
+	torn bool
+}
+
+// next reads the next record of the log into r.entry.
+func (r *shardLogReader) next(db *Database, stats *ReplayStats) error {
+	if r.entry != nil {
+		r.offset = r.end
+		r.entry = nil
+	}
+	for r.current < len(r.files) {
+		if r.file == nil {
+			file, err := os.Open(r.files[r.current])
+			if err != nil {
+				return err
+			}
+			info, err := file.Stat()
+			if err != nil {
+				_ = file.Close()
+				return err
+			}
+			header, start, err := readLogHeader(file)
+			if err != nil {
+				_ = file.Close()
+				return fmt.Errorf("%s: %w", r.files[r.current], err)
+			}
+			r.file, r.header, r.size, r.offset = file, header, info.Size(), start
+		}
+
+		record, next, err := readRecord(r.file, r.offset, r.size, r.header.version)
+		if err == io.EOF {
+			// A record cut short reads as the end of the file too
+			if r.size > r.offset {
+				r.torn = true
+				return nil
+			}
+			_ = r.file.Close()
+			r.file = nil
+			r.current++
+			continue
+		}
+		entry := &contract.LogEntry{}
+		if err == nil {
+			err = db.decodeLogEntry(r.header, record, entry)
+		}
+		if err != nil {
+			if db.strictReplay && errors.Is(err, ErrCorruptRecord) {
+				return fmt.Errorf("%s at offset %d: %w", r.files[r.current], r.offset, err)
+			}
+			// Whatever comes after it in this log comes after a gap in the
+			// merged order too
+			if errors.Is(err, ErrCorruptRecord) {
+				stats.SkippedCorrupt++
+			}
+			r.torn = true
+			return nil
+		}
+
+		r.entry, r.record, r.end = entry, record, next
+		return nil
+	}
+	return nil
+}
+
+func (r *shardLogReader) close() {
+	if r.file != nil {
+		_ = r.file.Close()
+	}
+}
+
+// replayShardLogs replays the shard logs through r, merging them by sequence
+// number and stopping at the first record missing. Unless the database is
+// read-only, every log is then truncated after its last record replayed, so
+// the records after the gap don't reappear once it's filled by new writes.
+// Callers must hold compactLock and every shard lock.
+func (db *Database) replayShardLogs(r *replayer) error {
+	sugar := db.logger.Sugar()
+
+	logs, err := db.discoverShardLogs()
+	if err != nil {
+		return err
+	}
+
+	readers := make([]*shardLogReader, len(logs))
+	defer func() {
+		for _, reader := range readers {
+			reader.close()
+		}
+	}()
+	for i, files := range logs {
+		readers[i] = &shardLogReader{files: files}
+		err = readers[i].next(db, r.stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	var last uint64
+	for {
+		var head *shardLogReader
+		for _, reader := range readers {
+			if reader.entry != nil && (head == nil || reader.entry.Seq < head.entry.Seq) {
+				head = reader
+			}
+		}
+		if head == nil {
+			break
+		}
+		// The numbering starts wherever the oldest record left, a truncated
+		// log carries on from where it was
+		if last != 0 && head.entry.Seq != last+1 {
+			sugar.Warnf("Record %d is missing from the shard logs, dropping the records from %d on", last+1, head.entry.Seq)
+			break
+		}
+
+		err = r.replayEntry(head.entry, int64(recordPrefixSize(head.header.version))+int64(len(head.record)))
+		if err != nil {
+			return err
+		}
+		last = head.entry.Seq
+		err = head.next(db, r.stats)
+		if err != nil {
+			return err
+		}
+	}
+	r.finish("the shard logs")
+
+	if !db.readOnly {
+		for _, reader := range readers {
+			err = db.cutShardLog(reader)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if last > db.logSeq {
+		db.logSeq = last
+		db.syncedSeq.Store(last)
+	}
+	return nil
+}
+
+// cutShardLog drops what the merge didn't replay from the log read by reader,
+// if anything: the rest of the file it stopped in and the files after it.
+func (db *Database) cutShardLog(reader *shardLogReader) error {
+	if reader.entry == nil && !reader.torn {
+		return nil
+	}
+
+	path := reader.files[reader.current]
+	db.logger.Sugar().Warnf("Truncating shard log %s at offset %d", path, reader.offset)
+	reader.close()
+	reader.file = nil
+
+	err := os.Truncate(path, reader.offset)
+	if err != nil {
+		return err
+	}
+	active := reader.files[len(reader.files)-1]
+	for _, later := range reader.files[reader.current+1:] {
+		if later == active {
+			// It may be open, empty it rather than removing it
+			err = truncateToHeader(later)
+		} else {
+			err = os.Remove(later)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// The log may be open already, it must append where the file ends now
+	if store, ok := db.store.(*shardLogStore); ok {
+		for _, log := range store.logs {
+			if log.path == active {
+				info, err := log.file.Stat()
+				if err != nil {
+					return err
+				}
+				log.size, log.synced = info.Size(), info.Size()
+			}
+		}
+	}
+	return nil
+}
+
+// truncateToHeader drops every record of the log file at path, keeping its
+// header.
+func truncateToHeader(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	_, start, err := readLogHeader(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, start)
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestShardLogsReplayInWriteOrder(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{WithShards(8), WithShardLogs(), WithSyncMode(SyncOnCommit)}
+	db := openTestDatabase(t, dir, opts...)
+	rng := rand.New(rand.NewSource(1))
+	// Overwrites and deletes of the same keys, and batches spanning shards,
+	// only replay to the same state if the shard logs merge back in order
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key-%d", rng.Intn(40))
+		switch n := rng.Intn(10); {
+		case n < 6:
+			mustSet(t, db, key, fmt.Sprintf("value-%d", i))
+		case n < 8:
+			mustDelete(t, db, key)
+		default:
+			b := db.Batch()
+			for j := 0; j < 4; j++ {
+				b.Set(fmt.Sprintf("key-%d", rng.Intn(40)), []byte(fmt.Sprintf("batch-%d-%d", i, j)))
+			}
+			b.Delete(fmt.Sprintf("key-%d", rng.Intn(40)))
+			err := b.Commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	want := make(map[string]string)
+	versions := make(map[string]uint64)
+	db.ForEach(func(key string, value []byte) bool {
+		want[key] = string(value)
+		return true
+	})
+	for key := range want {
+		_, version, err := db.GetVersion(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions[key] = version
+	}
+
+	db = reopenTestDatabase(t, db, dir, opts...)
+	checkContents(t, db, want)
+	for key, want := range versions {
+		_, version, err := db.GetVersion(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != want {
+			t.Fatalf("key %q at version %d after replay, want %d", key, version, want)
+		}
+	}
+}
+
+func TestShardLogsRecoverToPrefixOfWrites(t *testing.T) {
+	dir := t.TempDir()
+	const shards, keys = 8, 100
+	opts := []Option{WithShards(shards), WithShardLogs(), WithSyncMode(SyncOnCommit)}
+	db := openTestDatabase(t, dir, opts...)
+	last := make([]int, shards)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		mustSet(t, db, key, "value")
+		last[shardIndex(key, shards)] = i
+	}
+	err := db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tear the last record of the shard log that was written to last the
+	// longest ago, as if a crash had cut it short
+	torn := 0
+	for i := range last {
+		if last[i] < last[torn] {
+			torn = i
+		}
+	}
+	path := db.shardLogPath(torn)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(path, info.Size()-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The writes after the torn one are dropped from every shard, not just
+	// the torn one
+	want := make(map[string]string)
+	for i := 0; i < last[torn]; i++ {
+		want[fmt.Sprintf("key-%03d", i)] = "value"
+	}
+	db = openTestDatabase(t, dir, opts...)
+	checkContents(t, db, want)
+
+	// The numbering carries on from the prefix, so new writes replay too
+	mustSet(t, db, "after", "value")
+	want["after"] = "value"
+	db = reopenTestDatabase(t, db, dir, opts...)
+	checkContents(t, db, want)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Snapshot writes the current contents of the database to a standalone file at
+// path, in the same format as the log. The keyspace is copied under the read
+// lock of every shard, so the snapshot reflects a single moment, and the live
+// log is left untouched.
+func (db *Database) Snapshot(path string) error {
+	rLockShards(db.shards)
+	if db.closed {
+		rUnlockShards(db.shards)
+		return ErrClosed
+	}
+	entries := db.liveEntries()
+	rUnlockShards(db.shards)
+
+	return db.writeSegment(path, entries)
+}
+
+// LoadSnapshot restores a snapshot written by Snapshot into an empty database.
+// The restored keys are also appended to the database's own log, if it has
+// one open, so they survive a restart. The snapshot is only read, and a record
+// failing its checksum fails the load whatever WithStrictReplay says, leaving
+// the database empty.
+func (db *Database) LoadSnapshot(path string) (err error) {
+	sugar := db.logger.Sugar()
+
+	err = db.enterWrite(context.Background())
+	if err != nil {
+		return err
+	}
+	defer db.exitWrite()
+
+	// Deferred before taking the shard locks, so it waits for the followers
+	// once they're released, like Set
+	var leader *ReplicationLeader
+	var seq uint64
+	defer func() {
+		if err == nil {
+			err = leader.awaitAcks(seq)
+		}
+	}()
+
+	lockShards(db.shards)
+	defer unlockShards(db.shards)
+
+	if db.closed {
+		return ErrClosed
+	}
+	if n := db.size(); n != 0 {
+		return fmt.Errorf("can't load snapshot %s into a database holding %d keys", path, n)
+	}
+
+	// A snapshot isn't the database's log: leave the file as it is, and
+	// fail on a corrupt record rather than restore without it
+	r := &replayer{db: db, stats: &ReplayStats{}}
+	err = r.readFile(path, 0, logReadOptions{strict: true, readOnly: true, mmap: db.mmapReplay})
+	if err != nil {
+		// Nothing was logged yet, drop the keys restored before the failure
+		for _, s := range db.shards {
+			s.reset()
+		}
+		return err
+	}
+
+	err = db.writeLogEntries(db.liveEntries()...)
+	if err != nil {
+		return err
+	}
+	leader, seq = db.replicationPosition()
+
+	// The keys were applied before they were logged, sync them so Durable
+	// doesn't have to tell them apart
+	err = db.Flush()
+	if err != nil {
+		return err
+	}
+
+	sugar.Infof("Loaded %d keys from snapshot %s", db.size(), path)
+	return nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	const keys = 5000
+
+	dir := t.TempDir()
+	db := openTestDatabase(t, filepath.Join(dir, "source"))
+	want := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key, value := fmt.Sprintf("key-%05d", i), fmt.Sprintf("value-%d", i*i)
+		mustSet(t, db, key, value)
+		want[key] = value
+	}
+	// Deleted and overwritten keys only show up as they are now
+	for i := 0; i < keys; i += 7 {
+		key := fmt.Sprintf("key-%05d", i)
+		mustDelete(t, db, key)
+		delete(want, key)
+	}
+	for i := 1; i < keys; i += 11 {
+		key := fmt.Sprintf("key-%05d", i)
+		mustSet(t, db, key, "overwritten")
+		want[key] = "overwritten"
+	}
+
+	path := filepath.Join(dir, "snapshot.bin")
+	err := db.Snapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restoreDir := filepath.Join(dir, "restored")
+	restored := openTestDatabase(t, restoreDir)
+	err = restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, restored, want)
+
+	// The restored keys were logged, so they survive a restart
+	restored = reopenTestDatabase(t, restored, restoreDir)
+	checkContents(t, restored, want)
+}
+
+func TestLoadSnapshotRejectsCorruptRecords(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMemoryDatabase()
+	for i := 0; i < 100; i++ {
+		mustSet(t, db, fmt.Sprintf("key-%03d", i), "value")
+	}
+	path := filepath.Join(dir, "snapshot.bin")
+	err := db.Snapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMemoryDatabase()
+	err = restored.LoadSnapshot(path)
+	if err == nil {
+		t.Fatal("loaded a corrupt snapshot")
+	}
+	if n := restored.Len(); n != 0 {
+		t.Fatalf("failed load left %d keys behind", n)
+	}
+
+	// The snapshot is left as it was
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(data) {
+		t.Fatalf("snapshot is %d bytes after the load, was %d", len(after), len(data))
+	}
+}
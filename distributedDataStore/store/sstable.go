@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"personalMonorepo/distributedDataStore/contract"
+	"sort"
+)
+
+// ssTableIndexInterval is how many records of an SSTable each index entry
+// covers, so a lookup reads at most that many records.
+const ssTableIndexInterval = 16
+
+// ssTable is an immutable file holding INSERT and DELETE records sorted by
+// key, one per key, written in the log file format. Only a sparse index and a
+// bloom filter of its keys are kept in memory, the records are read from the
+// file on lookup.
+type ssTable struct {
+	path string
+	file *os.File
+	// header describes the format of the records of file, which start at
+	// start and end at size
+	header logHeader
+	start  int64
+	size   int64
+	// index holds the key and offset of every ssTableIndexInterval-th record
+	index []ssTableIndexEntry
+	bloom *bloomFilter
+}
+
+type ssTableIndexEntry struct {
+	key    string
+	offset int64
+}
+
+// openSSTable opens the SSTable at path and reads its keys once to build its
+// index and filter. Tables are synced before they're renamed into place, so a
+// corrupt record is an error rather than something to skip.
+func openSSTable(enc *recordEncoder, path string) (*ssTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	header, start, err := readLogHeader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	t := &ssTable{path: path, file: file, header: header, start: start, size: info.Size()}
+	var keys []string
+	err = t.scan(enc, 0, func(entry *contract.LogEntry, offset int64) bool {
+		if len(keys)%ssTableIndexInterval == 0 {
+			t.index = append(t.index, ssTableIndexEntry{key: entry.Key, offset: offset})
+		}
+		keys = append(keys, entry.Key)
+		return true
+	})
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	t.bloom = newBloomFilter(len(keys))
+	for _, key := range keys {
+		t.bloom.add(key)
+	}
+	return t, nil
+}
+
+// get returns the record of key, false if the table has none.
+func (t *ssTable) get(enc *recordEncoder, key string) (*contract.LogEntry, bool, error) {
+	if !t.bloom.mayContain(key) {
+		return nil, false, nil
+	}
+
+	// The last index entry at or before key starts the run of records key
+	// would be in
+	i := sort.Search(len(t.index), func(i int) bool { return t.index[i].key > key }) - 1
+	if i < 0 {
+		return nil, false, nil
+	}
+
+	var found *contract.LogEntry
+	read := 0
+	err := t.scan(enc, t.index[i].offset, func(entry *contract.LogEntry, _ int64) bool {
+		if entry.Key == key {
+			found = entry
+		}
+		read++
+		return entry.Key < key && read < ssTableIndexInterval
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", t.path, err)
+	}
+	return found, found != nil, nil
+}
+
+// scan calls fn with each record from offset on and its offset, until fn
+// returns false or the table ends. Offset 0 starts at the first record.
+func (t *ssTable) scan(enc *recordEncoder, offset int64, fn func(entry *contract.LogEntry, offset int64) bool) error {
+	if offset < t.start {
+		offset = t.start
+	}
+
+	for {
+		payload, next, err := readRecord(t.file, offset, t.size, t.header.version)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := &contract.LogEntry{}
+		err = enc.decodeLogEntry(t.header, payload, entry)
+		if err != nil {
+			return err
+		}
+		if !fn(entry, offset) {
+			return nil
+		}
+		offset = next
+	}
+}
+
+func (t *ssTable) close() error {
+	return t.file.Close()
+}
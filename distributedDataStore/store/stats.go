@@ -0,0 +1,53 @@
+package store
+
+import "time"
+
+// Stats is a snapshot of the state of a database, see Database.Stats.
+type Stats struct {
+	// Keys is the number of keys held in memory and ValueBytes the total
+	// size of their values. Expired keys count until they're swept.
+	Keys       int
+	ValueBytes int64
+	// LogFileSize is the size of the active log file and Segments the number
+	// of rotated segments next to it, both zero without a log file
+	LogFileSize int64
+	Segments    int
+	// Buffered is the number of records written since the last sync, which a
+	// crash could still lose
+	Buffered int
+	// LastSync is when the log was last synced, zero if it never was
+	LastSync time.Time
+}
+
+// Stats returns a snapshot of the state of the database. It takes each lock
+// in turn rather than all of them at once, so it doesn't hold up writes, but
+// the figures may be slightly out of step under concurrent writes. A segment
+// count that can't be listed is left at zero.
+func (db *Database) Stats() Stats {
+	var stats Stats
+	for _, s := range db.shards {
+		s.mu.RLock()
+		stats.Keys += len(s.data)
+		stats.ValueBytes += s.valueBytes
+		s.mu.RUnlock()
+	}
+
+	db.logFileLock.Lock()
+	stats.Buffered = len(db.writeAhead)
+	stats.LastSync = db.lastSync
+	store, ok := db.store.(*FileLogStore)
+	if ok {
+		stats.LogFileSize = store.size
+	}
+	db.logFileLock.Unlock()
+
+	if ok {
+		segments, err := discoverSegments(db.logFile)
+		if err == nil && len(segments) > 0 {
+			// The last one is the active file
+			stats.Segments = len(segments) - 1
+		}
+	}
+
+	return stats
+}
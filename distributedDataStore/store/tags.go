@@ -0,0 +1,70 @@
+package store
+
+import "sort"
+
+// tagIndex maps the metadata of the keys of a shard back to the keys: every
+// name to every value it's given to the keys with that pair.
+type tagIndex map[string]map[string]map[string]struct{}
+
+func (idx tagIndex) add(key string, metadata map[string]string) {
+	for name, value := range metadata {
+		values, ok := idx[name]
+		if !ok {
+			values = make(map[string]map[string]struct{})
+			idx[name] = values
+		}
+		keys, ok := values[value]
+		if !ok {
+			keys = make(map[string]struct{})
+			values[value] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (idx tagIndex) remove(key string, metadata map[string]string) {
+	for name, value := range metadata {
+		keys := idx[name][value]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(idx[name], value)
+		}
+		if len(idx[name]) == 0 {
+			delete(idx, name)
+		}
+	}
+}
+
+// SetTagged is SetWithMeta for tags: the tags are the metadata of the value,
+// and QueryByTag finds the keys by them. Every metadata pair is a tag, whether
+// it was set here or by SetWithMeta, and like metadata the tags go with the
+// value, a later write of the key without them untags it.
+func (db *Database) SetTagged(key string, value []byte, tags map[string]string) error {
+	return db.SetWithMeta(key, value, tags)
+}
+
+// QueryByTag returns the live keys tagged name=value, sorted. The index behind
+// it is kept in memory along with the metadata, updated by every write and
+// rebuilt by replay from the metadata in the log records, so it holds across
+// compaction, restarts and followers. The result is a consistent snapshot
+// taken under the read lock of every shard.
+func (db *Database) QueryByTag(name, value string) ([]string, error) {
+	rLockShards(db.shards)
+	defer rUnlockShards(db.shards)
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	now := db.clock.Now().UnixNano()
+	result := make([]string, 0)
+	for _, s := range db.shards {
+		for key := range s.tags[name][value] {
+			if !s.expiredAt(key, now) {
+				result = append(result, key)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"personalMonorepo/distributedDataStore/contract"
+	"sync"
+)
+
+// LogReader streams the records of the log from an offset on, following the
+// log as it grows and rotates, see TailFrom. It isn't safe for concurrent use,
+// except for Close, which may be called while Next is waiting.
+type LogReader struct {
+	db    *Database
+	store *FileLogStore
+
+	file   *os.File
+	info   os.FileInfo
+	header logHeader
+	// base is the log offset file starts at, offset the file offset of the
+	// next record in it
+	base   int64
+	offset int64
+	// sealed is set once file was rotated, from then on it's read to its end.
+	// Until then generation is the rotation count of the store it was read
+	// under.
+	sealed     bool
+	generation uint64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// TailFrom returns a reader streaming the records of the log from offset on:
+// first the ones already in the log, then new ones as they become durable, so
+// under SyncEvery and SyncNone they show up with the flush that syncs them.
+// The reader moves on to the next segment when the log rotates.
+//
+// Offsets count bytes across the whole log, its segments laid end to end
+// oldest first, so they stay valid across rotations. Zero is the start of the
+// log, and every record comes with the offset right after it, which a
+// consumer can store and later resume from. Compaction rewrites the segments
+// it seals, which shifts the offsets of everything after them.
+//
+// The log file must be open, a database with another LogStore returns
+// ErrNotSupported.
+func (db *Database) TailFrom(offset int64) (*LogReader, error) {
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	store, ok := db.store.(*FileLogStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	segments, err := discoverSegments(db.logFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var base int64
+	for i, segment := range segments {
+		active := i == len(segments)-1
+		if active {
+			if offset > base+store.synced {
+				return nil, fmt.Errorf("offset %d is past the end of the log at %d", offset, base+store.synced)
+			}
+		} else {
+			info, err := os.Stat(segment)
+			if err != nil {
+				return nil, err
+			}
+			if offset >= base+info.Size() {
+				base += info.Size()
+				continue
+			}
+		}
+
+		r := &LogReader{
+			db:         db,
+			store:      store,
+			base:       base,
+			sealed:     !active,
+			generation: store.rotations,
+			closed:     make(chan struct{}),
+		}
+		err := r.open(segment, offset-base)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	// discoverSegments always returns the active file of an open log
+	return nil, fmt.Errorf("log file %s not found", db.logFile)
+}
+
+// Next returns the next record of the log along with the offset right after
+// it, waiting for one to be written if the reader has caught up. It returns
+// ErrClosed once the database or its log is closed, and io.EOF once the
+// reader is. Batch markers are returned like any other record.
+func (r *LogReader) Next() (*contract.LogEntry, int64, error) {
+	return r.NextContext(context.Background())
+}
+
+// NextContext is Next, giving up waiting with the error of ctx once it's done.
+func (r *LogReader) NextContext(ctx context.Context) (*contract.LogEntry, int64, error) {
+	db := r.db
+	for {
+		select {
+		case <-r.closed:
+			return nil, 0, io.EOF
+		default:
+		}
+
+		limit, appended, err := r.limit()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if r.sealed || r.offset < limit {
+			if r.sealed {
+				limit = r.info.Size()
+			}
+			payload, next, err := readRecord(r.file, r.offset, limit, r.header.version)
+			if err == ErrCorruptRecord && !db.strictReplay {
+				db.logger.Sugar().Warnf("Skipping corrupt record at offset %d of %s", r.offset, r.info.Name())
+				r.offset = next
+				continue
+			}
+			if err == io.EOF && r.sealed {
+				err = r.nextSegment()
+				if err != nil {
+					return nil, 0, err
+				}
+				continue
+			}
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s at offset %d: %w", r.info.Name(), r.offset, err)
+			}
+			r.offset = next
+
+			entry := &contract.LogEntry{}
+			err = db.decodeLogEntry(r.header, payload, entry)
+			if err != nil {
+				return nil, 0, err
+			}
+			return entry, r.base + r.offset, nil
+		}
+
+		select {
+		case <-appended:
+		case <-r.closed:
+			return nil, 0, io.EOF
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// Close closes the reader, making a waiting Next return io.EOF.
+func (r *LogReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		err = r.file.Close()
+	})
+	return err
+}
+
+// limit returns the file offset up to which the current file can be read and
+// a channel closed once that changes. A file that was rotated meanwhile is
+// marked sealed.
+func (r *LogReader) limit() (int64, <-chan struct{}, error) {
+	db := r.db
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.closed || db.store != r.store {
+		return 0, nil, ErrClosed
+	}
+	if r.sealed {
+		return 0, nil, nil
+	}
+	if r.store.rotations != r.generation {
+		// Rotation synced the file before sealing it, all of it can be read
+		info, err := r.file.Stat()
+		if err != nil {
+			return 0, nil, err
+		}
+		r.info, r.sealed = info, true
+		return 0, nil, nil
+	}
+	return r.store.synced, r.store.appended, nil
+}
+
+// nextSegment moves the reader on to the segment written after the sealed one
+// it has read to the end.
+func (r *LogReader) nextSegment() error {
+	db := r.db
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.closed || db.store != r.store {
+		return ErrClosed
+	}
+
+	// Stat the open file again, it has grown since it was opened
+	current, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	segments, err := discoverSegments(db.logFile)
+	if err != nil {
+		return err
+	}
+
+	// The file was renamed when it was sealed, look it up among the segments
+	// by identity
+	for i, segment := range segments {
+		info, err := os.Stat(segment)
+		if os.IsNotExist(err) {
+			// Removed by a compaction since it was listed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(info, current) || i == len(segments)-1 {
+			continue
+		}
+
+		_ = r.file.Close()
+		r.base += current.Size()
+		r.sealed = i+1 < len(segments)-1
+		r.generation = r.store.rotations
+		return r.open(segments[i+1], 0)
+	}
+
+	return fmt.Errorf("%w: %s", ErrSegmentRemoved, r.info.Name())
+}
+
+// open opens the log file at path and positions the reader at the first
+// record at or after the file offset start.
+func (r *LogReader) open(path string, start int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	header, offset, err := readLogHeader(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if start > offset {
+		offset = start
+	}
+
+	r.file, r.info, r.header, r.offset = file, info, header, offset
+	return nil
+}
@@ -0,0 +1,354 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"personalMonorepo/distributedDataStore/contract"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TieredStore is a first cut at LSM-style storage for datasets that don't fit
+// in memory, which Database needs them to. Writes go to a write-ahead log and
+// an in-memory memtable. Once the memtable holds more than memtableSize bytes
+// of keys and values it's flushed to an immutable SSTable sorted by key, the
+// log is truncated and a fresh memtable starts. Get looks in the memtable and
+// then in the SSTables, newest first, and only keeps a sparse index and a
+// bloom filter of each in memory.
+//
+// It only supports Set, Get and Delete for now. SSTables are never merged, so
+// deleted and overwritten keys keep taking up space in older ones, and the
+// memtable is flushed inline by the write that fills it.
+type TieredStore struct {
+	mu           sync.RWMutex
+	dir          string
+	name         string
+	memtableSize int64
+	// enc encodes and decodes records with the codec, compression and
+	// encryption options the store was opened with, and limits bounds its
+	// writes
+	enc          recordEncoder
+	limits       keyLimits
+	strictReplay bool
+	clock        Clock
+	wal          *FileLogStore
+	// memtable holds the writes since the last flush, DELETE records being
+	// tombstones shadowing older values in the SSTables. memtableBytes is the
+	// size of their keys and values.
+	memtable      map[string]*contract.LogEntry
+	memtableBytes int64
+	// tables holds the SSTables, oldest first, and nextTable numbers the
+	// next one
+	tables    []*ssTable
+	nextTable int
+	closed    bool
+	logger    *zap.Logger
+}
+
+// OpenTieredStore opens the tiered store called name in dir, creating dir if
+// needed, and replays its write-ahead log into the memtable. The log is kept
+// in <name>.wal and the SSTables in <name>.sst_<number>. Of the options, only
+// those about the log format, write limits and logging apply.
+func OpenTieredStore(dir, name string, memtableSize int64, opts ...Option) (*TieredStore, error) {
+	if name == "" || filepath.Base(name) != name {
+		return nil, fmt.Errorf("invalid store name %q, it must be a plain file name", name)
+	}
+	if memtableSize <= 0 {
+		return nil, fmt.Errorf("memtable size must be positive, got %d", memtableSize)
+	}
+
+	// The options are written for a Database, collect them on one that's
+	// never opened and keep the settings that apply
+	cfg := &Database{recordEncoder: recordEncoder{codec: ProtoCodec}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.logger == nil {
+		cfg.logger = zap.L()
+	}
+	if cfg.clock == nil {
+		cfg.clock = RealClock{}
+	}
+
+	t := &TieredStore{
+		dir:          dir,
+		name:         name,
+		memtableSize: memtableSize,
+		enc:          cfg.recordEncoder,
+		limits:       cfg.keyLimits,
+		strictReplay: cfg.strictReplay,
+		clock:        cfg.clock,
+		memtable:     make(map[string]*contract.LogEntry),
+		nextTable:    1,
+		logger:       cfg.logger,
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.openTables()
+	if err != nil {
+		_ = t.closeTables()
+		return nil, err
+	}
+
+	err = t.replayWAL()
+	if err != nil {
+		_ = t.closeTables()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// ssTableSuffix matches what follows <name>.sst_ in the name of an SSTable
+var ssTableSuffix = regexp.MustCompile(`^\d{8}$`)
+
+// openTables opens the SSTables of the store, oldest first.
+func (t *TieredStore) openTables() error {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return err
+	}
+
+	prefix := t.name + ".sst_"
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		// Tables still being written when we stopped end in .tmp and don't
+		// match
+		if strings.HasPrefix(name, prefix) && ssTableSuffix.MatchString(name[len(prefix):]) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table, err := openSSTable(&t.enc, filepath.Join(t.dir, name))
+		if err != nil {
+			return err
+		}
+		t.tables = append(t.tables, table)
+
+		number, _ := strconv.Atoi(name[len(prefix):])
+		t.nextTable = number + 1
+	}
+	return nil
+}
+
+// replayWAL opens the write-ahead log and loads its records into the
+// memtable. Records already flushed to an SSTable by a flush that crashed
+// before truncating the log are loaded again, which shadows them with the same
+// values.
+func (t *TieredStore) replayWAL() error {
+	sugar := t.logger.Sugar()
+	start := time.Now()
+
+	// The memtable bounds the log, it's never rotated by size
+	wal, err := openFileLogStore(filepath.Join(t.dir, t.name+".wal"), math.MaxInt64, t.enc.codec, t.strictReplay, t.clock, t.logger)
+	if err != nil {
+		return err
+	}
+	wal.reencode = t.enc.reencode
+
+	records := 0
+	err = wal.ReadAll(func(record []byte) error {
+		entry := &contract.LogEntry{}
+		err := t.enc.decodeLogEntry(t.enc.currentHeader(), record, entry)
+		if err != nil {
+			return err
+		}
+		if entry.Op != INSERT && entry.Op != DELETE {
+			return fmt.Errorf("%w %d in tiered store log", ErrUnknownOp, entry.Op)
+		}
+		t.apply(entry)
+		records++
+		return nil
+	})
+	if err != nil {
+		_ = wal.Close()
+		return err
+	}
+
+	t.wal = wal
+	sugar.Infof("Replayed %d records into the memtable of %s in %s, %d SSTables", records, t.name, time.Since(start), len(t.tables))
+	return nil
+}
+
+// Set sets key to value.
+func (t *TieredStore) Set(key string, value []byte) error {
+	return t.write(&contract.LogEntry{Op: INSERT, Key: key, Value: value, Timestamp: t.clock.Now().UnixNano()})
+}
+
+// Delete deletes key. Deleting a missing key succeeds, and still logs a
+// tombstone, since telling whether an SSTable holds the key would take a
+// lookup.
+func (t *TieredStore) Delete(key string) error {
+	return t.write(&contract.LogEntry{Op: DELETE, Key: key, Timestamp: t.clock.Now().UnixNano()})
+}
+
+// write logs entry, applies it to the memtable and flushes the memtable if
+// it's full.
+func (t *TieredStore) write(entry *contract.LogEntry) error {
+	err := t.limits.checkLimits(entry.Key, entry.Value)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+
+	record, err := t.enc.encodeEntry(t.enc.currentHeader(), entry)
+	if err != nil {
+		return err
+	}
+	err = t.wal.Append(record)
+	if err != nil {
+		return err
+	}
+
+	// The caller may reuse value once we return
+	if entry.Value != nil {
+		entry.Value = append([]byte(nil), entry.Value...)
+	}
+	t.apply(entry)
+
+	if t.memtableBytes > t.memtableSize {
+		return t.flush()
+	}
+	return nil
+}
+
+// apply records entry in the memtable. Callers must hold the write lock.
+func (t *TieredStore) apply(entry *contract.LogEntry) {
+	if old, ok := t.memtable[entry.Key]; ok {
+		t.memtableBytes -= int64(len(old.Key) + len(old.Value))
+	}
+	t.memtable[entry.Key] = entry
+	t.memtableBytes += int64(len(entry.Key) + len(entry.Value))
+}
+
+// flush writes the memtable to a new SSTable and starts a fresh memtable and
+// log. The table is synced and renamed into place before the log is
+// truncated, so a crash in between only replays the log over the same table.
+// Callers must hold the write lock.
+func (t *TieredStore) flush() error {
+	sugar := t.logger.Sugar()
+	start := time.Now()
+
+	entries := make([]*contract.LogEntry, 0, len(t.memtable))
+	for _, entry := range t.memtable {
+		// Tombstones only shadow older tables, the first one doesn't need
+		// them
+		if entry.Op == DELETE && len(t.tables) == 0 {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	path := filepath.Join(t.dir, fmt.Sprintf("%s.sst_%08d", t.name, t.nextTable))
+	err := t.enc.writeSegment(path, entries)
+	if err != nil {
+		return err
+	}
+	table, err := openSSTable(&t.enc, path)
+	if err != nil {
+		return err
+	}
+	t.tables = append(t.tables, table)
+	t.nextTable++
+
+	err = t.wal.truncate()
+	if err != nil {
+		return err
+	}
+	t.memtable = make(map[string]*contract.LogEntry)
+	t.memtableBytes = 0
+
+	sugar.Infof("Flushed memtable to %s with %d records in %s", path, len(entries), time.Since(start))
+	return nil
+}
+
+// Get returns the value of key, from the memtable or else from the newest
+// SSTable holding it.
+func (t *TieredStore) Get(key string) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.closed {
+		return nil, ErrClosed
+	}
+
+	entry, ok := t.memtable[key]
+	for i := len(t.tables) - 1; !ok && i >= 0; i-- {
+		var err error
+		entry, ok, err = t.tables[i].get(&t.enc, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !ok || entry.Op == DELETE {
+		return nil, ErrKeyNotFound
+	}
+
+	copied := make([]byte, len(entry.Value))
+	copy(copied, entry.Value)
+	return copied, nil
+}
+
+// Sync makes every write so far durable.
+func (t *TieredStore) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+	return t.wal.Sync()
+}
+
+// Close syncs the log and closes the store, later operations fail with
+// ErrClosed. Closing an already closed store is a no-op.
+func (t *TieredStore) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	err := t.wal.Sync()
+	if closeErr := t.wal.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := t.closeTables(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (t *TieredStore) closeTables() error {
+	var err error
+	for _, table := range t.tables {
+		if closeErr := table.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"os"
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// Truncate deletes every key and, for the FileLogStore, every file of the log,
+// leaving a fresh empty log file behind. It holds every shard lock throughout,
+// so readers see either the full state before it or the empty state after it.
+//
+// A batch of DELETE records for the live keys is logged and synced before any
+// file is removed, then the checkpoint and the segments go oldest first and
+// the active file last. A crash at any point leaves either the old log or a
+// suffix of it followed by the deletes, which both replay to the state they
+// should. Watchers and followers see the deletes like any other, LogReaders
+// fail with ErrSegmentRemoved once their segment is gone. Other LogStores keep
+// their records, followed by the deletes.
+func (db *Database) Truncate() (err error) {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	sugar := db.logger.Sugar()
+
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	// Deferred before taking the shard locks, so it waits for the followers
+	// once they're released, like Set
+	var leader *ReplicationLeader
+	var seq uint64
+	defer func() {
+		if err == nil {
+			err = leader.awaitAcks(seq)
+		}
+	}()
+
+	lockShards(db.shards)
+	defer unlockShards(db.shards)
+	db.logFileLock.Lock()
+	defer db.logFileLock.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	// Every write holds its shard lock until it's logged, so nothing is
+	// queued for the group committer and the log can be written directly
+	now := db.writeTimestamp()
+	logEntries := []*contract.LogEntry{{Op: BATCH_BEGIN}}
+	for _, s := range db.shards {
+		for key := range s.data {
+			logEntries = append(logEntries, &contract.LogEntry{Op: DELETE, Key: key, Timestamp: now})
+		}
+	}
+	logEntries = append(logEntries, &contract.LogEntry{Op: BATCH_COMMIT})
+	keys := len(logEntries) - 2
+
+	if keys > 0 {
+		err := db.appendLogFile(logEntries...)
+		if err != nil {
+			return err
+		}
+		if db.leader != nil {
+			db.leader.append(logEntries)
+			leader, seq = db.leader, db.leader.sequence()
+		}
+	}
+	err = db.flush()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range db.shards {
+		s.reset()
+	}
+
+	if store, ok := db.store.(*FileLogStore); ok {
+		err = os.Remove(db.checkpointFile())
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = store.truncate()
+		if err != nil {
+			return err
+		}
+	}
+
+	sugar.Infof("Truncated database %s, deleting %d keys", db.name, keys)
+	return nil
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestTruncateUnderConcurrentReads(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDatabase(t, dir)
+	const keys = 500
+	for i := 0; i < keys; i++ {
+		mustSet(t, db, fmt.Sprintf("key-%03d", i), fmt.Sprintf("value-%d", i))
+	}
+	rotateTestLog(t, db)
+
+	done := make(chan struct{})
+	var started, wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				entries, err := db.Scan("key-")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// Either every key before the truncate or none of them
+				if len(entries) != 0 && len(entries) != keys {
+					t.Errorf("scan saw %d keys, want %d or 0", len(entries), keys)
+					return
+				}
+				if n := db.Len(); n != 0 && n != keys {
+					t.Errorf("Len is %d, want %d or 0", n, keys)
+					return
+				}
+			}
+		}()
+	}
+
+	// Truncate once every reader has started
+	started.Wait()
+	err := db.Truncate()
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, db, map[string]string{})
+	if names := segmentNames(t, dir); len(names) != 0 {
+		t.Fatalf("segments %v left behind after the truncate", names)
+	}
+
+	// The fresh log takes writes and replays without the old keys
+	mustSet(t, db, "after", "value")
+	db = reopenTestDatabase(t, db, dir)
+	checkContents(t, db, map[string]string{"after": "value"})
+}
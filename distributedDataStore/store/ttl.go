@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// WithSweepInterval sets how often expired keys are evicted and logged as
+// deleted, once a second by default.
+func WithSweepInterval(interval time.Duration) Option {
+	return func(db *Database) {
+		db.sweepInterval = interval
+	}
+}
+
+// SetWithTTL sets key to value and makes it expire after ttl. Expired keys read
+// as absent straight away, and are deleted from the log by the background
+// sweeper shortly after.
+func (db *Database) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %s", ttl)
+	}
+
+	_, err := db.set(context.Background(), key, value, db.clock.Now().Add(ttl).UnixNano(), nil)
+	return err
+}
+
+// lookup returns the value of key unless it's missing or expired. Callers must
+// hold the shard lock.
+func (s *shard) lookup(key string) ([]byte, bool) {
+	if !s.bloom.mayContain(key) {
+		return nil, false
+	}
+	value, ok := s.data[key]
+	if !ok || s.expiredAt(key, s.clock.Now().UnixNano()) {
+		return nil, false
+	}
+	return value, true
+}
+
+// expiredAt reports whether key has a TTL that has passed by now. Callers must
+// hold the shard lock.
+func (s *shard) expiredAt(key string, now int64) bool {
+	expiresAt, ok := s.expiry[key]
+	return ok && expiresAt <= now
+}
+
+// setExpiry records the expiry of key, 0 clears it. Callers must hold the
+// shard lock for writing.
+func (s *shard) setExpiry(key string, expiresAt int64) {
+	s.unshare()
+	if old, ok := s.expiry[key]; ok {
+		s.unindexExpiry(old)
+	}
+	if expiresAt == 0 {
+		delete(s.expiry, key)
+		return
+	}
+	s.expiry[key] = expiresAt
+	i := sort.Search(len(s.expiries), func(i int) bool { return s.expiries[i] >= expiresAt })
+	s.expiries = append(s.expiries, 0)
+	copy(s.expiries[i+1:], s.expiries[i:])
+	s.expiries[i] = expiresAt
+}
+
+// unindexExpiry takes one occurrence of expiresAt out of expiries. Callers must
+// hold the shard lock for writing.
+func (s *shard) unindexExpiry(expiresAt int64) {
+	i := sort.Search(len(s.expiries), func(i int) bool { return s.expiries[i] >= expiresAt })
+	if i < len(s.expiries) && s.expiries[i] == expiresAt {
+		s.expiries = append(s.expiries[:i], s.expiries[i+1:]...)
+	}
+}
+
+// liveCount returns the number of keys of the shard that haven't expired by
+// now. Callers must hold the shard lock.
+func (s *shard) liveCount(now int64) int {
+	expired := sort.Search(len(s.expiries), func(i int) bool { return s.expiries[i] > now })
+	return len(s.data) - expired
+}
+
+// sweepExpired periodically evicts expired keys and writes DELETE records for
+// them so the log agrees with what readers see. It stops when the database is
+// closed.
+func (db *Database) sweepExpired() {
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-db.clock.After(db.sweepInterval):
+			err := db.evictExpired()
+			if err != nil {
+				db.logger.Sugar().Errorf("Failed to evict expired keys: %v", err)
+			}
+		}
+	}
+}
+
+func (db *Database) evictExpired() error {
+	if !db.freezeLock.TryRLock() {
+		// Frozen, expired keys read as missing until the next sweep anyway
+		return nil
+	}
+	defer db.freezeLock.RUnlock()
+
+	if db.partialReplay.Load() && !db.partialAccepted.Load() {
+		// Writes are turned away until the partial replay is accepted,
+		// the deletes would be too
+		return nil
+	}
+
+	for _, s := range db.shards {
+		err := db.evictExpiredShard(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evictExpiredShard evicts the expired keys of a single shard, so the sweep
+// never holds more than one shard lock at a time.
+func (db *Database) evictExpiredShard(s *shard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db.closed || db.partialReplay.Load() && !db.partialAccepted.Load() {
+		return nil
+	}
+
+	// The sweep also drops durable state that's no longer needed
+	s.prunePending(db.syncedSeq.Load())
+
+	now := db.clock.Now().UnixNano()
+	var logEntries []*contract.LogEntry
+	for key, expiresAt := range s.expiry {
+		if expiresAt > now {
+			continue
+		}
+		logEntries = append(logEntries, &contract.LogEntry{
+			Op:        DELETE,
+			Key:       key,
+			Timestamp: db.writeTimestamp(),
+		})
+	}
+
+	if len(logEntries) == 0 {
+		return nil
+	}
+
+	err := db.writeLogEntries(logEntries...)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range logEntries {
+		s.remove(entry.Key)
+	}
+	return nil
+}
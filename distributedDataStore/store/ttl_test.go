@@ -0,0 +1,42 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSweepWaitsForPartialReplayToBeAccepted(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	db := openTestDatabase(t, t.TempDir(), WithClock(clock))
+	err := db.SetWithTTL("key", []byte("value"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	evicted := func() bool {
+		s := db.shardFor("key")
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, ok := s.data["key"]
+		return !ok
+	}
+
+	// The replay stopped short, the sweep leaves the keyspace alone
+	db.partialReplay.Store(true)
+	err = db.evictExpired()
+	if err != nil {
+		t.Fatalf("sweep during a partial replay returned %v", err)
+	}
+	if evicted() {
+		t.Fatal("sweep evicted a key during a partial replay")
+	}
+
+	db.partialAccepted.Store(true)
+	err = db.evictExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !evicted() {
+		t.Fatal("sweep didn't evict the expired key once the partial replay was accepted")
+	}
+}
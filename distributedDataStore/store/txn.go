@@ -0,0 +1,143 @@
+package store
+
+// Txn is an optimistic read-write transaction, see Database.Txn.
+type Txn struct {
+	db *Database
+	// reads maps every key read to its version at the time of the first
+	// read, 0 if it was missing
+	reads map[string]uint64
+	// writes stages the writes of the transaction, staged holds their latest
+	// value per key so the transaction reads its own writes
+	writes  WriteBatch
+	staged  map[string][]byte
+	deleted map[string]bool
+}
+
+// Txn runs fn in a transaction and commits the writes it staged if fn returns
+// nil. The commit only goes through if none of the keys read by fn were
+// written since, otherwise nothing is written and Txn returns ErrConflict.
+// Transactions are serializable: a committed transaction behaves as if it had
+// run on its own at the moment of its commit.
+//
+// fn may be called again by the caller after a conflict, so it shouldn't have
+// side effects outside the transaction. An error returned by fn aborts the
+// transaction and is returned as is.
+func (db *Database) Txn(fn func(tx *Txn) error) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	tx := &Txn{
+		db:      db,
+		reads:   make(map[string]uint64),
+		writes:  WriteBatch{db: db},
+		staged:  make(map[string][]byte),
+		deleted: make(map[string]bool),
+	}
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// Get returns a copy of the value of key as seen by the transaction, or
+// ErrKeyNotFound if it isn't set. Keys written by the transaction read as
+// written.
+func (tx *Txn) Get(key string) ([]byte, error) {
+	if tx.deleted[key] {
+		return nil, ErrKeyNotFound
+	}
+	if value, ok := tx.staged[key]; ok {
+		copied := make([]byte, len(value))
+		copy(copied, value)
+		return copied, nil
+	}
+
+	db := tx.db
+	s := db.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	value, ok := s.lookup(key)
+	if _, seen := tx.reads[key]; !seen {
+		tx.reads[key] = s.version(key)
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied, nil
+}
+
+// Set stages setting key to value.
+func (tx *Txn) Set(key string, value []byte) {
+	tx.writes.Set(key, value)
+	tx.staged[key] = value
+	delete(tx.deleted, key)
+}
+
+// Delete stages deleting key.
+func (tx *Txn) Delete(key string) {
+	tx.writes.Delete(key)
+	delete(tx.staged, key)
+	tx.deleted[key] = true
+}
+
+// commit validates the read set and commits the staged writes as a batch,
+// with the shards of every key read or written locked for writing throughout.
+// A transaction that only reads is validated too, so it fails with
+// ErrConflict rather than return reads that never coexisted.
+func (tx *Txn) commit() error {
+	db := tx.db
+
+	err := db.admitEntries(tx.writes.entries)
+	if err != nil {
+		return err
+	}
+	defer db.exitWrite()
+
+	keys := make([]string, 0, len(tx.reads)+tx.writes.Len())
+	for key := range tx.reads {
+		keys = append(keys, key)
+	}
+	for _, entry := range tx.writes.entries {
+		err = db.checkKey(entry.Key)
+		if err == nil && entry.Op != DELETE {
+			err = db.checkLimits(entry.Key, entry.Value)
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, entry.Key)
+	}
+	shards := db.shardsOf(keys)
+	lockShards(shards)
+	if db.closed {
+		unlockShards(shards)
+		return ErrClosed
+	}
+
+	for key, version := range tx.reads {
+		if db.shardFor(key).version(key) != version {
+			unlockShards(shards)
+			return ErrConflict
+		}
+	}
+
+	err = db.commitBatchLocked(tx.writes.entries)
+	leader, seq := db.replicationPosition()
+	unlockShards(shards)
+	if err != nil {
+		return err
+	}
+
+	return leader.awaitAcks(seq)
+}
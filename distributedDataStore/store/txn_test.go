@@ -0,0 +1,125 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// txnInt reads the integer stored at key in tx, 0 if it's missing.
+func txnInt(tx *Txn, key string) (int, error) {
+	value, err := tx.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(value))
+}
+
+func TestTxnRetriesUnderContention(t *testing.T) {
+	db := openTestDatabase(t, t.TempDir())
+	const accounts, workers, transfers = 4, 8, 100
+	for i := 0; i < accounts; i++ {
+		mustSet(t, db, fmt.Sprintf("account-%d", i), "100")
+	}
+
+	var conflicts atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < transfers; i++ {
+				from := fmt.Sprintf("account-%d", (w+i)%accounts)
+				to := fmt.Sprintf("account-%d", (w+i+1)%accounts)
+				for {
+					err := db.Txn(func(tx *Txn) error {
+						a, err := txnInt(tx, from)
+						if err != nil {
+							return err
+						}
+						b, err := txnInt(tx, to)
+						if err != nil {
+							return err
+						}
+						// Let other transfers in between the reads and
+						// the commit
+						runtime.Gosched()
+						tx.Set(from, []byte(strconv.Itoa(a-1)))
+						tx.Set(to, []byte(strconv.Itoa(b+1)))
+						return nil
+					})
+					if errors.Is(err, ErrConflict) {
+						conflicts.Add(1)
+						continue
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					break
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every transfer committed exactly once, so the total is unchanged
+	total := 0
+	err := db.Txn(func(tx *Txn) error {
+		for i := 0; i < accounts; i++ {
+			n, err := txnInt(tx, fmt.Sprintf("account-%d", i))
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != accounts*100 {
+		t.Fatalf("accounts add up to %d after the transfers, want %d", total, accounts*100)
+	}
+	t.Logf("%d conflicts retried", conflicts.Load())
+}
+
+func TestTxnConflictsWithWriteToReadKey(t *testing.T) {
+	db := NewMemoryDatabase()
+	mustSet(t, db, "a", "1")
+
+	attempts := 0
+	fn := func(tx *Txn) error {
+		attempts++
+		value, err := tx.Get("a")
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// Written behind the transaction's back, between its read
+			// and its commit
+			mustSet(t, db, "a", "2")
+		}
+		tx.Set("b", append([]byte("from a="), value...))
+		return nil
+	}
+	err := db.Txn(fn)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+	checkContents(t, db, map[string]string{"a": "2"})
+
+	// The retry reads the new value and commits
+	err = db.Txn(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, db, map[string]string{"a": "2", "b": "from a=2"})
+}
@@ -0,0 +1,38 @@
+package store
+
+// Validation is what Set would do with a key and value, see Validate.
+type Validation struct {
+	// Op is the op of the record Set would log, INSERT or UPDATE
+	Op uint32
+	// NoOp is set when the key already holds the value, in which case Set
+	// writes nothing and Op is meaningless
+	NoOp bool
+}
+
+// Validate runs the checks Set would run on key and value, without writing
+// anything, and returns the error Set would fail with or what it would do
+// otherwise. It's meant for preflighting writes, like the records of a bulk
+// load. A later Set may still do something else if the key is written in
+// between, or fail for reasons only known at write time, like a full log
+// buffer or a failing disk.
+func (db *Database) Validate(key string, value []byte) (Validation, error) {
+	if db.readOnly {
+		return Validation{}, ErrReadOnly
+	}
+
+	err := db.checkLimits(key, value)
+	if err != nil {
+		return Validation{}, err
+	}
+
+	s := db.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db.closed {
+		return Validation{}, ErrClosed
+	}
+
+	op, changed := s.setOp(key, value, 0, nil, !db.noDedup)
+	return Validation{Op: op, NoOp: !changed}, nil
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"personalMonorepo/distributedDataStore/contract"
+)
+
+// CorruptionKind says what's wrong with a damaged part of a log file.
+type CorruptionKind int
+
+const (
+	// CorruptHeader is a file header that can't be read, so none of the
+	// file's records can be.
+	CorruptHeader CorruptionKind = iota
+	// CorruptChecksum is a record whose length or payload fails its
+	// checksum, or whose length runs past the end of the file, while intact
+	// records follow it. Scanning goes on from the next intact record.
+	CorruptChecksum
+	// CorruptPayload is a record passing its checksum whose payload can't be
+	// decoded or carries an op we don't know.
+	CorruptPayload
+	// CorruptTruncated is a record running past the end of the file with
+	// nothing intact after it, usually the last one written before a crash.
+	CorruptTruncated
+)
+
+func (k CorruptionKind) String() string {
+	switch k {
+	case CorruptHeader:
+		return "bad header"
+	case CorruptChecksum:
+		return "checksum mismatch"
+	case CorruptPayload:
+		return "bad payload"
+	case CorruptTruncated:
+		return "truncated record"
+	default:
+		return fmt.Sprintf("CorruptionKind(%d)", int(k))
+	}
+}
+
+// Corruption is a damaged part of a log file found by Verify.
+type Corruption struct {
+	Path   string
+	Offset int64
+	Kind   CorruptionKind
+	Err    error
+	// Repaired is set by Repair on the corruption it truncated the file at
+	Repaired bool
+}
+
+func (c Corruption) String() string {
+	return fmt.Sprintf("%s at offset %d of %s: %v", c.Kind, c.Offset, c.Path, c.Err)
+}
+
+// VerifyReport describes what Verify found in the log.
+type VerifyReport struct {
+	// Files lists the files that were scanned, in order
+	Files []string
+	// Records is the number of intact records and Bytes their size
+	Records int
+	Bytes   int64
+	// Corruptions lists every damaged part found, in log order
+	Corruptions []Corruption
+}
+
+// OK reports whether the log is free of corruption.
+func (r *VerifyReport) OK() bool {
+	return len(r.Corruptions) == 0
+}
+
+// Verify scans the log file at path, or every segment of the database's log
+// if path is empty, and reports the offset and kind of each damaged record
+// without modifying anything. Payloads are decoded with the database's
+// options, so a log written with encryption needs the same key to verify.
+//
+// The returned error is only set when a file can't be read at all, corruption
+// is reported in the VerifyReport.
+func (db *Database) Verify(path string) (*VerifyReport, error) {
+	return db.verify(path, false)
+}
+
+// Repair is Verify, truncating each file at its first record replay can't get
+// past, so a database that fails to start on a damaged log can be loaded
+// again. The records after that point in the file are lost, the other
+// segments are left as they are. Records failing their checksum are skipped by
+// replay and left in place, unless the database uses WithStrictReplay. A file
+// whose header can't be read is left alone.
+//
+// The log must not be open for writing while it's repaired.
+func (db *Database) Repair(path string) (*VerifyReport, error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	db.logFileLock.Lock()
+	open := db.store != nil
+	db.logFileLock.Unlock()
+	if open {
+		return nil, fmt.Errorf("can't repair %s while it's open, close the log first", db.logFile)
+	}
+
+	return db.verify(path, true)
+}
+
+func (db *Database) verify(path string, repair bool) (*VerifyReport, error) {
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	report := &VerifyReport{}
+	err := db.withLogFiles(func(segments []string) error {
+		if path != "" {
+			segments = []string{path}
+		}
+		for _, path := range segments {
+			err := db.verifyFile(path, repair, report)
+			if err != nil {
+				return err
+			}
+			report.Files = append(report.Files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	sugar := db.logger.Sugar()
+	for _, c := range report.Corruptions {
+		if c.Repaired {
+			sugar.Warnf("Truncated %s at offset %d, dropping a %s", c.Path, c.Offset, c.Kind)
+		}
+	}
+	return report, nil
+}
+
+// verifyFile scans the log file at path into report, truncating it at its
+// first unrecoverable record when repairing.
+func (db *Database) verifyFile(path string, repair bool, report *VerifyReport) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, offset, err := readLogHeader(file)
+	if err != nil {
+		report.Corruptions = append(report.Corruptions, Corruption{Path: path, Kind: CorruptHeader, Err: err})
+		return nil
+	}
+
+	// corrupt records a corruption at offset and reports whether the rest of
+	// the file should still be scanned
+	corrupt := func(kind CorruptionKind, err error) (bool, error) {
+		c := Corruption{Path: path, Offset: offset, Kind: kind, Err: err}
+		recoverable := kind == CorruptChecksum && !db.strictReplay
+		if repair && !recoverable {
+			err := os.Truncate(path, offset)
+			if err != nil {
+				return false, err
+			}
+			c.Repaired = true
+		}
+		report.Corruptions = append(report.Corruptions, c)
+		return kind != CorruptTruncated && !c.Repaired, nil
+	}
+
+	for offset < info.Size() {
+		// A record running past the end is only reported as such when
+		// nothing intact follows it, see readRecord
+		payload, next, err := readRecord(file, offset, info.Size(), header.version)
+		if err == io.EOF {
+			_, err = corrupt(CorruptTruncated, fmt.Errorf("record runs past the end of the file at %d: %w",
+				info.Size(), io.ErrUnexpectedEOF))
+			return err
+		}
+		if err == ErrCorruptRecord {
+			more, err := corrupt(CorruptChecksum, err)
+			if err != nil || !more {
+				return err
+			}
+			offset = next
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", path, offset, err)
+		}
+
+		entry := &contract.LogEntry{}
+		err = db.decodeLogEntry(header, payload, entry)
+		if err == nil && entry.Op > CHECKPOINT {
+			err = fmt.Errorf("%w %d for key %q", ErrUnknownOp, entry.Op, entry.Key)
+		}
+		if err != nil {
+			more, err := corrupt(CorruptPayload, err)
+			if err != nil || !more {
+				return err
+			}
+			offset = next
+			continue
+		}
+
+		report.Records++
+		report.Bytes += next - offset
+		offset = next
+	}
+	return nil
+}
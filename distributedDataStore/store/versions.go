@@ -0,0 +1,100 @@
+package store
+
+import "context"
+
+// Every key carries a version that changes on each write to it. Versions come
+// from a single counter, so they only ever grow and a key deleted and written
+// again never gets an old version back. They're stored in the log records of
+// the writes and survive replay, compaction and replication.
+
+// GetVersion is Get that also returns the version of key.
+func (db *Database) GetVersion(key string) ([]byte, uint64, error) {
+	getsTotal.Inc()
+
+	err := db.checkKey(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s := db.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db.closed {
+		return nil, 0, ErrClosed
+	}
+
+	value, ok := s.lookup(key)
+	if !ok {
+		getMissesTotal.Inc()
+		return nil, 0, ErrKeyNotFound
+	}
+
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied, s.versions[key], nil
+}
+
+// SetIfVersion sets key to value only if its current version is expected, and
+// reports whether it did. An expected version of 0 only matches a missing key.
+// The key keeps its TTL and metadata, if it has any.
+func (db *Database) SetIfVersion(key string, value []byte, expected uint64) (bool, error) {
+	if db.readOnly {
+		return false, ErrReadOnly
+	}
+	err := db.admit(context.Background(), 1, len(key)+len(value))
+	if err != nil {
+		return false, err
+	}
+	defer db.exitWrite()
+
+	s := db.shardFor(key)
+	s.mu.Lock()
+	if db.closed {
+		s.mu.Unlock()
+		return false, ErrClosed
+	}
+
+	if s.version(key) != expected {
+		s.mu.Unlock()
+		return false, nil
+	}
+
+	var expiresAt int64
+	var metadata map[string]string
+	if expected != 0 {
+		expiresAt, metadata = s.expiry[key], s.meta[key].metadata
+	}
+	_, err = db.setLocked(key, value, expiresAt, metadata)
+	leader, seq := db.replicationPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	return true, leader.awaitAcks(seq)
+}
+
+// version returns the version of key, 0 if it's missing or expired. Callers
+// must hold the shard lock.
+func (s *shard) version(key string) uint64 {
+	if _, ok := s.lookup(key); !ok {
+		return 0
+	}
+	return s.versions[key]
+}
+
+// observeVersion returns the version a replayed or replicated record gives its
+// key, making sure the versions handed out later are greater. Records written
+// before keys had versions get a fresh one.
+func (db *Database) observeVersion(version uint64) uint64 {
+	if version == 0 {
+		return db.versionSeq.Add(1)
+	}
+	for {
+		current := db.versionSeq.Load()
+		if current >= version || db.versionSeq.CompareAndSwap(current, version) {
+			return version
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// Snapshot is a read-only view of the database as of a single moment, handed
+// to the function given to View. Every Get and Scan on it sees the same keys,
+// whatever is written meanwhile.
+type Snapshot struct {
+	shards []shardView
+	// now is when the view was taken, keys expiring later stay visible
+	now int64
+}
+
+// shardView is the state of a shard as of a Snapshot. The maps and the index
+// are the shard's own, the shard copies them before its next write instead.
+type shardView struct {
+	data   map[string][]byte
+	keys   []string
+	expiry map[string]int64
+}
+
+// View calls fn with a consistent read-only view of the database and returns
+// what fn returns. Taking the view is cheap: it holds every shard's read lock
+// just long enough to mark the shards shared, and each shard copies its keys
+// on its first write after that, so writers aren't held off for the duration
+// of fn. fn must not keep v after it returns, it pins the memory of the
+// keyspace as of the view.
+func (db *Database) View(fn func(v *Snapshot) error) error {
+	rLockShards(db.shards)
+	if db.closed {
+		rUnlockShards(db.shards)
+		return ErrClosed
+	}
+	v := db.snapshot()
+	rUnlockShards(db.shards)
+
+	return fn(v)
+}
+
+// snapshot marks every shard shared and returns a view of their state. Callers
+// must hold the read lock of every shard.
+func (db *Database) snapshot() *Snapshot {
+	v := &Snapshot{shards: make([]shardView, len(db.shards)), now: db.clock.Now().UnixNano()}
+	for i, s := range db.shards {
+		s.shared.Store(true)
+		v.shards[i] = shardView{data: s.data, keys: s.keys, expiry: s.expiry}
+	}
+	return v
+}
+
+// Get returns a copy of the value key had when the view was taken, or
+// ErrKeyNotFound if it wasn't set.
+func (v *Snapshot) Get(key string) ([]byte, error) {
+	s := v.shards[shardIndex(key, len(v.shards))]
+	value, ok := s.data[key]
+	if !ok || s.expiredAt(key, v.now) {
+		return nil, ErrKeyNotFound
+	}
+
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied, nil
+}
+
+// Scan returns the entries whose key started with prefix when the view was
+// taken, sorted by key. An empty prefix returns every entry.
+func (v *Snapshot) Scan(prefix string) ([]KeyValue, error) {
+	result := make([]KeyValue, 0)
+	for _, s := range v.shards {
+		for i := sort.SearchStrings(s.keys, prefix); i < len(s.keys); i++ {
+			key := s.keys[i]
+			if !strings.HasPrefix(key, prefix) {
+				break
+			}
+			if s.expiredAt(key, v.now) {
+				continue
+			}
+			result = append(result, KeyValue{Key: key, Value: copyValue(s.data[key])})
+		}
+	}
+	sortByKey(result)
+	return result, nil
+}
+
+func (s shardView) expiredAt(key string, now int64) bool {
+	expiresAt, ok := s.expiry[key]
+	return ok && expiresAt <= now
+}
+
+// unshare gives the shard its own copy of the state a Snapshot may still be
+// reading, before it's changed. Callers must hold the shard lock for writing.
+func (s *shard) unshare() {
+	if !s.shared.Load() {
+		return
+	}
+
+	data := make(map[string][]byte, len(s.data))
+	for key, value := range s.data {
+		data[key] = value
+	}
+	expiry := make(map[string]int64, len(s.expiry))
+	for key, expiresAt := range s.expiry {
+		expiry[key] = expiresAt
+	}
+	s.data = data
+	s.keys = append([]string(nil), s.keys...)
+	s.expiry = expiry
+	s.shared.Store(false)
+}
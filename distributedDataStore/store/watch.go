@@ -0,0 +1,195 @@
+package store
+
+import (
+	"personalMonorepo/distributedDataStore/contract"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeEvent describes a write to a watched key. Op is INSERT, UPDATE or
+// DELETE, Value is empty for deletes. Timestamp and Metadata are those of the
+// write, see GetWithMeta.
+type ChangeEvent struct {
+	Op        uint32
+	Key       string
+	Value     []byte
+	Version   uint64
+	Timestamp time.Time
+	Metadata  map[string]string
+}
+
+// WatchPolicy decides what happens to events for a subscriber whose buffer is
+// full.
+type WatchPolicy int
+
+const (
+	// WatchDrop drops events a subscriber has no room for, so a slow
+	// subscriber never holds up writes. It sees a gap in the stream instead.
+	WatchDrop WatchPolicy = iota
+	// WatchBlock waits for a slow subscriber to make room, holding up every
+	// write behind it. A subscriber must not write to the database while it
+	// has unread events.
+	WatchBlock
+)
+
+// defaultWatchBuffer is the number of events buffered per subscriber when
+// none is given.
+const defaultWatchBuffer = 1024
+
+// WithWatchBuffer sets how many events are buffered for each Watch
+// subscriber and what happens once a subscriber's buffer is full. It's 1024
+// events and WatchDrop by default.
+func WithWatchBuffer(size int, policy WatchPolicy) Option {
+	return func(db *Database) {
+		db.watchBuffer = size
+		db.watchPolicy = policy
+	}
+}
+
+// Watch subscribes to the writes to keys starting with prefix, an empty
+// prefix watching every key. Events are delivered in log order once their
+// record is durable, so under SyncEvery they arrive with the flush that syncs
+// them, and under SyncNone, which leaves syncing to Close, only once the
+// database is closed. Writes to a database without a log are delivered
+// right away. The returned func cancels the subscription and closes the
+// channel.
+func (db *Database) Watch(prefix string) (<-chan ChangeEvent, func()) {
+	sub := &watcher{
+		prefix: prefix,
+		events: make(chan ChangeEvent, db.watchBuffer),
+		done:   make(chan struct{}),
+	}
+
+	h := &db.watchers
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*watcher]bool)
+	}
+	h.subs[sub] = true
+	h.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			// Unblock a publisher waiting on this subscriber before taking
+			// the lock it holds
+			close(sub.done)
+			h.mu.Lock()
+			delete(h.subs, sub)
+			close(sub.events)
+			h.mu.Unlock()
+		})
+	}
+	return sub.events, cancel
+}
+
+// watchHub holds the Watch subscribers and the events waiting for their
+// records to be synced.
+type watchHub struct {
+	mu      sync.Mutex
+	subs    map[*watcher]bool
+	pending []pendingEvent
+}
+
+type watcher struct {
+	prefix string
+	events chan ChangeEvent
+	done   chan struct{}
+}
+
+type pendingEvent struct {
+	seq   uint64
+	event ChangeEvent
+}
+
+// stageWatched queues events for records appended to the log, the last of
+// them having sequence number lastSeq, until they're synced. Callers must hold
+// logFileLock.
+func (db *Database) stageWatched(lastSeq uint64, entries []*contract.LogEntry) {
+	h := &db.watchers
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+
+	first := lastSeq - uint64(len(entries)) + 1
+	for i, entry := range entries {
+		event, ok := changeEvent(entry)
+		if ok {
+			h.pending = append(h.pending, pendingEvent{seq: first + uint64(i), event: event})
+		}
+	}
+}
+
+// releaseWatched delivers the staged events of every record up to synced.
+// Callers must hold logFileLock, which keeps deliveries in log order.
+func (db *Database) releaseWatched(synced uint64) {
+	h := &db.watchers
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for n < len(h.pending) && h.pending[n].seq <= synced {
+		h.deliver(h.pending[n].event, db.watchPolicy)
+		n++
+	}
+	h.pending = append(h.pending[:0], h.pending[n:]...)
+}
+
+// publishWatched delivers events for records that won't be synced because
+// there is no log. Callers must hold logFileLock.
+func (db *Database) publishWatched(entries []*contract.LogEntry) {
+	h := &db.watchers
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, entry := range entries {
+		event, ok := changeEvent(entry)
+		if ok {
+			h.deliver(event, db.watchPolicy)
+		}
+	}
+}
+
+// changeEvent returns the event for the write logged by entry, false for the
+// records that don't write a key, like batch markers. The event of a MERGE
+// carries the operand.
+func changeEvent(entry *contract.LogEntry) (ChangeEvent, bool) {
+	switch entry.Op {
+	case INSERT, UPDATE, DELETE, MERGE:
+		return ChangeEvent{
+			Op:        entry.Op,
+			Key:       entry.Key,
+			Value:     entry.Value,
+			Version:   entry.Version,
+			Timestamp: writeTime(entry.Timestamp),
+			Metadata:  copyMetadata(entry.Metadata),
+		}, true
+	default:
+		return ChangeEvent{}, false
+	}
+}
+
+// deliver sends event to every subscriber watching its key. Callers must hold
+// h.mu.
+func (h *watchHub) deliver(event ChangeEvent, policy WatchPolicy) {
+	for sub := range h.subs {
+		if !strings.HasPrefix(event.Key, sub.prefix) {
+			continue
+		}
+		if policy == WatchBlock {
+			select {
+			case sub.events <- event:
+			case <-sub.done:
+			}
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}